@@ -0,0 +1,405 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// get all accounts belonging to user, with their current balances
+func (app *application) AllAccounts(w http.ResponseWriter, r *http.Request) {
+	log.Printf("AllAccounts endpoint hit\n")
+	userID, err := app.getUserIDFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	accounts, err := app.DB.AllAccounts(userID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, accounts)
+}
+
+// get one account belonging to user, with its current balance
+func (app *application) OneAccount(w http.ResponseWriter, r *http.Request) {
+	log.Printf("OneAccount endpoint hit\n")
+	userID, err := app.getUserIDFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	account, err := app.DB.OneAccount(id, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("account not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, account)
+}
+
+// get the transactions of one account, each with the running balance after it
+func (app *application) AccountLedger(w http.ResponseWriter, r *http.Request) {
+	log.Printf("AccountLedger endpoint hit\n")
+	userID, err := app.getUserIDFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	ledger, err := app.DB.AccountLedger(id, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("account not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, ledger)
+}
+
+// insert one account
+func (app *application) InsertAccount(w http.ResponseWriter, r *http.Request) {
+	log.Printf("InsertAccount endpoint hit\n")
+	userID, err := app.getUserIDFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	var account models.Account
+	err = app.readJSON(w, r, &account)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// an account without a currency is held in the user's base currency
+	if account.Currency == "" {
+		user, err := app.DB.GetUserByID(userID)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		account.Currency = user.BaseCurrency
+	}
+
+	err = validateAccount(&account)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	account.UserID = userID
+	account.CreatedAt = time.Now()
+	account.UpdatedAt = time.Now()
+
+	_, err = app.repo(r).InsertAccount(&account)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "account inserted",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// update one account; only the fields present in the payload are changed
+func (app *application) UpdateAccount(w http.ResponseWriter, r *http.Request) {
+	log.Printf("UpdateAccount endpoint hit\n")
+	userID, err := app.getUserIDFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	account, err := app.DB.OneAccount(id, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("account not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// decode the payload over the stored record, so omitted fields keep their values
+	err = app.readJSON(w, r, account)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = validateAccount(account)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	account.ID = id
+	account.UserID = userID
+	account.UpdatedAt = time.Now()
+
+	err = app.repo(r).UpdateAccount(account)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("account not found"), http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, repository.ErrInUse) {
+			app.errorJSON(w, errors.New("account has transactions; its currency cannot change"), http.StatusConflict)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "account updated",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// delete one account; its incomes and expenses are kept, unassigned
+func (app *application) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	log.Printf("DeleteAccount endpoint hit\n")
+	userID, err := app.getUserIDFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.repo(r).DeleteAccount(id, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("account not found"), http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, repository.ErrInUse) {
+			app.errorJSON(w, errors.New("account has transfers; delete them first"), http.StatusConflict)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "account deleted",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// get all transfers belonging to user
+func (app *application) AllTransfers(w http.ResponseWriter, r *http.Request) {
+	log.Printf("AllTransfers endpoint hit\n")
+	userID, err := app.getUserIDFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	transfers, err := app.DB.AllTransfers(userID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, transfers)
+}
+
+// insert one transfer between two of the user's accounts
+func (app *application) InsertTransfer(w http.ResponseWriter, r *http.Request) {
+	log.Printf("InsertTransfer endpoint hit\n")
+	userID, err := app.getUserIDFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	var transfer models.Transfer
+	err = app.readJSON(w, r, &transfer)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if transfer.FromAccountID == transfer.ToAccountID {
+		app.errorJSON(w, errors.New("cannot transfer to the same account"))
+		return
+	}
+
+	if transfer.Amount <= 0 {
+		app.errorJSON(w, errors.New("amount must be positive"))
+		return
+	}
+
+	if transfer.Date.IsZero() {
+		app.errorJSON(w, errors.New("date is required"))
+		return
+	}
+
+	from, err := app.DB.OneAccount(transfer.FromAccountID, userID)
+	if err != nil {
+		app.errorJSON(w, errors.New("unknown from account"))
+		return
+	}
+
+	to, err := app.DB.OneAccount(transfer.ToAccountID, userID)
+	if err != nil {
+		app.errorJSON(w, errors.New("unknown to account"))
+		return
+	}
+
+	if from.Currency != to.Currency {
+		app.errorJSON(w, errors.New("accounts are held in different currencies"))
+		return
+	}
+
+	transfer.UserID = userID
+	transfer.CreatedAt = time.Now()
+	transfer.UpdatedAt = time.Now()
+
+	_, err = app.repo(r).InsertTransfer(&transfer)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "transfer inserted",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// delete one transfer
+func (app *application) DeleteTransfer(w http.ResponseWriter, r *http.Request) {
+	log.Printf("DeleteTransfer endpoint hit\n")
+	userID, err := app.getUserIDFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.repo(r).DeleteTransfer(id, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("transfer not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "transfer deleted",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// validateAccount checks the user-supplied fields of an account, normalizing its currency.
+func validateAccount(account *models.Account) error {
+	if account.Name == "" {
+		return errors.New("account name is required")
+	}
+
+	valid := false
+	for _, t := range models.AccountTypes {
+		if account.Type == t {
+			valid = true
+		}
+	}
+	if !valid {
+		return errors.New("unknown account type")
+	}
+
+	currency, err := models.NormalizeCurrency(account.Currency)
+	if err != nil {
+		return err
+	}
+	account.Currency = currency
+
+	return nil
+}
+
+// checkAccountOwner makes sure an income or expense is only assigned to one of the
+// user's own accounts, and, if it has a currency, only to one in that currency. Without
+// one it takes the account's.
+func (app *application) checkAccountOwner(userID int, accountID *int, currency string) error {
+	if accountID == nil {
+		return nil
+	}
+
+	account, err := app.DB.OneAccount(*accountID, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errors.New("unknown account")
+		}
+		return err
+	}
+
+	if currency != "" && currency != account.Currency {
+		return fmt.Errorf("currency must be the account's, %s", account.Currency)
+	}
+
+	return nil
+}
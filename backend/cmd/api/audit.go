@@ -0,0 +1,100 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// maxRequestIDLength is how much of a request id is kept in the audit log.
+const maxRequestIDLength = 64
+
+// auditEntities lists the kinds of record whose history can be browsed.
+var auditEntities = map[string]bool{
+	models.AuditIncome:              true,
+	models.AuditExpense:             true,
+	models.AuditSource:              true,
+	models.AuditCategory:            true,
+	models.AuditAccount:             true,
+	models.AuditTransfer:            true,
+	models.AuditBudget:              true,
+	models.AuditGoal:                true,
+	models.AuditGoalContribution:    true,
+	models.AuditRecurring:           true,
+	models.AuditRecurringOccurrence: true,
+	models.AuditImportMapping:       true,
+	models.AuditHousehold:           true,
+	models.AuditHouseholdMember:     true,
+	models.AuditHouseholdInvite:     true,
+}
+
+// repo returns the repository a request's writes go through, which records them in the
+// audit log as made by the signed-in user, with the request's details. Requests without
+// a user write as the system.
+func (app *application) repo(r *http.Request) repository.DatabaseRepo {
+	userID, err := app.getUserIDFromContext(r)
+	if err != nil {
+		return app.DB
+	}
+
+	actor := models.AuditActor{
+		UserID:    userID,
+		IPAddress: clientIP(r),
+		UserAgent: userAgent(r),
+		RequestID: requestID(r),
+		Method:    r.Method,
+		Path:      r.URL.Path,
+	}
+	if token, ok := r.Context().Value(accessTokenKey).(*models.AccessToken); ok {
+		actor.AccessTokenID = &token.ID
+	}
+
+	return app.DB.WithActor(actor)
+}
+
+// requestID returns the id of the request as valid UTF-8, cut to maxRequestIDLength
+// bytes. Clients may send their own, so it is not to be trusted to fit.
+func requestID(r *http.Request) string {
+	id := middleware.GetReqID(r.Context())
+	if len(id) > maxRequestIDLength {
+		id = id[:maxRequestIDLength]
+	}
+	return strings.ToValidUTF8(id, "")
+}
+
+// get the history of one record belonging to the household, or to the user
+func (app *application) AuditHistory(w http.ResponseWriter, r *http.Request) {
+	log.Printf("AuditHistory endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	entity := chi.URLParam(r, "entity")
+	if !auditEntities[entity] {
+		app.errorJSON(w, errors.New("unknown record type"), http.StatusNotFound)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	entries, err := app.DB.AuditHistory(entity, id, member.HouseholdID, member.UserID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, entries)
+}
@@ -19,6 +19,7 @@ type Auth struct {
 	CookieDomain  string
 	CookiePath    string
 	CookieName    string
+	Keys          []SigningKey // The first signs; all verify. HS256 with Secret if empty
 }
 
 type jwtUser struct {
@@ -37,8 +38,16 @@ type Claims struct {
 }
 
 func (j *Auth) GenerateTokenPair(user *jwtUser) (TokenPairs, error) {
-	// Create a token
-	token := jwt.New(jwt.SigningMethodHS256)
+	// Create a token, signed with the current key if there are keys
+	var token *jwt.Token
+	var signingKey interface{} = []byte(j.Secret)
+	if len(j.Keys) > 0 {
+		token = jwt.New(j.Keys[0].Method)
+		token.Header["kid"] = j.Keys[0].ID
+		signingKey = j.Keys[0].Private
+	} else {
+		token = jwt.New(jwt.SigningMethodHS256)
+	}
 
 	// Set the claims
 	claims := token.Claims.(jwt.MapClaims)
@@ -53,22 +62,14 @@ func (j *Auth) GenerateTokenPair(user *jwtUser) (TokenPairs, error) {
 	claims["exp"] = time.Now().UTC().Add(j.TokenExpiry).Unix()
 
 	// Create a signed token
-	signedAccessToken, err := token.SignedString([]byte(j.Secret))
+	signedAccessToken, err := token.SignedString(signingKey)
 	if err != nil {
 		return TokenPairs{}, err
 	}
 
-	// Create a refresh token and set claims
-	refreshToken := jwt.New(jwt.SigningMethodHS256)
-	refreshTokenClaims := refreshToken.Claims.(jwt.MapClaims)
-	refreshTokenClaims["sub"] = fmt.Sprint(user.ID)
-	refreshTokenClaims["iat"] = time.Now().UTC().Unix()
-
-	// Set the expiry for the refresh token
-	refreshTokenClaims["exp"] = time.Now().UTC().Add(j.RefreshExpiry).Unix()
-
-	// Create signed refresh token
-	signedRefreshToken, err := refreshToken.SignedString([]byte(j.Secret))
+	// Create a refresh token; it is random rather than signed, as it is only good for
+	// as long as its session is stored
+	refreshToken, err := generateToken()
 	if err != nil {
 		return TokenPairs{}, err
 	}
@@ -76,7 +77,7 @@ func (j *Auth) GenerateTokenPair(user *jwtUser) (TokenPairs, error) {
 	// Create TokenPairs and populate with signed tokens
 	var tokenPairs = TokenPairs {
 		Token: signedAccessToken,
-		RefreshToken: signedRefreshToken,
+		RefreshToken: refreshToken,
 	}
 
 	// Return TokenPairs
@@ -139,12 +140,7 @@ func (j *Auth) GetTokenFromHeaderAndVerify(w http.ResponseWriter, r *http.Reques
 	claims := &Claims{}
 
 	// parse the token
-	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error){
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(j.Secret), nil
-	})
+	_, err := jwt.ParseWithClaims(token, claims, j.verificationKey)
 
 	if err != nil {
 		if strings.HasPrefix(err.Error(), "token is expired by") {
@@ -157,5 +153,32 @@ func (j *Auth) GetTokenFromHeaderAndVerify(w http.ResponseWriter, r *http.Reques
 		return "", nil, errors.New("invalid issuer")
 	}
 
+	if !claims.VerifyAudience(j.Audience, true) {
+		return "", nil, errors.New("invalid audience")
+	}
+
 	return token, claims, nil
-}
\ No newline at end of file
+}
+
+// verificationKey returns the key to verify token with: the one its kid names, which must
+// be for the algorithm it was signed with, or without keys, the secret.
+func (j *Auth) verificationKey(token *jwt.Token) (interface{}, error) {
+	if len(j.Keys) == 0 {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(j.Secret), nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	for _, k := range j.Keys {
+		if k.ID == kid {
+			if token.Method.Alg() != k.Method.Alg() {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return k.Public, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
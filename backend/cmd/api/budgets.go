@@ -0,0 +1,268 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxBudgetMonths bounds how many months one budget report may cover.
+const maxBudgetMonths = 36
+
+// get all budgets belonging to the household
+func (app *application) AllBudgets(w http.ResponseWriter, r *http.Request) {
+	log.Printf("AllBudgets endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	budgets, err := app.DB.AllBudgets(member.HouseholdID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, budgets)
+}
+
+// insert one budget
+func (app *application) InsertBudget(w http.ResponseWriter, r *http.Request) {
+	log.Printf("InsertBudget endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	var budget models.Budget
+	err = app.readJSON(w, r, &budget)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = validateBudget(&budget)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	budget.UserID = member.UserID
+	budget.HouseholdID = member.HouseholdID
+	budget.CreatedAt = time.Now()
+	budget.UpdatedAt = time.Now()
+	budget.Category.UserID = member.UserID
+	budget.Category.HouseholdID = member.HouseholdID
+	budget.Category.CreatedAt = time.Now()
+	budget.Category.UpdatedAt = time.Now()
+
+	_, err = app.repo(r).InsertBudget(&budget)
+	if err != nil {
+		if errors.Is(err, repository.ErrDuplicate) {
+			app.errorJSON(w, errors.New("category already has a budget"), http.StatusConflict)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "budget inserted",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// update one budget; only the fields present in the payload are changed
+func (app *application) UpdateBudget(w http.ResponseWriter, r *http.Request) {
+	log.Printf("UpdateBudget endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	budget, err := app.DB.OneBudget(id, member.HouseholdID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("budget not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// the member who set it keeps it, whoever in the household changes it
+	setBy := budget.UserID
+
+	// decode the payload over the stored record, so omitted fields keep their values
+	err = app.readJSON(w, r, budget)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = validateBudget(budget)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	budget.ID = id
+	budget.UserID = setBy
+	budget.HouseholdID = member.HouseholdID
+	budget.UpdatedAt = time.Now()
+	budget.Category.UserID = member.UserID
+	budget.Category.HouseholdID = member.HouseholdID
+	budget.Category.CreatedAt = time.Now()
+	budget.Category.UpdatedAt = time.Now()
+
+	err = app.repo(r).UpdateBudget(budget)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("budget not found"), http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, repository.ErrDuplicate) {
+			app.errorJSON(w, errors.New("category already has a budget"), http.StatusConflict)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "budget updated",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// delete one budget
+func (app *application) DeleteBudget(w http.ResponseWriter, r *http.Request) {
+	log.Printf("DeleteBudget endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.repo(r).DeleteBudget(id, member.HouseholdID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("budget not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "budget deleted",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// compare every budget against actual spending, for the current month and the months
+// before it; ?months=N picks how many months (default 6)
+func (app *application) BudgetReport(w http.ResponseWriter, r *http.Request) {
+	log.Printf("BudgetReport endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	months := 6
+	if v := r.URL.Query().Get("months"); v != "" {
+		months, err = strconv.Atoi(v)
+		if err != nil || months < 1 || months > maxBudgetMonths {
+			app.errorJSON(w, errors.New("months must be between 1 and 36"))
+			return
+		}
+	}
+
+	budgets, err := app.DB.AllBudgets(member.HouseholdID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// whole months, the current one through its last day
+	start := models.TrailingMonths(months).From
+
+	spent, err := app.DB.GetMonthlyExpensesByCategory(member.HouseholdID, models.Period{
+		From: start,
+		To:   start.AddDate(0, months, -1),
+	})
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	report := []*models.BudgetMonth{}
+
+	for _, spentByCategory := range spent {
+		period := models.Period{
+			From: start,
+			To:   models.Month.Next(start).AddDate(0, 0, -1),
+		}
+
+		month := models.BudgetMonth{
+			Month:   models.Month.Label(start),
+			Period:  period,
+			Budgets: []*models.BudgetStatus{},
+		}
+
+		for _, budget := range budgets {
+			status := budget.Status(spentByCategory[budget.Category.Name])
+			month.TotalLimit += status.Limit
+			month.TotalSpent += status.Spent
+			month.Budgets = append(month.Budgets, status)
+		}
+
+		// most recent month first
+		report = append([]*models.BudgetMonth{&month}, report...)
+		start = models.Month.Next(start)
+	}
+
+	app.writeJSON(w, http.StatusOK, report)
+}
+
+// validateBudget checks the user-supplied fields of a budget.
+func validateBudget(budget *models.Budget) error {
+	if budget.Category == nil || budget.Category.Name == "" {
+		return errors.New("category name is required")
+	}
+
+	if budget.Amount <= 0 {
+		return errors.New("amount must be positive")
+	}
+
+	return nil
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"backend/internal/rates"
 	"database/sql"
 	"log"
+	"os"
 
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
@@ -31,4 +33,27 @@ func (app *application) connectToDB() (*sql.DB, error) {
 
 	log.Println("Connected to Postgres!")
 	return connection, nil
-}
\ No newline at end of file
+}
+
+// loadExchangeRates stores the rates in the CSV file at path, replacing rates already
+// stored for the same currencies and dates.
+func (app *application) loadExchangeRates(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fx, err := rates.ReadCSV(f)
+	if err != nil {
+		return err
+	}
+
+	n, err := app.DB.UpsertExchangeRates(fx)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Loaded %d exchange rates from %s\n", n, path)
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"backend/internal/export"
+	"backend/internal/models"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// export all of the household's sources, categories, incomes and expenses, streamed as
+// they are read; format=csv (a zip of one file per table), json or xlsx. The xlsx
+// workbook also holds a monthly summary, in the household's base currency
+func (app *application) ExportData(w http.ResponseWriter, r *http.Request) {
+	log.Printf("ExportData endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = export.JSON
+	}
+
+	// every writer buffers, so nothing reaches the response before the headers are set
+	ew, err := export.NewWriter(w, format)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	sources, err := app.DB.AllSources(member.HouseholdID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	categories, err := app.DB.AllCategories(member.HouseholdID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	contentType, extension := export.ContentType(format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="finances-%s.%s"`, time.Now().Format("2006-01-02"), extension))
+	w.WriteHeader(http.StatusOK)
+
+	// once the export has started, an error can only cut it short
+	err = app.exportTables(ew, member.HouseholdID, format, sources, categories)
+	if err == nil {
+		err = ew.Close()
+	}
+	if err != nil {
+		log.Printf("Error exporting data for household %d: %v\n", member.HouseholdID, err)
+	}
+}
+
+// exportTables writes every table of an export, the transactions straight from the
+// database, and for xlsx the monthly summary of the months they span.
+func (app *application) exportTables(ew export.Writer, householdID int, format string, sources []*models.Source, categories []*models.Category) error {
+	err := ew.Table("sources", []string{"id", "name"})
+	if err != nil {
+		return err
+	}
+	for _, s := range sources {
+		err = ew.Row(s.ID, s.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = ew.Table("categories", []string{"id", "name"})
+	if err != nil {
+		return err
+	}
+	for _, c := range categories {
+		err = ew.Row(c.ID, c.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the summary starts with the month of the oldest transaction
+	first := models.Today()
+
+	err = ew.Table("incomes", []string{"id", "date", "amount", "currency", "source_id", "source", "account_id",
+		"description", "external_id"})
+	if err != nil {
+		return err
+	}
+	err = app.DB.EachIncome(householdID, func(i *models.Income) error {
+		if i.Date.Before(first) {
+			first = i.Date
+		}
+		return ew.Row(i.ID, i.Date, i.Amount, i.Currency, i.SourceID, i.Source.Name, i.AccountID,
+			i.Description, i.ExternalID)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = ew.Table("expenses", []string{"id", "date", "amount", "currency", "category_id", "category", "account_id",
+		"description", "payment_method", "external_id"})
+	if err != nil {
+		return err
+	}
+	err = app.DB.EachExpense(householdID, func(e *models.Expense) error {
+		if e.Date.Before(first) {
+			first = e.Date
+		}
+		return ew.Row(e.ID, e.Date, e.Amount, e.Currency, e.CategoryID, e.Category.Name, e.AccountID,
+			e.Description, e.PaymentMethod, e.ExternalID)
+	})
+	if err != nil {
+		return err
+	}
+
+	if format != export.XLSX {
+		return nil
+	}
+
+	period := models.Period{From: models.Month.Truncate(first), To: models.Today()}
+	if period.Buckets(models.Month) > models.MaxSummaryBuckets {
+		period.From = models.Month.Truncate(period.To).AddDate(0, -(models.MaxSummaryBuckets - 1), 0)
+	}
+
+	summary, err := app.DB.GetFinancialSummary(householdID, period, models.Month)
+	if err != nil {
+		return err
+	}
+
+	err = ew.Table("summary", []string{"month", "start", "currency", "income", "expenses", "net_income"})
+	if err != nil {
+		return err
+	}
+	for _, m := range summary.Months {
+		err = ew.Row(m.Label, m.Start, summary.BaseCurrency, m.IncomeSum, m.ExpenseSum, m.NetIncome)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
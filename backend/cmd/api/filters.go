@@ -0,0 +1,182 @@
+package main
+
+import (
+	"backend/internal/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// readTransactionFilter builds a listing filter from the query string of a request to
+// /admin/incomes or /admin/expenses. It understands:
+//
+//	from, to                date range, as YYYY-MM-DD, inclusive
+//	min_amount, max_amount  amount range, inclusive
+//	source_id, category_id  repeated or comma separated ids
+//	payment_method          exact payment method, expenses only
+//	q                       substring of the description
+//	sort, order             one of models.SortFields, and asc or desc (default date desc)
+//	limit, cursor           page size, and the next_cursor of the previous page
+func (app *application) readTransactionFilter(r *http.Request) (models.TransactionFilter, error) {
+	qs := r.URL.Query()
+	filter := models.TransactionFilter{
+		SortField:     "date",
+		SortDesc:      true,
+		PaymentMethod: qs.Get("payment_method"),
+		Description:   qs.Get("q"),
+	}
+
+	var err error
+
+	if filter.From, err = parseDateParam(qs.Get("from"), "from"); err != nil {
+		return filter, err
+	}
+	if filter.To, err = parseDateParam(qs.Get("to"), "to"); err != nil {
+		return filter, err
+	}
+	if filter.MinAmount, err = parseAmountParam(qs.Get("min_amount"), "min_amount"); err != nil {
+		return filter, err
+	}
+	if filter.MaxAmount, err = parseAmountParam(qs.Get("max_amount"), "max_amount"); err != nil {
+		return filter, err
+	}
+	if filter.SourceIDs, err = parseIDsParam(qs["source_id"], "source_id"); err != nil {
+		return filter, err
+	}
+	if filter.CategoryIDs, err = parseIDsParam(qs["category_id"], "category_id"); err != nil {
+		return filter, err
+	}
+
+	if sort := qs.Get("sort"); sort != "" {
+		valid := false
+		for _, f := range models.SortFields {
+			if sort == f {
+				valid = true
+			}
+		}
+		if !valid {
+			return filter, fmt.Errorf("sort must be one of %s", strings.Join(models.SortFields, ", "))
+		}
+		filter.SortField = sort
+	}
+
+	switch qs.Get("order") {
+	case "":
+	case "asc":
+		filter.SortDesc = false
+	case "desc":
+		filter.SortDesc = true
+	default:
+		return filter, errors.New("order must be asc or desc")
+	}
+
+	if limit := qs.Get("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit < 1 || filter.Limit > models.MaxPageSize {
+			return filter, fmt.Errorf("limit must be between 1 and %d", models.MaxPageSize)
+		}
+	}
+
+	if token := qs.Get("cursor"); token != "" {
+		filter.After, err = models.DecodeCursor(token)
+		if err != nil {
+			return filter, err
+		}
+		if filter.After.Sort != filter.SortField || filter.After.Desc != filter.SortDesc {
+			return filter, errors.New("cursor does not match the requested sort")
+		}
+	}
+
+	return filter, nil
+}
+
+// readSummaryPeriod reads the span and bucket size of a request to /admin/summary from
+// its query string:
+//
+//	from, to     period to break down, as YYYY-MM-DD, inclusive (default: the trailing 12 months)
+//	granularity  week, month, quarter or year (default month)
+func (app *application) readSummaryPeriod(r *http.Request) (models.Period, models.Granularity, error) {
+	qs := r.URL.Query()
+	period := models.TrailingMonths(12)
+	granularity := models.Month
+
+	from, err := parseDateParam(qs.Get("from"), "from")
+	if err != nil {
+		return period, granularity, err
+	}
+	if from != nil {
+		period.From = *from
+	}
+
+	to, err := parseDateParam(qs.Get("to"), "to")
+	if err != nil {
+		return period, granularity, err
+	}
+	if to != nil {
+		period.To = *to
+	}
+
+	if g := qs.Get("granularity"); g != "" {
+		granularity, err = models.ParseGranularity(g)
+		if err != nil {
+			return period, granularity, err
+		}
+	}
+
+	if period.To.Before(period.From) {
+		return period, granularity, errors.New("from must not be after to")
+	}
+
+	if period.Buckets(granularity) > models.MaxSummaryBuckets {
+		return period, granularity, fmt.Errorf("period spans more than %d %ss", models.MaxSummaryBuckets, granularity)
+	}
+
+	return period, granularity, nil
+}
+
+func parseDateParam(value, name string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a date formatted as YYYY-MM-DD", name)
+	}
+
+	return &t, nil
+}
+
+func parseAmountParam(value, name string) (*models.Money, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	m, err := models.ParseMoney(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an amount with at most two decimals", name)
+	}
+
+	return &m, nil
+}
+
+func parseIDsParam(values []string, name string) ([]int, error) {
+	var ids []int
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			if part == "" {
+				continue
+			}
+			id, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be a list of ids", name)
+			}
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, nil
+}
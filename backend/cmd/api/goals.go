@@ -0,0 +1,426 @@
+package main
+
+import (
+	"backend/internal/models"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// projectionMonths is how many full months of net income a goal projection averages.
+const projectionMonths = 12
+
+// get all goals belonging to the household, with the amount saved so far
+func (app *application) AllGoals(w http.ResponseWriter, r *http.Request) {
+	log.Printf("AllGoals endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	goals, err := app.DB.AllGoals(member.HouseholdID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, goals)
+}
+
+// get one goal belonging to the household, with the amount saved so far
+func (app *application) OneGoal(w http.ResponseWriter, r *http.Request) {
+	log.Printf("OneGoal endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	goal, err := app.DB.OneGoal(id, member.HouseholdID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("goal not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, goal)
+}
+
+// insert one goal
+func (app *application) InsertGoal(w http.ResponseWriter, r *http.Request) {
+	log.Printf("InsertGoal endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	var goal models.Goal
+	err = app.readJSON(w, r, &goal)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = validateGoal(&goal)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.checkAccountOwner(member.UserID, goal.AccountID, "")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	goal.UserID = member.UserID
+	goal.HouseholdID = member.HouseholdID
+	goal.CreatedAt = time.Now()
+	goal.UpdatedAt = time.Now()
+	if goal.Category != nil {
+		goal.Category.UserID = member.UserID
+		goal.Category.HouseholdID = member.HouseholdID
+		goal.Category.CreatedAt = time.Now()
+		goal.Category.UpdatedAt = time.Now()
+	}
+
+	_, err = app.repo(r).InsertGoal(&goal)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "goal inserted",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// update one goal; only the fields present in the payload are changed
+func (app *application) UpdateGoal(w http.ResponseWriter, r *http.Request) {
+	log.Printf("UpdateGoal endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	goal, err := app.DB.OneGoal(id, member.HouseholdID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("goal not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// the member who set it keeps it, whoever in the household changes it
+	setBy := goal.UserID
+
+	categoryName := ""
+	if goal.Category != nil {
+		categoryName = goal.Category.Name
+	}
+
+	// decode the payload over the stored record, so omitted fields keep their values
+	err = app.readJSON(w, r, goal)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// a null category or category_id unlinks the category, unless another one is named
+	if goal.CategoryID == nil && goal.Category != nil && goal.Category.Name == categoryName {
+		goal.Category = nil
+	}
+	if goal.Category == nil {
+		goal.CategoryID = nil
+	}
+
+	err = validateGoal(goal)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.checkAccountOwner(setBy, goal.AccountID, "")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	goal.ID = id
+	goal.UserID = setBy
+	goal.HouseholdID = member.HouseholdID
+	goal.UpdatedAt = time.Now()
+	if goal.Category != nil {
+		goal.Category.UserID = member.UserID
+		goal.Category.HouseholdID = member.HouseholdID
+		goal.Category.CreatedAt = time.Now()
+		goal.Category.UpdatedAt = time.Now()
+	}
+
+	err = app.repo(r).UpdateGoal(goal)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("goal not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "goal updated",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// delete one goal, with its contributions
+func (app *application) DeleteGoal(w http.ResponseWriter, r *http.Request) {
+	log.Printf("DeleteGoal endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.repo(r).DeleteGoal(id, member.HouseholdID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("goal not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "goal deleted",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// get the contributions to one goal
+func (app *application) AllGoalContributions(w http.ResponseWriter, r *http.Request) {
+	log.Printf("AllGoalContributions endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	_, err = app.DB.OneGoal(id, member.HouseholdID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("goal not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	contributions, err := app.DB.AllGoalContributions(id, member.HouseholdID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, contributions)
+}
+
+// record one contribution to a goal
+func (app *application) InsertGoalContribution(w http.ResponseWriter, r *http.Request) {
+	log.Printf("InsertGoalContribution endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var contribution models.GoalContribution
+	err = app.readJSON(w, r, &contribution)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if contribution.Amount <= 0 {
+		app.errorJSON(w, errors.New("amount must be positive"))
+		return
+	}
+
+	if contribution.Date.IsZero() {
+		app.errorJSON(w, errors.New("date is required"))
+		return
+	}
+
+	contribution.UserID = member.UserID
+	contribution.GoalID = id
+	contribution.CreatedAt = time.Now()
+	contribution.UpdatedAt = time.Now()
+
+	_, err = app.repo(r).InsertGoalContribution(member.HouseholdID, &contribution)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("goal not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "contribution inserted",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// delete one contribution to a goal
+func (app *application) DeleteGoalContribution(w http.ResponseWriter, r *http.Request) {
+	log.Printf("DeleteGoalContribution endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	contributionID, err := strconv.Atoi(chi.URLParam(r, "contributionID"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.repo(r).DeleteGoalContribution(contributionID, id, member.HouseholdID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("contribution not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "contribution deleted",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// report the progress of one goal: what is left to save, the monthly contribution needed
+// to reach it in time, and when it will be reached at the household's average net income over
+// the last full months
+func (app *application) GoalProgress(w http.ResponseWriter, r *http.Request) {
+	log.Printf("GoalProgress endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	goal, err := app.DB.OneGoal(id, member.HouseholdID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("goal not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// leave the current month out; it is only partly over
+	today := models.Today()
+	thisMonth := models.Month.Truncate(today)
+	period := models.Period{
+		From: thisMonth.AddDate(0, -projectionMonths, 0),
+		To:   thisMonth.AddDate(0, 0, -1),
+	}
+
+	summary, err := app.DB.GetFinancialSummary(member.HouseholdID, period, models.Month)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, goal.Progress(summary.AverageNetIncome(), today))
+}
+
+// validateGoal checks the user-supplied fields of a goal.
+func validateGoal(goal *models.Goal) error {
+	if goal.Name == "" {
+		return errors.New("goal name is required")
+	}
+
+	if goal.TargetAmount <= 0 {
+		return errors.New("target amount must be positive")
+	}
+
+	if goal.TargetDate.IsZero() {
+		return errors.New("target date is required")
+	}
+
+	return nil
+}
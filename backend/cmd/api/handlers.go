@@ -3,6 +3,8 @@ package main
 import (
 	"backend/internal/graph"
 	"backend/internal/models"
+	"backend/internal/repository"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,7 +16,6 @@ import (
 	"time"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/golang-jwt/jwt/v4"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -51,6 +52,16 @@ func (app *application) signup(w http.ResponseWriter, r *http.Request) {
 			return
 	}
 
+	// summaries are converted into the base currency
+	if user.BaseCurrency == "" {
+		user.BaseCurrency = models.DefaultCurrency
+	}
+	user.BaseCurrency, err = models.NormalizeCurrency(user.BaseCurrency)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
 	// encrypt password
 	bytes, err := bcrypt.GenerateFromPassword([]byte(user.Password), 14)
 	if err != nil {
@@ -68,6 +79,10 @@ func (app *application) signup(w http.ResponseWriter, r *http.Request) {
 			return
 	}
 
+	// confirm the address is real
+	user.ID = newID
+	app.sendVerification(&user)
+
 	// create a jwt user
 	u := jwtUser{
 		ID:        newID,
@@ -75,20 +90,18 @@ func (app *application) signup(w http.ResponseWriter, r *http.Request) {
 		LastName:  user.LastName,
 	}
 
-	// generate tokens
-	tokens, err := app.auth.GenerateTokenPair(&u)
+	// generate tokens, and start a session for the refresh token
+	tokens, err := app.startSession(w, r, &u)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	refreshCookie := app.auth.GetRefreshCookie(tokens.RefreshToken)
-	http.SetCookie(w, refreshCookie)
-
 	app.writeJSON(w, http.StatusAccepted, tokens)
 }
 
-// authenticate authenticates a user when they try to log in, and returns a JWT.
+// authenticate authenticates a user when they try to log in, and returns a JWT, or an
+// MFAChallenge if they have two-factor authentication on.
 func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 	log.Printf("authenticate endpoint hit\n")
 	// read json payload
@@ -103,9 +116,15 @@ func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// slow down guessing, from one address and against one account
+	if !app.checkLoginLimits(w, r, requestPayload.Email) {
+		return
+	}
+
 	// validate user against database
 	user, err := app.DB.GetUserByEmail(requestPayload.Email)
 	if err != nil {
+		app.loginFailed(r, requestPayload.Email, nil, models.LoginInvalidCredentials)
 		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
 		return
 	}
@@ -113,9 +132,20 @@ func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 	// check password
 	valid, err := user.PasswordMatches(requestPayload.Password)
 	if err != nil || !valid {
+		app.loginFailed(r, requestPayload.Email, &user.ID, models.LoginInvalidCredentials)
 		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
 		return
 	}
+	app.loginPassed(r, requestPayload.Email)
+
+	// with two-factor authentication on, the password only earns a challenge, and tokens
+	// come from /authenticate/mfa with a code; failures are kept until then
+	if user.TOTPEnabledAt != nil {
+		app.recordLogin(r, requestPayload.Email, &user.ID, models.LoginMFARequired)
+		app.writeJSON(w, http.StatusAccepted, app.mfaChallenge(user))
+		return
+	}
+	app.loginSucceeded(r, requestPayload.Email, user.ID, models.LoginSucceeded)
 
 	// create a jwt user
 	u := jwtUser{
@@ -124,94 +154,114 @@ func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 		LastName:  user.LastName,
 	}
 
-	// generate tokens
-	tokens, err := app.auth.GenerateTokenPair(&u)
+	// generate tokens, and start a session for the refresh token
+	tokens, err := app.startSession(w, r, &u)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	refreshCookie := app.auth.GetRefreshCookie(tokens.RefreshToken)
-	http.SetCookie(w, refreshCookie)
-
 	app.writeJSON(w, http.StatusAccepted, tokens)
 }
 
-// refreshToken checks for a valid refresh cookie, and returns a JWT if it finds one.
+// refreshToken exchanges a valid refresh cookie for a new token pair. The refresh token
+// is rotated: the old one stops working, and presenting it again revokes the session.
 func (app *application) refreshToken(w http.ResponseWriter, r *http.Request) {
 	log.Printf("refreshToken endpoint hit\n")
-	for _, cookie := range r.Cookies() {
-		if cookie.Name == app.auth.CookieName {
-			claims := &Claims{}
-			refreshToken := cookie.Value
-
-			// parse the token to get the claims
-			_, err := jwt.ParseWithClaims(refreshToken, claims, func(token *jwt.Token) (interface{}, error) {
-				return []byte(app.JWTSecret), nil
-			})
-			if err != nil {
-				log.Printf("could not parse claim: %v\n", err)
-				app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
-				return
-			}
-
-			// get the user id from the token claims
-			userID, err := strconv.Atoi(claims.Subject)
-			if err != nil {
-				log.Printf("could not get userid from claim: %v\n", err)
-				app.errorJSON(w, errors.New("unknown user"), http.StatusUnauthorized)
-				return
-			}
-
-			user, err := app.DB.GetUserByID(userID)
-			if err != nil {
-				log.Printf("could not get user %v from db: %v\n", userID, err)
-				app.errorJSON(w, errors.New("unknown user"), http.StatusUnauthorized)
-				return
-			}
-
-			u := jwtUser{
-				ID:        user.ID,
-				FirstName: user.FirstName,
-				LastName:  user.LastName,
-			}
-
-			tokenPairs, err := app.auth.GenerateTokenPair(&u)
-			if err != nil {
-				log.Printf("could not generate tokens: %v\n", err)
-				app.errorJSON(w, errors.New("error generating tokens"), http.StatusUnauthorized)
-				return
-			}
-
-			http.SetCookie(w, app.auth.GetRefreshCookie(tokenPairs.RefreshToken))
-
-			app.writeJSON(w, http.StatusOK, tokenPairs)
+	refreshToken, ok := app.refreshCookie(r)
+	if !ok {
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	nextToken, err := generateToken()
+	if err != nil {
+		app.errorJSON(w, errors.New("error generating tokens"), http.StatusInternalServerError)
+		return
+	}
+
+	next := models.Session{
+		TokenHash:  hashToken(nextToken),
+		UserAgent:  userAgent(r),
+		IPAddress:  clientIP(r),
+		ExpiresAt:  time.Now().Add(app.auth.RefreshExpiry),
+		LastUsedAt: time.Now(),
+	}
+
+	err = app.DB.RotateSession(hashToken(refreshToken), &next, time.Now())
+	if err != nil {
+		if errors.Is(err, repository.ErrTokenReused) {
+			log.Printf("refresh token of user %d reused, revoked session %s\n", next.UserID, next.FamilyID)
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("could not rotate refresh token: %v\n", err)
 		}
+		http.SetCookie(w, app.auth.GetExpiredRefreshCookie())
+		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
 	}
+
+	user, err := app.DB.GetUserByID(next.UserID)
+	if err != nil {
+		log.Printf("could not get user %v from db: %v\n", next.UserID, err)
+		app.errorJSON(w, errors.New("unknown user"), http.StatusUnauthorized)
+		return
+	}
+
+	u := jwtUser{
+		ID:        user.ID,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+	}
+
+	tokenPairs, err := app.auth.GenerateTokenPair(&u)
+	if err != nil {
+		log.Printf("could not generate tokens: %v\n", err)
+		app.errorJSON(w, errors.New("error generating tokens"), http.StatusUnauthorized)
+		return
+	}
+	tokenPairs.RefreshToken = nextToken
+
+	http.SetCookie(w, app.auth.GetRefreshCookie(tokenPairs.RefreshToken))
+
+	app.writeJSON(w, http.StatusOK, tokenPairs)
 }
 
-// logout logs the user out by sending an expired cookie to delete the refresh cookie.
+// logout revokes the session of the refresh cookie, so neither it nor any token rotated
+// from it works again, and sends an expired cookie to delete it.
 func (app *application) logout(w http.ResponseWriter, r *http.Request) {
 	log.Printf("logout endpoint hit\n")
+	if refreshToken, ok := app.refreshCookie(r); ok {
+		err := app.DB.RevokeSession(hashToken(refreshToken), time.Now())
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
 	http.SetCookie(w, app.auth.GetExpiredRefreshCookie())
 	w.WriteHeader(http.StatusAccepted)
 }
 
-// returns all income associated with user
+// returns one page of the household's income, filtered and sorted by the query string
 func (app *application) AllIncomes(w http.ResponseWriter, r *http.Request) {
-	claims, ok := r.Context().Value(claimsKey).(*Claims)
-	if !ok {
-			app.errorJSON(w, errors.New("unable to retrieve claims"), http.StatusUnauthorized)
-			return
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
 	}
 
-	userID, err := strconv.Atoi(claims.Subject)
+	filter, err := app.readTransactionFilter(r)
 	if err != nil {
-			app.errorJSON(w, errors.New("invalid user ID in token"), http.StatusUnauthorized)
+			app.errorJSON(w, err)
 			return
 	}
 
-	incomes, err := app.DB.AllIncomes(userID)
+	if filter.PaymentMethod != "" {
+			app.errorJSON(w, errors.New("incomes cannot be filtered by payment_method"))
+			return
+	}
+
+	incomes, err := app.DB.AllIncomes(member.HouseholdID, filter)
 	if err != nil {
 			app.errorJSON(w, err, http.StatusInternalServerError)
 			return
@@ -220,22 +270,22 @@ func (app *application) AllIncomes(w http.ResponseWriter, r *http.Request) {
 	app.writeJSON(w, http.StatusOK, incomes)
 }
 
-// returns all expenses associated with user
+// returns one page of the household's expenses, filtered and sorted by the query string
 func (app *application) AllExpenses(w http.ResponseWriter, r *http.Request) {
 	log.Printf("AllExpenses endpoint hit\n")
-	claims, ok := r.Context().Value(claimsKey).(*Claims)
-	if !ok {
-			app.errorJSON(w, errors.New("unable to retrieve claims"), http.StatusUnauthorized)
-			return
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
 	}
 
-	userID, err := strconv.Atoi(claims.Subject)
+	filter, err := app.readTransactionFilter(r)
 	if err != nil {
-			app.errorJSON(w, errors.New("invalid user ID in token"), http.StatusUnauthorized)
+			app.errorJSON(w, err)
 			return
 	}
 
-	expenses, err := app.DB.AllExpenses(userID)
+	expenses, err := app.DB.AllExpenses(member.HouseholdID, filter)
 	if err != nil {
 			app.errorJSON(w, err, http.StatusInternalServerError)
 			return
@@ -247,18 +297,10 @@ func (app *application) AllExpenses(w http.ResponseWriter, r *http.Request) {
 // insert one paycheque
 func (app *application) InsertIncome(w http.ResponseWriter, r *http.Request) {
 	log.Printf("InsertIncome endpoint hit\n")
-	claims, ok := r.Context().Value(claimsKey).(*Claims)
-	if !ok {
-			log.Println("unable to retrieve claims")
-			app.errorJSON(w, errors.New("unable to retrieve claims"), http.StatusUnauthorized)
-			return
-	}
-
-	userID, err := strconv.Atoi(claims.Subject)
+	member, err := app.householdFromContext(r)
 	if err != nil {
-			log.Println("invalid user ID in token")
-			app.errorJSON(w, errors.New("invalid user ID in token"), http.StatusUnauthorized)
-			return
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
 	}
 
 	var income models.Income
@@ -269,14 +311,35 @@ func (app *application) InsertIncome(w http.ResponseWriter, r *http.Request) {
 			return
 	}
 
-	income.UserID = userID
+	if income.Amount <= 0 {
+		app.errorJSON(w, errors.New("amount must be positive"))
+		return
+	}
+
+	if income.Currency != "" {
+		income.Currency, err = models.NormalizeCurrency(income.Currency)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+	}
+
+	err = app.checkAccountOwner(member.UserID, income.AccountID, income.Currency)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	income.UserID = member.UserID
+	income.HouseholdID = member.HouseholdID
 	income.CreatedAt = time.Now()
 	income.UpdatedAt = time.Now()
-	income.Source.UserID = userID
+	income.Source.UserID = member.UserID
+	income.Source.HouseholdID = member.HouseholdID
 	income.Source.CreatedAt = time.Now()
 	income.Source.UpdatedAt = time.Now()
 
-	err = app.DB.InsertIncome(&income)
+	err = app.repo(r).InsertIncome(&income)
 	if err != nil {
 			log.Println("error inserting income")
 			app.errorJSON(w, err)
@@ -294,16 +357,10 @@ func (app *application) InsertIncome(w http.ResponseWriter, r *http.Request) {
 // insert one expense
 func (app *application) InsertExpense(w http.ResponseWriter, r *http.Request) {
 	log.Printf("InsertExpense endpoint hit\n")
-	claims, ok := r.Context().Value(claimsKey).(*Claims)
-	if !ok {
-			app.errorJSON(w, errors.New("unable to retrieve claims"), http.StatusUnauthorized)
-			return
-	}
-
-	userID, err := strconv.Atoi(claims.Subject)
+	member, err := app.householdFromContext(r)
 	if err != nil {
-			app.errorJSON(w, errors.New("invalid user ID in token"), http.StatusUnauthorized)
-			return
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
 	}
 
 	var expense models.Expense
@@ -313,14 +370,35 @@ func (app *application) InsertExpense(w http.ResponseWriter, r *http.Request) {
 			return
 	}
 
-	expense.UserID = userID
+	if expense.Amount <= 0 {
+		app.errorJSON(w, errors.New("amount must be positive"))
+		return
+	}
+
+	if expense.Currency != "" {
+		expense.Currency, err = models.NormalizeCurrency(expense.Currency)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+	}
+
+	err = app.checkAccountOwner(member.UserID, expense.AccountID, expense.Currency)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	expense.UserID = member.UserID
+	expense.HouseholdID = member.HouseholdID
 	expense.CreatedAt = time.Now()
 	expense.UpdatedAt = time.Now()
-	expense.Category.UserID = userID
+	expense.Category.UserID = member.UserID
+	expense.Category.HouseholdID = member.HouseholdID
 	expense.Category.CreatedAt = time.Now()
 	expense.Category.UpdatedAt = time.Now()
 
-	err = app.DB.InsertExpense(&expense)
+	err = app.repo(r).InsertExpense(&expense)
 	if err != nil {
 			app.errorJSON(w, err)
 			return
@@ -334,157 +412,342 @@ func (app *application) InsertExpense(w http.ResponseWriter, r *http.Request) {
 	app.writeJSON(w, http.StatusAccepted, resp)
 }
 
-// get all sources belonging to user
-func (app *application) AllSources(w http.ResponseWriter, r *http.Request) {
-	log.Printf("AllSources endpoint hit\n")
-	claims, ok := r.Context().Value(claimsKey).(*Claims)
-	if !ok {
-			app.errorJSON(w, errors.New("unable to retrieve claims"), http.StatusUnauthorized)
-			return
+// update one paycheque; only the fields present in the payload are changed
+func (app *application) UpdateIncome(w http.ResponseWriter, r *http.Request) {
+	log.Printf("UpdateIncome endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
 	}
 
-	userID, err := strconv.Atoi(claims.Subject)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-			app.errorJSON(w, errors.New("invalid user ID in token"), http.StatusUnauthorized)
+		app.errorJSON(w, err)
+		return
+	}
+
+	income, err := app.DB.OneIncome(id, member.HouseholdID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("income not found"), http.StatusNotFound)
 			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
 	}
 
-	sources, err := app.DB.AllSources(userID)
+	// the member who recorded it keeps it, whoever in the household changes it
+	recordedBy := income.UserID
+
+	// decode the payload over the stored record, so omitted fields keep their values
+	err = app.readJSON(w, r, income)
 	if err != nil {
-			app.errorJSON(w, err)
+		app.errorJSON(w, err)
+		return
+	}
+
+	if income.Source == nil || income.Source.Name == "" {
+		app.errorJSON(w, errors.New("source name is required"))
+		return
+	}
+
+	if income.Amount <= 0 {
+		app.errorJSON(w, errors.New("amount must be positive"))
+		return
+	}
+
+	income.Currency, err = models.NormalizeCurrency(income.Currency)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.checkAccountOwner(recordedBy, income.AccountID, income.Currency)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	income.ID = id
+	income.UserID = recordedBy
+	income.HouseholdID = member.HouseholdID
+	income.UpdatedAt = time.Now()
+	income.Source.UserID = member.UserID
+	income.Source.HouseholdID = member.HouseholdID
+	income.Source.CreatedAt = time.Now()
+	income.Source.UpdatedAt = time.Now()
+
+	err = app.repo(r).UpdateIncome(income)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("income not found"), http.StatusNotFound)
 			return
+		}
+		app.errorJSON(w, err)
+		return
 	}
 
-	app.writeJSON(w, http.StatusOK, sources)
+	resp := JSONResponse{
+		Error:   false,
+		Message: "income updated",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
 }
 
-// get all categories belonging to user
-func (app *application) AllCategories(w http.ResponseWriter, r *http.Request) {
-	log.Printf("AllCategories endpoint hit\n")
-	claims, ok := r.Context().Value(claimsKey).(*Claims)
-	if !ok {
-			app.errorJSON(w, errors.New("unable to retrieve claims"), http.StatusUnauthorized)
+// delete one paycheque
+func (app *application) DeleteIncome(w http.ResponseWriter, r *http.Request) {
+	log.Printf("DeleteIncome endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.repo(r).DeleteIncome(id, member.HouseholdID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("income not found"), http.StatusNotFound)
 			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
 	}
 
-	userID, err := strconv.Atoi(claims.Subject)
+	resp := JSONResponse{
+		Error:   false,
+		Message: "income deleted",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// update one expense; only the fields present in the payload are changed
+func (app *application) UpdateExpense(w http.ResponseWriter, r *http.Request) {
+	log.Printf("UpdateExpense endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-			app.errorJSON(w, errors.New("invalid user ID in token"), http.StatusUnauthorized)
+		app.errorJSON(w, err)
+		return
+	}
+
+	expense, err := app.DB.OneExpense(id, member.HouseholdID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("expense not found"), http.StatusNotFound)
 			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
 	}
 
-	categories, err := app.DB.AllCategories(userID)
+	// the member who recorded it keeps it, whoever in the household changes it
+	recordedBy := expense.UserID
+
+	// decode the payload over the stored record, so omitted fields keep their values
+	err = app.readJSON(w, r, expense)
 	if err != nil {
-			app.errorJSON(w, err)
+		app.errorJSON(w, err)
+		return
+	}
+
+	if expense.Category == nil || expense.Category.Name == "" {
+		app.errorJSON(w, errors.New("category name is required"))
+		return
+	}
+
+	if expense.Amount <= 0 {
+		app.errorJSON(w, errors.New("amount must be positive"))
+		return
+	}
+
+	expense.Currency, err = models.NormalizeCurrency(expense.Currency)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.checkAccountOwner(recordedBy, expense.AccountID, expense.Currency)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	expense.ID = id
+	expense.UserID = recordedBy
+	expense.HouseholdID = member.HouseholdID
+	expense.UpdatedAt = time.Now()
+	expense.Category.UserID = member.UserID
+	expense.Category.HouseholdID = member.HouseholdID
+	expense.Category.CreatedAt = time.Now()
+	expense.Category.UpdatedAt = time.Now()
+
+	err = app.repo(r).UpdateExpense(expense)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("expense not found"), http.StatusNotFound)
 			return
+		}
+		app.errorJSON(w, err)
+		return
 	}
 
-	app.writeJSON(w, http.StatusOK, categories)
+	resp := JSONResponse{
+		Error:   false,
+		Message: "expense updated",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
 }
 
-// get summary for dashboard
-func (app *application) GetFinancialSummary(w http.ResponseWriter, r *http.Request) {
-	log.Printf("GetFinancialSummary endpoint hit\n")
-	// get the userId using the jwt token
-	userID, err := app.getUserIDFromContext(r)
+// delete one expense
+func (app *application) DeleteExpense(w http.ResponseWriter, r *http.Request) {
+	log.Printf("DeleteExpense endpoint hit\n")
+	member, err := app.householdFromContext(r)
 	if err != nil {
-			app.errorJSON(w, err)
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.repo(r).DeleteExpense(id, member.HouseholdID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("expense not found"), http.StatusNotFound)
 			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
 	}
 
-	// Get overall sums
-	totalIncome, err := app.DB.GetTotalIncome(userID)
+	resp := JSONResponse{
+		Error:   false,
+		Message: "expense deleted",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// get all sources belonging to the household
+func (app *application) AllSources(w http.ResponseWriter, r *http.Request) {
+	log.Printf("AllSources endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	sources, err := app.DB.AllSources(member.HouseholdID)
 	if err != nil {
 			app.errorJSON(w, err)
 			return
 	}
 
-	totalExpenses, err := app.DB.GetTotalExpenses(userID)
+	app.writeJSON(w, http.StatusOK, sources)
+}
+
+// get all categories belonging to the household
+func (app *application) AllCategories(w http.ResponseWriter, r *http.Request) {
+	log.Printf("AllCategories endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	categories, err := app.DB.AllCategories(member.HouseholdID)
 	if err != nil {
 			app.errorJSON(w, err)
 			return
 	}
 
-	accountBalance := totalIncome - totalExpenses
+	app.writeJSON(w, http.StatusOK, categories)
+}
+
+// change the user's base currency, which the households they create start with, and which
+// households they are the only member of follow
+func (app *application) UpdateBaseCurrency(w http.ResponseWriter, r *http.Request) {
+	log.Printf("UpdateBaseCurrency endpoint hit\n")
+	userID, err := app.getUserIDFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	var payload struct {
+		BaseCurrency string `json:"base_currency"`
+	}
 
-	// Get income by source and expenses by category
-	incomeBySource, err := app.DB.GetIncomeBySource(userID)
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	currency, err := models.NormalizeCurrency(payload.BaseCurrency)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.repo(r).UpdateUserBaseCurrency(userID, currency)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "base currency updated",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// get summary for dashboard, broken down by the period and granularity in the query string
+func (app *application) GetFinancialSummary(w http.ResponseWriter, r *http.Request) {
+	log.Printf("GetFinancialSummary endpoint hit\n")
+	// the household comes from enforceHousehold; accounts stay the member's own
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	period, granularity, err := app.readSummaryPeriod(r)
 	if err != nil {
 			app.errorJSON(w, err)
 			return
 	}
 
-	expensesByCategory, err := app.DB.GetExpensesByCategory(userID)
+	summary, err := app.DB.GetFinancialSummary(member.HouseholdID, period, granularity)
 	if err != nil {
 			app.errorJSON(w, err)
 			return
 	}
 
-	// Get data for the past 12 months
-	var months []map[string]interface{}
-	for i := 11; i >= 0; i-- {
-			monthData := make(map[string]interface{})
-			
-			monthName := time.Now().AddDate(0, -i, 0).Format("January 2006")
-			monthData["month"] = monthName
-
-			incomeThisMonth, err := app.DB.GetIncomeForMonth(userID, i)
-			if err != nil {
-					app.errorJSON(w, err)
-					return
-			}
-
-			expensesThisMonth, err := app.DB.GetExpensesForMonth(userID, i)
-			if err != nil {
-					app.errorJSON(w, err)
-					return
-			}
-
-			netIncomeThisMonth := incomeThisMonth - expensesThisMonth
-
-			incomeBySourceThisMonth, err := app.DB.GetIncomeBySourceForMonth(userID, i)
-			if err != nil {
-					app.errorJSON(w, err)
-					return
-			}
-
-			expensesByCategoryThisMonth, err := app.DB.GetExpensesByCategoryForMonth(userID, i)
-			if err != nil {
-					app.errorJSON(w, err)
-					return
-			}
-
-			top3IncomeSources, err := app.DB.GetTop3IncomeSourcesForMonth(userID, i)
-			if err != nil {
-					app.errorJSON(w, err)
-					return
-			}
-
-			top3ExpenseCategories, err := app.DB.GetTop3ExpenseCategoriesForMonth(userID, i)
-			if err != nil {
-					app.errorJSON(w, err)
-					return
-			}
-
-			monthData["net_income"] = netIncomeThisMonth
-			monthData["income_sum"] = incomeThisMonth
-			monthData["expense_sum"] = expensesThisMonth
-			monthData["income_by_source"] = incomeBySourceThisMonth
-			monthData["expense_by_category"] = expensesByCategoryThisMonth
-			monthData["top3IncomeThisMonth"] = top3IncomeSources
-			monthData["top3ExpenseThisMonth"] = top3ExpenseCategories
-
-			months = append(months, monthData)
-	}
-
-	// Build the final JSON response
-	summary := map[string]interface{}{
-			"account_balance":       accountBalance,
-			"income_sum_total":      totalIncome,
-			"expense_sum_total":     totalExpenses,
-			"overall_income_by_source": incomeBySource,
-			"overall_expense_by_category": expensesByCategory,
-			"months":                months,
+	summary.Accounts, err = app.DB.AllAccounts(member.UserID)
+	if err != nil {
+			app.errorJSON(w, err)
+			return
 	}
 
 	app.writeJSON(w, http.StatusOK, summary)
@@ -0,0 +1,569 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// householdInviteExpiry is how long an invite link can be used for.
+const householdInviteExpiry = time.Hour * 24 * 7
+
+// householdKey holds the membership of the household a request acts on.
+const householdKey contextKey = "household"
+
+// householdHeader chooses the household a request acts on; without it, the household the
+// user joined first is used.
+const householdHeader = "X-Household-ID"
+
+// householdResources lists the first segment of each /admin path whose records belong to
+// a household rather than to one user.
+var householdResources = map[string]bool{
+	"incomes":    true,
+	"sources":    true,
+	"expenses":   true,
+	"categories": true,
+	"summary":    true,
+	"budgets":    true,
+	"goals":      true,
+	"recurring":  true,
+	"import":     true,
+	"export":     true,
+	"audit":      true,
+}
+
+// enforceHousehold resolves the household a request to a household's records acts on and
+// checks the user's role in it: GET requests need to be a viewer, anything else an
+// editor. The membership is stored in the context for the handler.
+func (app *application) enforceHousehold(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		segment := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/admin/"), "/", 2)[0]
+		if !householdResources[segment] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		userID, err := app.getUserIDFromContext(r)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusUnauthorized)
+			return
+		}
+
+		var member *models.HouseholdMember
+		if header := r.Header.Get(householdHeader); header != "" {
+			householdID, err := strconv.Atoi(header)
+			if err != nil {
+				app.errorJSON(w, fmt.Errorf("invalid %s header", householdHeader))
+				return
+			}
+			member, err = app.DB.HouseholdMember(householdID, userID)
+		} else {
+			member, err = app.DB.DefaultHouseholdMember(userID)
+		}
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				app.errorJSON(w, errors.New("not a member of this household"), http.StatusForbidden)
+				return
+			}
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		role := models.RoleEditor
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			role = models.RoleViewer
+		}
+
+		if !member.Can(role) {
+			app.errorJSON(w, fmt.Errorf("%ss of a household cannot do this", member.Role), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), householdKey, member)))
+	})
+}
+
+// requireHouseholdRole returns middleware that lets a request to /households/{id} through
+// only if the user has at least role in that household, and stores the membership in the
+// context for the handler.
+func (app *application) requireHouseholdRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, err := app.getUserIDFromContext(r)
+			if err != nil {
+				app.errorJSON(w, err, http.StatusUnauthorized)
+				return
+			}
+
+			householdID, err := strconv.Atoi(chi.URLParam(r, "id"))
+			if err != nil {
+				app.errorJSON(w, err)
+				return
+			}
+
+			member, err := app.DB.HouseholdMember(householdID, userID)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					app.errorJSON(w, errors.New("household not found"), http.StatusNotFound)
+					return
+				}
+				app.errorJSON(w, err, http.StatusInternalServerError)
+				return
+			}
+
+			if !member.Can(role) {
+				app.errorJSON(w, fmt.Errorf("only %ss of a household can do this", role), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), householdKey, member)))
+		})
+	}
+}
+
+// householdFromContext returns the membership enforceHousehold or requireHouseholdRole
+// stored for the request.
+func (app *application) householdFromContext(r *http.Request) (*models.HouseholdMember, error) {
+	member, ok := r.Context().Value(householdKey).(*models.HouseholdMember)
+	if !ok {
+		return nil, errors.New("unable to retrieve household")
+	}
+
+	return member, nil
+}
+
+// householdError writes the response for an error changing a household's members.
+func (app *application) householdError(w http.ResponseWriter, err error, notFound string) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		app.errorJSON(w, errors.New(notFound), http.StatusNotFound)
+	case errors.Is(err, repository.ErrLastOwner), errors.Is(err, repository.ErrLastHousehold):
+		app.errorJSON(w, err, http.StatusConflict)
+	default:
+		app.errorJSON(w, err, http.StatusInternalServerError)
+	}
+}
+
+// returns the households the user is a member of, with their role in each
+func (app *application) AllHouseholds(w http.ResponseWriter, r *http.Request) {
+	log.Printf("AllHouseholds endpoint hit\n")
+	userID, err := app.getUserIDFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	households, err := app.DB.AllHouseholds(userID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, households)
+}
+
+// readHousehold reads and checks a household's name and base currency from the request.
+func (app *application) readHousehold(w http.ResponseWriter, r *http.Request, household *models.Household) error {
+	var requestPayload struct {
+		Name         string `json:"name"`
+		BaseCurrency string `json:"base_currency"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		return err
+	}
+
+	household.Name = strings.TrimSpace(requestPayload.Name)
+	if household.Name == "" || len(household.Name) > 255 {
+		return errors.New("name must be between 1 and 255 characters")
+	}
+
+	if requestPayload.BaseCurrency != "" {
+		household.BaseCurrency, err = models.NormalizeCurrency(requestPayload.BaseCurrency)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// creates a household, owned by the user, in their base currency unless another is given
+func (app *application) InsertHousehold(w http.ResponseWriter, r *http.Request) {
+	log.Printf("InsertHousehold endpoint hit\n")
+	user, ok := app.userFromContext(w, r)
+	if !ok {
+		return
+	}
+
+	household := models.Household{BaseCurrency: user.BaseCurrency}
+
+	err := app.readHousehold(w, r, &household)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	household.CreatedAt = time.Now()
+	household.UpdatedAt = household.CreatedAt
+	household.Role = models.RoleOwner
+
+	household.ID, err = app.repo(r).InsertHousehold(&household, user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "household created",
+		Data:    household,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// renames a household and sets its base currency
+func (app *application) UpdateHousehold(w http.ResponseWriter, r *http.Request) {
+	log.Printf("UpdateHousehold endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	household := models.Household{ID: member.HouseholdID}
+
+	err = app.readHousehold(w, r, &household)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	if household.BaseCurrency == "" {
+		app.errorJSON(w, errors.New("base currency is required"))
+		return
+	}
+
+	household.UpdatedAt = time.Now()
+
+	err = app.repo(r).UpdateHousehold(&household)
+	if err != nil {
+		app.householdError(w, err, "household not found")
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "household updated",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// deletes a household, with everything recorded in it, unless it is the only household
+// of one of its members
+func (app *application) DeleteHousehold(w http.ResponseWriter, r *http.Request) {
+	log.Printf("DeleteHousehold endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	err = app.repo(r).DeleteHousehold(member.HouseholdID)
+	if err != nil {
+		app.householdError(w, err, "household not found")
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "household deleted",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// returns the members of a household
+func (app *application) AllHouseholdMembers(w http.ResponseWriter, r *http.Request) {
+	log.Printf("AllHouseholdMembers endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	members, err := app.DB.AllHouseholdMembers(member.HouseholdID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, members)
+}
+
+// changes the role of one member of a household
+func (app *application) UpdateHouseholdMember(w http.ResponseWriter, r *http.Request) {
+	log.Printf("UpdateHouseholdMember endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var requestPayload struct {
+		Role string `json:"role"`
+	}
+
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if !models.ValidRole(requestPayload.Role) {
+		app.errorJSON(w, fmt.Errorf("unknown role %q", requestPayload.Role))
+		return
+	}
+
+	err = app.repo(r).UpdateHouseholdMember(&models.HouseholdMember{
+		HouseholdID: member.HouseholdID,
+		UserID:      userID,
+		Role:        requestPayload.Role,
+		UpdatedAt:   time.Now(),
+	})
+	if err != nil {
+		app.householdError(w, err, "member not found")
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "member updated",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// removes one member from a household, unless it is their only one; what they recorded
+// stays
+func (app *application) DeleteHouseholdMember(w http.ResponseWriter, r *http.Request) {
+	log.Printf("DeleteHouseholdMember endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.repo(r).DeleteHouseholdMember(member.HouseholdID, userID)
+	if err != nil {
+		app.householdError(w, err, "member not found")
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "member removed",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// lets the user leave a household; its last owner has to delete it instead, and nobody
+// can leave their only household
+func (app *application) LeaveHousehold(w http.ResponseWriter, r *http.Request) {
+	log.Printf("LeaveHousehold endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	err = app.repo(r).DeleteHouseholdMember(member.HouseholdID, member.UserID)
+	if err != nil {
+		app.householdError(w, err, "household not found")
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "left household",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// returns a household's invites that can still be used, without their links
+func (app *application) AllHouseholdInvites(w http.ResponseWriter, r *http.Request) {
+	log.Printf("AllHouseholdInvites endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	invites, err := app.DB.AllHouseholdInvites(member.HouseholdID, time.Now())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, invites)
+}
+
+// creates an invite to a household with a role, editor unless another is given, and
+// returns its link; this is the only time the link is shown
+func (app *application) InsertHouseholdInvite(w http.ResponseWriter, r *http.Request) {
+	log.Printf("InsertHouseholdInvite endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	var requestPayload struct {
+		Role string `json:"role"`
+	}
+
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if requestPayload.Role == "" {
+		requestPayload.Role = models.RoleEditor
+	}
+	if !models.ValidRole(requestPayload.Role) {
+		app.errorJSON(w, fmt.Errorf("unknown role %q", requestPayload.Role))
+		return
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	invite := models.HouseholdInvite{
+		HouseholdID: member.HouseholdID,
+		TokenHash:   hashToken(token),
+		Role:        requestPayload.Role,
+		CreatedBy:   member.UserID,
+		ExpiresAt:   now.Add(householdInviteExpiry),
+		CreatedAt:   now,
+	}
+
+	err = app.repo(r).InsertHouseholdInvite(&invite)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "invite created",
+		Data: struct {
+			*models.HouseholdInvite
+			Token string `json:"token"`
+			Link  string `json:"link"`
+		}{&invite, token, app.frontendURL("/households/join", url.Values{"token": {token}})},
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// revokes one of a household's invites
+func (app *application) DeleteHouseholdInvite(w http.ResponseWriter, r *http.Request) {
+	log.Printf("DeleteHouseholdInvite endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	inviteID, err := strconv.Atoi(chi.URLParam(r, "inviteID"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.repo(r).DeleteHouseholdInvite(member.HouseholdID, inviteID)
+	if err != nil {
+		app.householdError(w, err, "invite not found")
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "invite revoked",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// joins the household of an invite link, with the invite's role
+func (app *application) JoinHousehold(w http.ResponseWriter, r *http.Request) {
+	log.Printf("JoinHousehold endpoint hit\n")
+	userID, err := app.getUserIDFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	var requestPayload struct {
+		Token string `json:"token"`
+	}
+
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	member, err := app.repo(r).AcceptHouseholdInvite(hashToken(requestPayload.Token), userID, time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			app.errorJSON(w, errors.New("invite is invalid or has expired"))
+		case errors.Is(err, repository.ErrDuplicate):
+			app.errorJSON(w, errors.New("already a member of this household"), http.StatusConflict)
+		default:
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "joined household",
+		Data:    member,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
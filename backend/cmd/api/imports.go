@@ -0,0 +1,419 @@
+package main
+
+import (
+	"backend/internal/importer"
+	"backend/internal/models"
+	"backend/internal/ofx"
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxImportBytes bounds the size of an uploaded statement.
+const maxImportBytes = 10 << 20
+
+// defaultImportCategory is the category of imported expenses when none is given.
+const defaultImportCategory = "Uncategorized"
+
+// get all CSV import mappings belonging to user
+func (app *application) AllImportMappings(w http.ResponseWriter, r *http.Request) {
+	log.Printf("AllImportMappings endpoint hit\n")
+	userID, err := app.getUserIDFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	mappings, err := app.DB.AllImportMappings(userID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, mappings)
+}
+
+// insert one CSV import mapping
+func (app *application) InsertImportMapping(w http.ResponseWriter, r *http.Request) {
+	log.Printf("InsertImportMapping endpoint hit\n")
+	userID, err := app.getUserIDFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	var mapping models.ImportMapping
+	err = app.readJSON(w, r, &mapping)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.validateImportMapping(userID, &mapping)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	mapping.UserID = userID
+	mapping.CreatedAt = time.Now()
+	mapping.UpdatedAt = time.Now()
+
+	_, err = app.repo(r).InsertImportMapping(&mapping)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "import mapping inserted",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// update one CSV import mapping; only the fields present in the payload are changed
+func (app *application) UpdateImportMapping(w http.ResponseWriter, r *http.Request) {
+	log.Printf("UpdateImportMapping endpoint hit\n")
+	userID, err := app.getUserIDFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	mapping, err := app.DB.OneImportMapping(id, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("import mapping not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// decode the payload over the stored record, so omitted fields keep their values
+	err = app.readJSON(w, r, mapping)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.validateImportMapping(userID, mapping)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	mapping.ID = id
+	mapping.UserID = userID
+	mapping.UpdatedAt = time.Now()
+
+	err = app.repo(r).UpdateImportMapping(mapping)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("import mapping not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "import mapping updated",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// delete one CSV import mapping
+func (app *application) DeleteImportMapping(w http.ResponseWriter, r *http.Request) {
+	log.Printf("DeleteImportMapping endpoint hit\n")
+	userID, err := app.getUserIDFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.repo(r).DeleteImportMapping(id, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("import mapping not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "import mapping deleted",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// read an uploaded CSV statement with a saved mapping and report what would be imported,
+// without importing anything; the form holds the file and a mapping_id
+func (app *application) PreviewCSVImport(w http.ResponseWriter, r *http.Request) {
+	log.Printf("PreviewCSVImport endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	_, preview, err := app.readCSVStatement(w, r, member.UserID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, preview)
+}
+
+// import an uploaded CSV statement with a saved mapping, all rows in one transaction.
+// The form holds the file and a mapping_id; if any row cannot be read nothing is
+// imported, unless skip_errors=true is sent to import the readable rows only
+func (app *application) CommitCSVImport(w http.ResponseWriter, r *http.Request) {
+	log.Printf("CommitCSVImport endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	mapping, preview, err := app.readCSVStatement(w, r, member.UserID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if preview.Errors > 0 && r.FormValue("skip_errors") != "true" {
+		resp := JSONResponse{
+			Error:   true,
+			Message: fmt.Sprintf("%d rows cannot be imported; fix them, or send skip_errors=true to leave them out", preview.Errors),
+			Data:    preview,
+		}
+		app.writeJSON(w, http.StatusBadRequest, resp)
+		return
+	}
+
+	incomes, expenses := preview.Transactions(member.UserID, mapping.Currency, mapping.AccountID)
+
+	n, err := app.repo(r).ImportTransactions(member.HouseholdID, member.UserID, incomes, expenses)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d rows imported", n),
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// read an uploaded OFX or QFX statement and report what would be imported, without
+// importing anything. The form holds the file, and optionally an account_id, a source
+// for incomes and a category for expenses; transactions imported before are flagged
+func (app *application) PreviewOFXImport(w http.ResponseWriter, r *http.Request) {
+	log.Printf("PreviewOFXImport endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	_, preview, err := app.readOFXStatement(w, r, member)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, preview)
+}
+
+// import an uploaded OFX or QFX statement, all transactions in one transaction; those
+// imported before are skipped, so the same file can be imported again. The form is the
+// preview's; if any transaction cannot be read nothing is imported, unless
+// skip_errors=true is sent to import the readable ones only
+func (app *application) CommitOFXImport(w http.ResponseWriter, r *http.Request) {
+	log.Printf("CommitOFXImport endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	accountID, preview, err := app.readOFXStatement(w, r, member)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if preview.Errors > 0 && r.FormValue("skip_errors") != "true" {
+		resp := JSONResponse{
+			Error:   true,
+			Message: fmt.Sprintf("%d transactions cannot be imported; send skip_errors=true to leave them out", preview.Errors),
+			Data:    preview,
+		}
+		app.writeJSON(w, http.StatusBadRequest, resp)
+		return
+	}
+
+	incomes, expenses := preview.Transactions(member.UserID, "", accountID)
+
+	n, err := app.repo(r).ImportTransactions(member.HouseholdID, member.UserID, incomes, expenses)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d transactions imported, %d already imported", n, len(incomes)+len(expenses)-n+preview.Duplicates),
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// readCSVStatement reads the statement uploaded with a preview or commit request,
+// using the user's mapping named by the mapping_id form field.
+func (app *application) readCSVStatement(w http.ResponseWriter, r *http.Request, userID int) (*models.ImportMapping, *models.ImportPreview, error) {
+	data, err := app.readUpload(w, r, "file", maxImportBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mappingID, err := strconv.Atoi(r.FormValue("mapping_id"))
+	if err != nil {
+		return nil, nil, errors.New("mapping_id is required")
+	}
+
+	mapping, err := app.DB.OneImportMapping(mappingID, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, errors.New("import mapping not found")
+		}
+		return nil, nil, err
+	}
+
+	preview, err := importer.ReadCSV(bytes.NewReader(data), mapping)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return mapping, preview, nil
+}
+
+// readOFXStatement reads the OFX or QFX statement uploaded with a preview or commit
+// request, flagging the transactions the household has imported before, and returns the
+// member's account named by the account_id form field, if any.
+func (app *application) readOFXStatement(w http.ResponseWriter, r *http.Request, member *models.HouseholdMember) (*int, *models.ImportPreview, error) {
+	data, err := app.readUpload(w, r, "file", maxImportBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var accountID *int
+	if v := r.FormValue("account_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, nil, errors.New("account_id must be a number")
+		}
+		accountID = &id
+	}
+
+	err = app.checkAccountOwner(member.UserID, accountID, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	category := r.FormValue("category")
+	if category == "" {
+		category = defaultImportCategory
+	}
+
+	statements, err := ofx.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	preview := ofx.Preview(statements, r.FormValue("source"), category)
+
+	accounts, err := app.DB.AllAccounts(member.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	preview.CheckCurrencies("", accountID, accounts)
+
+	ids := []string{}
+	for _, row := range preview.Rows {
+		ids = append(ids, row.ExternalID)
+	}
+
+	imported, err := app.DB.ImportedExternalIDs(member.HouseholdID, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, row := range preview.Rows {
+		if row.Error == "" && imported[row.ExternalID] {
+			row.Duplicate = true
+			preview.Duplicates++
+			if row.Kind == models.ImportIncome {
+				preview.Incomes--
+			} else {
+				preview.Expenses--
+			}
+		}
+	}
+
+	return accountID, preview, nil
+}
+
+// validateImportMapping checks the user-supplied fields of an import mapping,
+// normalizing its currency.
+func (app *application) validateImportMapping(userID int, mapping *models.ImportMapping) error {
+	err := mapping.Validate()
+	if err != nil {
+		return err
+	}
+
+	_, err = importer.DateLayout(mapping.DateFormat)
+	if err != nil {
+		return err
+	}
+
+	if mapping.Currency != "" {
+		mapping.Currency, err = models.NormalizeCurrency(mapping.Currency)
+		if err != nil {
+			return err
+		}
+	}
+
+	return app.checkAccountOwner(userID, mapping.AccountID, mapping.Currency)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"backend/internal/journal"
+	"backend/internal/models"
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// export all of the household's incomes and expenses as a plain-text accounting journal;
+// format=ledger (the default, also read by hledger) or format=beancount
+func (app *application) ExportJournal(w http.ResponseWriter, r *http.Request) {
+	log.Printf("ExportJournal endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = journal.Ledger
+	}
+
+	// the writer buffers, so nothing reaches the response before the headers are set
+	jw, err := journal.NewWriter(w, format)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	household, err := app.DB.OneHousehold(member.HouseholdID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	sources, err := app.DB.AllSources(member.HouseholdID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	categories, err := app.DB.AllCategories(member.HouseholdID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	accounts, err := app.DB.AllAccounts(member.UserID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	unassigned := jw.Account(journal.AssetRoot, journal.Unassigned)
+	assets := map[int]string{}
+	declared := map[string]bool{unassigned: true}
+
+	for _, s := range sources {
+		declared[jw.Account(journal.IncomeRoot, s.Name)] = true
+	}
+	for _, c := range categories {
+		declared[jw.Account(journal.ExpenseRoot, c.Name)] = true
+	}
+	for _, a := range accounts {
+		assets[a.ID] = jw.Account(journal.AssetRoot, a.Name)
+		declared[assets[a.ID]] = true
+	}
+
+	names := []string{}
+	for name := range declared {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// other members' accounts are not shared, so their transactions go unassigned
+	asset := func(accountID *int) string {
+		if accountID != nil {
+			if name, ok := assets[*accountID]; ok {
+				return name
+			}
+		}
+		return unassigned
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="finances-%s.%s"`, time.Now().Format("2006-01-02"), format))
+	w.WriteHeader(http.StatusOK)
+
+	// once the journal has started, an error can only cut it short
+	err = jw.Header(household.BaseCurrency, names)
+	if err == nil {
+		err = app.DB.EachIncome(member.HouseholdID, func(income *models.Income) error {
+			return jw.Income(income, asset(income.AccountID))
+		})
+	}
+	if err == nil {
+		err = app.DB.EachExpense(member.HouseholdID, func(expense *models.Expense) error {
+			return jw.Expense(expense, asset(expense.AccountID))
+		})
+	}
+	if err == nil {
+		err = jw.Flush()
+	}
+	if err != nil {
+		log.Printf("Error exporting journal for household %d: %v\n", member.HouseholdID, err)
+	}
+}
+
+// read an uploaded Ledger, hledger or Beancount journal and report what would be
+// imported, without importing anything; the form holds the file
+func (app *application) PreviewJournalImport(w http.ResponseWriter, r *http.Request) {
+	log.Printf("PreviewJournalImport endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	j, err := app.readJournal(w, r, member)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, j.Preview)
+}
+
+// import an uploaded journal, with the sources and categories it declares, all in one
+// transaction. Transactions exported with an external id are skipped if the household already
+// has them. If any transaction cannot be imported nothing is, unless skip_errors=true
+// is sent to import the others only
+func (app *application) CommitJournalImport(w http.ResponseWriter, r *http.Request) {
+	log.Printf("CommitJournalImport endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	j, err := app.readJournal(w, r, member)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	preview := j.Preview
+	if preview.Errors > 0 && r.FormValue("skip_errors") != "true" {
+		resp := JSONResponse{
+			Error:   true,
+			Message: fmt.Sprintf("%d transactions cannot be imported; send skip_errors=true to leave them out", preview.Errors),
+			Data:    preview,
+		}
+		app.writeJSON(w, http.StatusBadRequest, resp)
+		return
+	}
+
+	now := time.Now()
+	sources := []*models.Source{}
+	for _, name := range j.Sources {
+		sources = append(sources, &models.Source{UserID: member.UserID, HouseholdID: member.HouseholdID, Name: name, CreatedAt: now, UpdatedAt: now})
+	}
+	categories := []*models.Category{}
+	for _, name := range j.Categories {
+		categories = append(categories, &models.Category{UserID: member.UserID, HouseholdID: member.HouseholdID, Name: name, CreatedAt: now, UpdatedAt: now})
+	}
+
+	incomes, expenses := preview.Transactions(member.UserID, "", nil)
+
+	n, err := app.repo(r).ImportJournal(member.HouseholdID, member.UserID, sources, categories, incomes, expenses)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d transactions imported", n),
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// readJournal reads the journal uploaded with a preview or commit request, naming its
+// sources and categories after the household's, and its accounts after the member's own,
+// where they match.
+func (app *application) readJournal(w http.ResponseWriter, r *http.Request, member *models.HouseholdMember) (*journal.Journal, error) {
+	data, err := app.readUpload(w, r, "file", maxImportBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	j, err := journal.Read(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	sources, err := app.DB.AllSources(member.HouseholdID)
+	if err != nil {
+		return nil, err
+	}
+
+	categories, err := app.DB.AllCategories(member.HouseholdID)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts, err := app.DB.AllAccounts(member.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	j.Resolve(sources, categories, accounts)
+	j.Preview.CheckCurrencies("", nil, accounts)
+
+	return j, nil
+}
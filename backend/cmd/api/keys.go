@@ -0,0 +1,142 @@
+package main
+
+import (
+	"backend/internal/jwk"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// SigningKey is a key access tokens are signed or verified with.
+type SigningKey struct {
+	ID      string            // The kid of the tokens it signs: its RFC 7638 thumbprint
+	Method  jwt.SigningMethod // RS256, ES256 or EdDSA, by the type of key
+	Private crypto.Signer     // nil for a key that only verifies
+	Public  crypto.PublicKey
+}
+
+// publishes the public keys access tokens are signed with, so other services can verify
+// them without sharing a secret
+func (app *application) JWKS(w http.ResponseWriter, r *http.Request) {
+	set := struct {
+		Keys []jwk.Key `json:"keys"`
+	}{Keys: []jwk.Key{}}
+
+	for _, k := range app.auth.Keys {
+		set.Keys = append(set.Keys, jwk.Key{ID: k.ID, Algorithm: k.Method.Alg(), Use: "sig", Public: k.Public})
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	app.writeJSON(w, http.StatusOK, set)
+}
+
+// newSigningKeys loads the PEM files listed, comma separated, in JWT_KEYS. The first one
+// signs access tokens, so it must be a private key; the rest only verify, so they may be
+// public keys. To rotate, list the new key after the current one until every server
+// publishes it, then move it first, and drop the old one once the tokens it signed
+// have expired. Without JWT_KEYS, tokens are signed with HS256 and JWT_SECRET.
+func newSigningKeys() ([]SigningKey, error) {
+	var keys []SigningKey
+
+	for _, path := range strings.Split(os.Getenv("JWT_KEYS"), ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := parseSigningKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		for _, k := range keys {
+			if k.ID == key.ID {
+				return nil, fmt.Errorf("%s: key is listed twice", path)
+			}
+		}
+
+		keys = append(keys, key)
+	}
+
+	if len(keys) > 0 && keys[0].Private == nil {
+		return nil, errors.New("the first of JWT_KEYS signs tokens, so it must be a private key")
+	}
+
+	return keys, nil
+}
+
+// parseSigningKey reads an RSA, P-256 or Ed25519 key from PEM: a private key in PKCS #8,
+// PKCS #1 or SEC 1 form, or a public key in PKIX form.
+func parseSigningKey(data []byte) (SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return SigningKey{}, errors.New("no PEM data found")
+	}
+
+	var parsed interface{}
+	var err error
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return SigningKey{}, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	var key SigningKey
+	if signer, ok := parsed.(crypto.Signer); ok {
+		key.Private = signer
+		key.Public = signer.Public()
+	} else {
+		key.Public = parsed
+	}
+
+	switch pub := key.Public.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < 2048 {
+			return SigningKey{}, errors.New("RSA keys must be at least 2048 bits")
+		}
+		key.Method = jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return SigningKey{}, errors.New("only P-256 EC keys are supported")
+		}
+		key.Method = jwt.SigningMethodES256
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return SigningKey{}, errors.New("unsupported key type")
+	}
+
+	key.ID, err = jwk.Thumbprint(key.Public)
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	return key, nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/ratelimit"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// loginLimitWindow is how long failed sign ins are remembered.
+const loginLimitWindow = time.Hour
+
+// loginLimits slow down password and code guessing, both from one address and against
+// one account.
+type loginLimits struct {
+	store     ratelimit.Store
+	byIP      *ratelimit.Limiter
+	byAccount *ratelimit.Limiter
+}
+
+// newLoginLimits returns the limits of sign ins, kept in store. An address gets more
+// tries than an account, as many users can share one.
+func newLoginLimits(store ratelimit.Store) loginLimits {
+	return loginLimits{
+		store: store,
+		byIP: &ratelimit.Limiter{
+			Store:  store,
+			Prefix: "ip:",
+			Free:   20,
+			Base:   time.Second,
+			Max:    time.Minute * 15,
+			Window: loginLimitWindow,
+		},
+		byAccount: &ratelimit.Limiter{
+			Store:  store,
+			Prefix: "account:",
+			Free:   5,
+			Base:   time.Second,
+			Max:    time.Minute * 15,
+			Window: loginLimitWindow,
+		},
+	}
+}
+
+// mailLimits slow down the endpoints that email a link to any address they are given,
+// so they cannot be used to flood an inbox or the mailer.
+type mailLimits struct {
+	byIP      *ratelimit.Limiter
+	byAddress *ratelimit.Limiter
+}
+
+// newMailLimits returns the limits of emailed links, kept in store alongside the limits of
+// sign ins. Every request counts, whether or not the address belongs to an account, so
+// the limits do not tell which do.
+func newMailLimits(store ratelimit.Store) mailLimits {
+	return mailLimits{
+		byIP: &ratelimit.Limiter{
+			Store:  store,
+			Prefix: "mail-ip:",
+			Free:   10,
+			Base:   time.Minute,
+			Max:    time.Minute * 15,
+			Window: loginLimitWindow,
+		},
+		byAddress: &ratelimit.Limiter{
+			Store:  store,
+			Prefix: "mail:",
+			Free:   3,
+			Base:   time.Minute,
+			Max:    time.Minute * 15,
+			Window: loginLimitWindow,
+		},
+	}
+}
+
+// checkMailLimits reports whether the request may have a link emailed to the given
+// address, and counts it in the same step if so. If not, it writes a 429 response saying
+// when to try again.
+func (app *application) checkMailLimits(w http.ResponseWriter, r *http.Request, email string) bool {
+	now := time.Now()
+
+	wait, err := app.mailLimits.byAddress.Reserve(loginKey(email), now)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return false
+	}
+
+	// a request the address refuses is not counted against the client
+	if wait == 0 {
+		wait, err = app.mailLimits.byIP.Reserve(clientIP(r), now)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return false
+		}
+		if wait > 0 {
+			err = app.mailLimits.byAddress.Release(loginKey(email))
+			if err != nil {
+				log.Printf("error releasing emailed link: %v\n", err)
+			}
+		}
+	}
+
+	if wait > 0 {
+		seconds := int((wait + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		app.errorJSON(w, fmt.Errorf("too many requests, try again in %d seconds", seconds), http.StatusTooManyRequests)
+		return false
+	}
+
+	return true
+}
+
+// checkLoginLimits reports whether the request may try to sign in to the account with
+// the given address. If so, the try is counted as a failure against both the address
+// and the account before the password or code is checked, so tries sent at once can't
+// all get in ahead of the count; loginPassed takes it back. If not, it records the
+// attempt and writes a 429 response saying when to try again.
+func (app *application) checkLoginLimits(w http.ResponseWriter, r *http.Request, email string) bool {
+	now := time.Now()
+
+	wait, err := app.loginLimits.byAccount.Reserve(loginKey(email), now)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return false
+	}
+
+	// a try the account refuses is not counted against the address
+	if wait == 0 {
+		wait, err = app.loginLimits.byIP.Reserve(clientIP(r), now)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return false
+		}
+		if wait > 0 {
+			err = app.loginLimits.byAccount.Release(loginKey(email))
+			if err != nil {
+				log.Printf("error releasing sign in: %v\n", err)
+			}
+		}
+	}
+
+	if wait == 0 {
+		return true
+	}
+
+	app.recordLogin(r, email, nil, models.LoginRateLimited)
+
+	seconds := int((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	app.errorJSON(w, fmt.Errorf("too many failed attempts, try again in %d seconds", seconds), http.StatusTooManyRequests)
+	return false
+}
+
+// loginFailed records a failed sign in. checkLoginLimits already counted it.
+func (app *application) loginFailed(r *http.Request, email string, userID *int, reason string) {
+	app.recordLogin(r, email, userID, reason)
+}
+
+// loginPassed takes back the failure checkLoginLimits counted, once the password or code
+// turned out right. Earlier failures are kept.
+func (app *application) loginPassed(r *http.Request, email string) {
+	err := app.loginLimits.byIP.Release(clientIP(r))
+	if err != nil {
+		log.Printf("error releasing sign in: %v\n", err)
+	}
+	err = app.loginLimits.byAccount.Release(loginKey(email))
+	if err != nil {
+		log.Printf("error releasing sign in: %v\n", err)
+	}
+}
+
+// loginSucceeded clears the failures of the account, and records the sign in. Failures
+// from the address are kept, so signing in to an account of one's own does not buy more
+// guesses at others.
+func (app *application) loginSucceeded(r *http.Request, email string, userID int, reason string) {
+	err := app.loginLimits.byAccount.Reset(loginKey(email))
+	if err != nil {
+		log.Printf("error clearing failed sign ins: %v\n", err)
+	}
+
+	app.recordLogin(r, email, &userID, reason)
+}
+
+// recordLogin adds an attempt to the login audit trail. A failure to do so is logged, but
+// does not stop the sign in.
+func (app *application) recordLogin(r *http.Request, email string, userID *int, reason string) {
+	attempt := models.LoginAttempt{
+		UserID:    userID,
+		Email:     loginKey(email),
+		IPAddress: clientIP(r),
+		UserAgent: userAgent(r),
+		Success:   reason == models.LoginSucceeded || reason == models.LoginMFARequired,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	}
+
+	err := app.DB.InsertLoginAttempt(&attempt)
+	if err != nil {
+		log.Printf("error recording sign in: %v\n", err)
+	}
+}
+
+// loginKey returns an address as it is counted and recorded: trimmed, lower case, valid
+// UTF-8 and at most 255 bytes.
+func loginKey(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if len(email) > 255 {
+		email = email[:255]
+	}
+	return strings.ToValidUTF8(email, "")
+}
@@ -1,12 +1,19 @@
 package main
 
 import (
+	"backend/internal/mailer"
+	"backend/internal/oidc"
+	"backend/internal/ratelimit"
 	"backend/internal/repository"
 	"backend/internal/repository/dbrepo"
+	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -24,6 +31,11 @@ type application struct {
 	JWTAudience  string
 	CookieDomain string
 	APIKey       string
+	Mailer       mailer.Mailer
+	emailPolicy  EmailPolicy
+	loginLimits  loginLimits
+	mailLimits   mailLimits
+	idProviders  map[string]*oidc.Provider
 }
 
 func main() {
@@ -50,6 +62,14 @@ func main() {
 	app.DB = &dbrepo.PostgresDBRepo{DB: conn}
 	defer app.DB.Connection().Close()
 
+	// load exchange rates, if a rates file is configured
+	if path := os.Getenv("EXCHANGE_RATES_CSV"); path != "" {
+		err = app.loadExchangeRates(path)
+		if err != nil {
+			log.Fatalf("Failed to load exchange rates: %v", err)
+		}
+	}
+
 	// configure authentication
 	app.auth = Auth{
 		Issuer:       app.JWTIssuer,
@@ -62,6 +82,41 @@ func main() {
 		CookieDomain: app.CookieDomain,
 	}
 
+	// load the keys access tokens are signed with, if any
+	app.auth.Keys, err = newSigningKeys()
+	if err != nil {
+		log.Fatalf("Failed to load signing keys: %v", err)
+	}
+
+	// decide where failed sign ins are counted
+	store, err := newLimitStore(conn)
+	if err != nil {
+		log.Fatalf("Failed to configure login limits: %v", err)
+	}
+	app.loginLimits = newLoginLimits(store)
+	app.mailLimits = newMailLimits(store)
+
+	// configure the identity providers users can sign in with
+	app.idProviders, err = newOIDCProviders(app.Domain)
+	if err != nil {
+		log.Fatalf("Failed to configure identity providers: %v", err)
+	}
+
+	// configure how email is sent
+	app.Mailer, err = newMailer(app.Domain)
+	if err != nil {
+		log.Fatalf("Failed to configure mail: %v", err)
+	}
+
+	// decide whether unverified accounts may use /admin
+	app.emailPolicy, err = newEmailPolicy()
+	if err != nil {
+		log.Fatalf("Failed to configure email verification: %v", err)
+	}
+
+	// post due recurring incomes and expenses in the background
+	go app.runScheduler(schedulerInterval)
+
 	log.Println("Starting application on port", port)
 
 	err = http.ListenAndServe(fmt.Sprintf(":%d", port), app.routes())
@@ -77,3 +132,79 @@ func mustGetEnv(key string) string {
 	}
 	return value
 }
+
+// newMailer picks how email is sent: through the SMTP server in SMTP_HOST if it is set,
+// else into files in MAIL_DIR if that is set, else into the log. Mail comes from
+// MAIL_FROM, or else no-reply at the app's domain.
+func newMailer(domain string) (mailer.Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		host := domain
+		if u, err := url.Parse(domain); err == nil && u.Host != "" {
+			host = u.Hostname()
+		}
+		from = "no-reply@" + strings.Split(host, ":")[0]
+	}
+
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		port := 587
+		if p := os.Getenv("SMTP_PORT"); p != "" {
+			n, err := strconv.Atoi(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SMTP_PORT %q", p)
+			}
+			port = n
+		}
+
+		return &mailer.SMTPMailer{
+			Host:     host,
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}, nil
+	}
+
+	if dir := os.Getenv("MAIL_DIR"); dir != "" {
+		return &mailer.FileMailer{Dir: dir, From: from}, nil
+	}
+
+	return &mailer.LogMailer{From: from}, nil
+}
+
+// newEmailPolicy reads the email policy: REQUIRE_VERIFIED_EMAIL=true keeps unverified
+// accounts out of /admin, except for the EMAIL_VERIFICATION_GRACE after signup, e.g. 72h.
+func newEmailPolicy() (EmailPolicy, error) {
+	var policy EmailPolicy
+	var err error
+
+	if v := os.Getenv("REQUIRE_VERIFIED_EMAIL"); v != "" {
+		policy.RequireVerified, err = strconv.ParseBool(v)
+		if err != nil {
+			return policy, fmt.Errorf("invalid REQUIRE_VERIFIED_EMAIL %q", v)
+		}
+	}
+
+	if v := os.Getenv("EMAIL_VERIFICATION_GRACE"); v != "" {
+		policy.Grace, err = time.ParseDuration(v)
+		if err != nil {
+			return policy, fmt.Errorf("invalid EMAIL_VERIFICATION_GRACE %q", v)
+		}
+	}
+
+	return policy, nil
+}
+
+// newLimitStore picks where failed sign ins are counted: LOGIN_LIMIT_STORE=memory keeps
+// them in this server's memory, and postgres, the default, in the database, so servers
+// sharing it share the limits.
+func newLimitStore(conn *sql.DB) (ratelimit.Store, error) {
+	switch v := os.Getenv("LOGIN_LIMIT_STORE"); v {
+	case "", "postgres":
+		return &dbrepo.PostgresLimitStore{DB: conn}, nil
+	case "memory":
+		return ratelimit.NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("invalid LOGIN_LIMIT_STORE %q", v)
+	}
+}
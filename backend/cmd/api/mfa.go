@@ -0,0 +1,373 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/totp"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// totpIssuer names the app in authenticator apps.
+	totpIssuer = "Financial Planner"
+
+	// mfaChallengeExpiry is how long a user has, after their password checks out, to
+	// enter a code.
+	mfaChallengeExpiry = time.Minute * 5
+
+	// mfaPurpose is what challenge tokens are signed for.
+	mfaPurpose = "mfa-challenge"
+
+	// recoveryCodeCount is how many recovery codes a user gets.
+	recoveryCodeCount = 10
+)
+
+// errInvalidMFACode is returned for a code that is neither the current authenticator code
+// nor an unused recovery code.
+var errInvalidMFACode = errors.New("invalid authentication code")
+
+// MFAChallenge is what authenticate returns, instead of tokens, for a user with two-factor
+// authentication on. The token is sent back to /authenticate/mfa with a code.
+type MFAChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+// finish signing in with the challenge token from authenticate and either the current
+// code of the authenticator app or a recovery code, and return a JWT
+func (app *application) authenticateMFA(w http.ResponseWriter, r *http.Request) {
+	log.Printf("authenticateMFA endpoint hit\n")
+	var requestPayload struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	user, ok := app.checkSignedToken(mfaPurpose, requestPayload.MFAToken, time.Now(), mfaBinding)
+	if !ok || user.TOTPEnabledAt == nil {
+		app.errorJSON(w, errors.New("sign in has expired, please start again"), http.StatusUnauthorized)
+		return
+	}
+
+	// codes are guessed more easily than passwords, so they count against the same limits
+	if !app.checkLoginLimits(w, r, user.Email) {
+		return
+	}
+
+	err = app.checkMFACode(user, requestPayload.Code, time.Now())
+	if err != nil {
+		if errors.Is(err, errInvalidMFACode) {
+			app.loginFailed(r, user.Email, &user.ID, models.LoginInvalidMFACode)
+			app.errorJSON(w, err, http.StatusUnauthorized)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	app.loginPassed(r, user.Email)
+	app.loginSucceeded(r, user.Email, user.ID, models.LoginSucceeded)
+
+	// create a jwt user
+	u := jwtUser{
+		ID:        user.ID,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+	}
+
+	// generate tokens, and start a session for the refresh token
+	tokens, err := app.startSession(w, r, &u)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusAccepted, tokens)
+}
+
+// returns whether the user has two-factor authentication on, and how many recovery codes
+// they have left
+func (app *application) MFAStatus(w http.ResponseWriter, r *http.Request) {
+	log.Printf("MFAStatus endpoint hit\n")
+	user, ok := app.userFromContext(w, r)
+	if !ok {
+		return
+	}
+
+	status := struct {
+		Enabled       bool       `json:"enabled"`
+		EnabledAt     *time.Time `json:"enabled_at"`
+		RecoveryCodes int        `json:"recovery_codes"`
+	}{
+		Enabled:   user.TOTPEnabledAt != nil,
+		EnabledAt: user.TOTPEnabledAt,
+	}
+
+	if status.Enabled {
+		n, err := app.DB.CountRecoveryCodes(user.ID)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		status.RecoveryCodes = n
+	}
+
+	app.writeJSON(w, http.StatusOK, status)
+}
+
+// starts enrolling the user in two-factor authentication, and returns the new secret and
+// its otpauth URI for their authenticator app. Nothing changes at sign in until a code
+// from the app is confirmed
+func (app *application) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("EnrollTOTP endpoint hit\n")
+	user, ok := app.userFromContext(w, r)
+	if !ok {
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.DB.SetTOTPSecret(user.ID, secret)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("two-factor authentication is already on"))
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	enrollment := struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}{
+		Secret: secret,
+		URI:    totp.URI(totpIssuer, user.Email, secret),
+	}
+
+	app.writeJSON(w, http.StatusOK, enrollment)
+}
+
+// turns two-factor authentication on once the user enters a code from their app, and
+// returns their recovery codes; this is the only time they are shown
+func (app *application) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("ConfirmTOTP endpoint hit\n")
+	user, ok := app.userFromContext(w, r)
+	if !ok {
+		return
+	}
+
+	var requestPayload struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if user.TOTPEnabledAt != nil {
+		app.errorJSON(w, errors.New("two-factor authentication is already on"))
+		return
+	}
+	if user.TOTPSecret == "" {
+		app.errorJSON(w, errors.New("two-factor authentication has not been set up"))
+		return
+	}
+
+	step, ok := totp.Validate(user.TOTPSecret, requestPayload.Code, time.Now())
+	if !ok {
+		app.errorJSON(w, errInvalidMFACode)
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.DB.EnableTOTP(user.ID, step, hashes, time.Now())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("two-factor authentication has not been set up"))
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "two-factor authentication enabled",
+		Data: struct {
+			RecoveryCodes []string `json:"recovery_codes"`
+		}{codes},
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// turns two-factor authentication off, given the user's password and a current or
+// recovery code
+func (app *application) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("DisableTOTP endpoint hit\n")
+	user, ok := app.userFromContext(w, r)
+	if !ok {
+		return
+	}
+
+	var requestPayload struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if user.TOTPEnabledAt == nil {
+		app.errorJSON(w, errors.New("two-factor authentication is not on"))
+		return
+	}
+
+	valid, err := user.PasswordMatches(requestPayload.Password)
+	if err != nil || !valid {
+		app.errorJSON(w, errors.New("invalid credentials"))
+		return
+	}
+
+	err = app.checkMFACode(user, requestPayload.Code, time.Now())
+	if err != nil {
+		if errors.Is(err, errInvalidMFACode) {
+			app.errorJSON(w, err)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.DB.DisableTOTP(user.ID, time.Now())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "two-factor authentication disabled",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// userFromContext loads the user the request was authenticated as, writing the error
+// response and returning false if that fails.
+func (app *application) userFromContext(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	userID, err := app.getUserIDFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return nil, false
+	}
+
+	user, err := app.DB.GetUserByID(userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("unknown user"), http.StatusUnauthorized)
+			return nil, false
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// mfaChallenge returns the challenge token that lets user finish signing in with a code.
+func (app *application) mfaChallenge(user *models.User) MFAChallenge {
+	return MFAChallenge{
+		MFARequired: true,
+		MFAToken:    app.signToken(mfaPurpose, user.ID, time.Now().Add(mfaChallengeExpiry), mfaBinding(user)),
+	}
+}
+
+// mfaBinding ties a challenge token to the user's password and secret, so it stops
+// working if either changes.
+func mfaBinding(user *models.User) string {
+	return user.Password + "\n" + user.TOTPSecret
+}
+
+// checkMFACode uses up code for user: either the authenticator code of the current time,
+// which is then refused until the next one, or one of their recovery codes. It returns
+// errInvalidMFACode if the code is neither.
+func (app *application) checkMFACode(user *models.User, code string, now time.Time) error {
+	code = strings.TrimSpace(code)
+
+	if len(code) == totp.Digits {
+		step, ok := totp.Validate(user.TOTPSecret, code, now)
+		if !ok {
+			return errInvalidMFACode
+		}
+		err := app.DB.UseTOTPStep(user.ID, step)
+		if errors.Is(err, sql.ErrNoRows) {
+			return errInvalidMFACode
+		}
+		return err
+	}
+
+	err := app.DB.UseRecoveryCode(user.ID, hashToken(normalizeRecoveryCode(code)), now)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errInvalidMFACode
+	}
+	return err
+}
+
+// generateRecoveryCodes returns n new recovery codes, formatted like ABCD-EFGH-IJKL-MNOP,
+// and the hashes to store for them.
+func generateRecoveryCodes(n int) ([]string, []string, error) {
+	codes := make([]string, n)
+	hashes := make([]string, n)
+
+	for i := range codes {
+		b := make([]byte, 10)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// 80 bits, as 16 characters that are easy to read back
+		raw := base32.StdEncoding.EncodeToString(b)
+		codes[i] = raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16]
+		hashes[i] = hashToken(raw)
+	}
+
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode undoes the formatting of a recovery code as it may be typed.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, code)
+}
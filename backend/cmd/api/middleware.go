@@ -2,14 +2,20 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type contextKey string
 
 const claimsKey contextKey = "claims"
 
+// accessTokenKey holds the personal access token a request was made with, if any.
+const accessTokenKey contextKey = "access_token"
+
 func (app *application) enableCORS(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
@@ -17,7 +23,7 @@ func (app *application) enableCORS(h http.Handler) http.Handler {
 		if r.Method == "OPTIONS" {
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
 			w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, X-CSRF-Token, Authorization")
+			w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, X-CSRF-Token, Authorization, X-Household-ID")
 			return
 		} else {
 			h.ServeHTTP(w, r)
@@ -27,16 +33,39 @@ func (app *application) enableCORS(h http.Handler) http.Handler {
 
 func (app *application) authRequired(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, claims, err := app.auth.GetTokenFromHeaderAndVerify(w, r)
+		claims, accessToken, err := app.verifyRequest(w, r)
 		if err != nil {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
 
 		log.Printf("JWT verified, storing claims in context\n")
+
+		// keep out accounts whose address the email policy wants verified first
+		if app.emailPolicy.RequireVerified {
+			userID, err := strconv.Atoi(claims.Subject)
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			user, err := app.DB.GetUserByID(userID)
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			if !app.emailPolicy.Allows(user, time.Now()) {
+				app.errorJSON(w, errors.New("email address not verified"), http.StatusForbidden)
+				return
+			}
+		}
 		
 		// Store the claims in the request context
 		ctx := context.WithValue(r.Context(), claimsKey, claims)
+		if accessToken != nil {
+			ctx = context.WithValue(ctx, accessTokenKey, accessToken)
+		}
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
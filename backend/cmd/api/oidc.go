@@ -0,0 +1,365 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/oidc"
+	"backend/internal/repository"
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// oidcLoginExpiry is how long a user has to sign in at the provider and come back.
+	oidcLoginExpiry = time.Minute * 10
+
+	// oidcCookieName holds the state, nonce and PKCE verifier of a login in progress.
+	oidcCookieName = "app_oidc_login"
+
+	// oidcPurpose is what login cookies are signed for.
+	oidcPurpose = "oidc-login"
+
+	// oidcTimeout bounds the calls to a provider during a callback.
+	oidcTimeout = time.Second * 15
+)
+
+// errOIDCLogin is returned for a callback that does not match a login in progress.
+var errOIDCLogin = errors.New("sign in has expired or was started elsewhere, please start again")
+
+// providerName is what a provider may be configured as.
+var providerName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// oidcState is a login in progress, kept in a signed cookie between login and callback.
+type oidcState struct {
+	Provider string `json:"p"`
+	State    string `json:"s"`
+	Nonce    string `json:"n"`
+	Verifier string `json:"v"`
+	Expires  int64  `json:"e"`
+}
+
+// returns the names of the identity providers users can sign in with
+func (app *application) AllOIDCProviders(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(app.idProviders))
+	for name := range app.idProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	app.writeJSON(w, http.StatusOK, names)
+}
+
+// starts signing in with an identity provider: returns the URL to send the user to, and
+// sets a cookie that ties the callback to this browser
+func (app *application) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	log.Printf("oidcLogin endpoint hit\n")
+	name := chi.URLParam(r, "provider")
+	provider, ok := app.idProviders[name]
+	if !ok {
+		app.errorJSON(w, errors.New("unknown identity provider"), http.StatusNotFound)
+		return
+	}
+
+	login := oidcState{Provider: name, Expires: time.Now().Add(oidcLoginExpiry).Unix()}
+	for _, v := range []*string{&login.State, &login.Nonce, &login.Verifier} {
+		var err error
+		*v, err = oidc.NewVerifier()
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	authURL, err := provider.AuthCodeURL(r.Context(), login.State, login.Nonce, oidc.Challenge(login.Verifier))
+	if err != nil {
+		log.Printf("error starting sign in with %s: %v\n", name, err)
+		app.errorJSON(w, errors.New("identity provider is unavailable"), http.StatusBadGateway)
+		return
+	}
+
+	http.SetCookie(w, app.oidcCookie(app.signOIDCState(login), int(oidcLoginExpiry.Seconds())))
+
+	resp := struct {
+		AuthorizationURL string `json:"authorization_url"`
+	}{authURL}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// finishes signing in with an identity provider, with the code and state it redirected
+// back with. The identity is linked to the user who verified its address, or to a new
+// user; the response is then the same as authenticate's
+func (app *application) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	log.Printf("oidcCallback endpoint hit\n")
+	name := chi.URLParam(r, "provider")
+	provider, ok := app.idProviders[name]
+	if !ok {
+		app.errorJSON(w, errors.New("unknown identity provider"), http.StatusNotFound)
+		return
+	}
+
+	var requestPayload struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// the cookie works once, whatever happens next
+	cookie, err := r.Cookie(oidcCookieName)
+	http.SetCookie(w, app.oidcCookie("", -1))
+	if err != nil {
+		app.errorJSON(w, errOIDCLogin)
+		return
+	}
+
+	login, ok := app.checkOIDCState(cookie.Value, time.Now())
+	if !ok || login.Provider != name || subtle.ConstantTimeCompare([]byte(login.State), []byte(requestPayload.State)) != 1 {
+		app.errorJSON(w, errOIDCLogin)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), oidcTimeout)
+	defer cancel()
+
+	claims, err := provider.Exchange(ctx, requestPayload.Code, login.Verifier, login.Nonce)
+	if err != nil {
+		log.Printf("error signing in with %s: %v\n", name, err)
+		app.errorJSON(w, errors.New("sign in with the identity provider failed"), http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.oidcUser(name, claims)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrDuplicate):
+			app.errorJSON(w, errors.New("an account with this email address already exists; sign in with its password"), http.StatusConflict)
+		case errors.Is(err, errNoOIDCEmail):
+			app.errorJSON(w, err)
+		default:
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// two-factor authentication still applies
+	if user.TOTPEnabledAt != nil {
+		app.recordLogin(r, user.Email, &user.ID, models.LoginMFARequired)
+		app.writeJSON(w, http.StatusAccepted, app.mfaChallenge(user))
+		return
+	}
+	app.loginSucceeded(r, user.Email, user.ID, models.LoginSucceeded)
+
+	// create a jwt user
+	u := jwtUser{
+		ID:        user.ID,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+	}
+
+	// generate tokens, and start a session for the refresh token
+	tokens, err := app.startSession(w, r, &u)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusAccepted, tokens)
+}
+
+// errNoOIDCEmail is returned when a provider shares no address to create a user with.
+var errNoOIDCEmail = errors.New("the identity provider did not share an email address")
+
+// oidcUser returns the user an identity at provider belongs to. An unknown identity is
+// linked to the user with the same address if both the provider and the user verified
+// it, or otherwise gets a new user. An address that an existing user has is refused with
+// repository.ErrDuplicate unless both verified it: an unverified account may have been
+// signed up by someone squatting on the address, who would keep its password and sessions.
+func (app *application) oidcUser(provider string, claims *oidc.Claims) (*models.User, error) {
+	now := time.Now()
+
+	userID, err := app.DB.UseUserIdentity(provider, claims.Subject, now)
+	if err == nil {
+		return app.DB.GetUserByID(userID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	if claims.Email == "" {
+		return nil, errNoOIDCEmail
+	}
+
+	identity := models.UserIdentity{
+		Provider:    provider,
+		Subject:     claims.Subject,
+		Email:       claims.Email,
+		CreatedAt:   now,
+		LastLoginAt: &now,
+	}
+
+	existing, err := app.DB.GetUserByEmail(claims.Email)
+	if err == nil {
+		if !claims.EmailVerified || existing.EmailVerifiedAt == nil {
+			return nil, repository.ErrDuplicate
+		}
+
+		identity.UserID = existing.ID
+		err = app.DB.InsertUserIdentity(&identity)
+		if err != nil {
+			return nil, err
+		}
+		return existing, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	firstName, lastName := claims.GivenName, claims.FamilyName
+	if firstName == "" && lastName == "" {
+		firstName, lastName, _ = strings.Cut(strings.TrimSpace(claims.Name), " ")
+	}
+	if firstName == "" {
+		firstName, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	// no password; the user can choose one with a reset link
+	user := models.User{
+		FirstName:    firstName,
+		LastName:     lastName,
+		Email:        claims.Email,
+		BaseCurrency: models.DefaultCurrency,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if claims.EmailVerified {
+		user.EmailVerifiedAt = &now
+	}
+
+	user.ID, err = app.DB.InsertUserWithIdentity(user, &identity)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.EmailVerifiedAt == nil {
+		app.sendVerification(&user)
+	}
+
+	return &user, nil
+}
+
+// oidcCookie returns the login cookie with value, which lasts maxAge seconds, or is
+// deleted if maxAge is negative. It is Lax rather than Strict, as the user arrives at the
+// callback from the provider's site.
+func (app *application) oidcCookie(value string, maxAge int) *http.Cookie {
+	expires := time.Now().Add(time.Duration(maxAge) * time.Second)
+	if maxAge < 0 {
+		expires = time.Unix(0, 0)
+	}
+
+	return &http.Cookie{
+		Name:     oidcCookieName,
+		Path:     "/auth/oidc",
+		Value:    value,
+		Expires:  expires,
+		MaxAge:   maxAge,
+		SameSite: http.SameSiteLaxMode,
+		Domain:   app.CookieDomain,
+		HttpOnly: true,
+		Secure:   true,
+	}
+}
+
+// signOIDCState encodes a login in progress for its cookie.
+func (app *application) signOIDCState(login oidcState) string {
+	payload, _ := json.Marshal(login)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(app.tokenMAC(oidcPurpose, string(payload), ""))
+}
+
+// checkOIDCState decodes the login in progress of a cookie, if its signature holds and it
+// has not expired.
+func (app *application) checkOIDCState(value string, now time.Time) (oidcState, bool) {
+	var login oidcState
+
+	encoded, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return login, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return login, false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return login, false
+	}
+	if subtle.ConstantTimeCompare(mac, app.tokenMAC(oidcPurpose, string(payload), "")) != 1 {
+		return login, false
+	}
+
+	err = json.Unmarshal(payload, &login)
+	if err != nil || now.Unix() > login.Expires {
+		return login, false
+	}
+
+	return login, true
+}
+
+// newOIDCProviders reads the identity providers named, comma separated, in
+// OIDC_PROVIDERS. For a provider named company, OIDC_COMPANY_ISSUER and
+// OIDC_COMPANY_CLIENT_ID are required; OIDC_COMPANY_CLIENT_SECRET, OIDC_COMPANY_SCOPES
+// and OIDC_COMPANY_REDIRECT_URL are optional. The redirect URL defaults to the
+// frontend's /oidc/callback/company page.
+func newOIDCProviders(domain string) (map[string]*oidc.Provider, error) {
+	providers := make(map[string]*oidc.Provider)
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !providerName.MatchString(name) {
+			return nil, fmt.Errorf("invalid provider name %q", name)
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+
+		config := oidc.Config{
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+		}
+		if config.Issuer == "" || config.ClientID == "" {
+			return nil, fmt.Errorf("%sISSUER and %sCLIENT_ID are required", prefix, prefix)
+		}
+		if scopes := os.Getenv(prefix + "SCOPES"); scopes != "" {
+			config.Scopes = strings.Fields(scopes)
+		}
+		if config.RedirectURL == "" {
+			config.RedirectURL = frontendBase(domain) + "/oidc/callback/" + name
+		}
+
+		providers[name] = oidc.NewProvider(config)
+	}
+
+	return providers, nil
+}
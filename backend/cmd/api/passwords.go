@@ -0,0 +1,193 @@
+package main
+
+import (
+	"backend/internal/mailer"
+	"backend/internal/models"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordResetExpiry is how long an emailed reset token works.
+const passwordResetExpiry = time.Hour
+
+// minPasswordLength is the shortest password a user may choose.
+const minPasswordLength = 8
+
+// email a password reset link to the user with the given address. The response is the
+// same whether or not the address belongs to a user, so it cannot be used to find out
+func (app *application) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	log.Printf("forgotPassword endpoint hit\n")
+	var requestPayload struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if !app.checkMailLimits(w, r, requestPayload.Email) {
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "if the address belongs to an account, a reset link has been sent to it",
+	}
+
+	user, err := app.DB.GetUserByEmail(requestPayload.Email)
+	if err != nil {
+		app.writeJSON(w, http.StatusAccepted, resp)
+		return
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	reset := models.PasswordReset{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(passwordResetExpiry),
+		CreatedAt: time.Now(),
+	}
+
+	err = app.DB.InsertPasswordReset(&reset)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your account. To choose a new "+
+			"password, open this link within %d minutes:\n\n%s\n\nIf it wasn't you, ignore this email; "+
+			"your password stays the same.\n", user.FirstName, int(passwordResetExpiry.Minutes()),
+			app.frontendURL("/reset-password", url.Values{"token": {token}})),
+	}
+
+	// send in the background, so the response takes as long for unknown addresses
+	go func() {
+		err := app.Mailer.Send(msg)
+		if err != nil {
+			log.Printf("error sending password reset to user %d: %v\n", user.ID, err)
+		}
+	}()
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// set a new password with a token from a reset email; the token then stops working,
+// as do any other reset tokens of the user, all of the user's sessions are revoked and
+// their personal access tokens are deleted
+func (app *application) resetPassword(w http.ResponseWriter, r *http.Request) {
+	log.Printf("resetPassword endpoint hit\n")
+	var requestPayload struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = validatePassword(requestPayload.Password)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	tokenHash := hashToken(requestPayload.Token)
+
+	// check the token before hashing the password, so a made-up token costs no bcrypt
+	_, err = app.DB.PasswordResetUser(tokenHash, time.Now())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("reset link is invalid or has expired"))
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(requestPayload.Password), 14)
+	if err != nil {
+		app.errorJSON(w, errors.New("failed to encrypt password"), http.StatusInternalServerError)
+		return
+	}
+
+	// the token is claimed again here, so one used meanwhile is still refused
+	_, err = app.DB.ResetPassword(tokenHash, string(hash), time.Now())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("reset link is invalid or has expired"))
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "password updated; every session has been signed out and every access token deleted",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// generateToken returns a random token for a link or a header, with 256 bits of entropy.
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the hex SHA-256 of a token, which is what gets stored. Tokens are
+// random, so unlike passwords they need no salt or slow hash.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// frontendURL returns the address of a page of the frontend, served at the app's domain.
+func (app *application) frontendURL(path string, query url.Values) string {
+	return frontendBase(app.Domain) + path + "?" + query.Encode()
+}
+
+// frontendBase returns the root of the frontend served at domain, with no trailing slash.
+func frontendBase(domain string) string {
+	base := strings.TrimRight(domain, "/")
+	if !strings.Contains(base, "://") {
+		base = "https://" + base
+	}
+	return base
+}
+
+// validatePassword checks a new password is long enough.
+func validatePassword(password string) error {
+	if len([]rune(password)) < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	return nil
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxUpcomingDays bounds how far ahead upcoming occurrences may be listed.
+const maxUpcomingDays = 366
+
+// get all recurring templates belonging to the household
+func (app *application) AllRecurring(w http.ResponseWriter, r *http.Request) {
+	log.Printf("AllRecurring endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	templates, err := app.DB.AllRecurring(member.HouseholdID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, templates)
+}
+
+// get one recurring template belonging to the household
+func (app *application) OneRecurring(w http.ResponseWriter, r *http.Request) {
+	log.Printf("OneRecurring endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	template, err := app.DB.OneRecurring(id, member.HouseholdID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("recurring template not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, template)
+}
+
+// insert one recurring template; its due occurrences are posted by the scheduler
+func (app *application) InsertRecurring(w http.ResponseWriter, r *http.Request) {
+	log.Printf("InsertRecurring endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	var template models.RecurringTemplate
+	err = app.readJSON(w, r, &template)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = validateRecurring(&template)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.checkAccountOwner(member.UserID, template.AccountID, template.Currency)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	template.UserID = member.UserID
+	template.HouseholdID = member.HouseholdID
+	template.CreatedAt = time.Now()
+	template.UpdatedAt = time.Now()
+	setRecurringPartyOwner(&template, member)
+
+	_, err = app.repo(r).InsertRecurring(&template)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "recurring template inserted",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// update one recurring template; only the fields present in the payload are changed
+func (app *application) UpdateRecurring(w http.ResponseWriter, r *http.Request) {
+	log.Printf("UpdateRecurring endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	template, err := app.DB.OneRecurring(id, member.HouseholdID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("recurring template not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// the member who set it up keeps it, whoever in the household changes it
+	setBy := template.UserID
+
+	// decode the payload over the stored record, so omitted fields keep their values
+	err = app.readJSON(w, r, template)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = validateRecurring(template)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.checkAccountOwner(setBy, template.AccountID, template.Currency)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	template.ID = id
+	template.UserID = setBy
+	template.HouseholdID = member.HouseholdID
+	template.UpdatedAt = time.Now()
+	setRecurringPartyOwner(template, member)
+
+	err = app.repo(r).UpdateRecurring(template)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("recurring template not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "recurring template updated",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// delete one recurring template; what it already posted is kept
+func (app *application) DeleteRecurring(w http.ResponseWriter, r *http.Request) {
+	log.Printf("DeleteRecurring endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.repo(r).DeleteRecurring(id, member.HouseholdID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("recurring template not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "recurring template deleted",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// list the occurrences of all the household's templates still to be posted over the coming
+// days, soonest first; ?days=N picks how many days, today included (default 30)
+func (app *application) UpcomingRecurring(w http.ResponseWriter, r *http.Request) {
+	log.Printf("UpcomingRecurring endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	days := 30
+	if v := r.URL.Query().Get("days"); v != "" {
+		days, err = strconv.Atoi(v)
+		if err != nil || days < 1 || days > maxUpcomingDays {
+			app.errorJSON(w, fmt.Errorf("days must be between 1 and %d", maxUpcomingDays))
+			return
+		}
+	}
+
+	today := models.Today()
+	to := today.AddDate(0, 0, days-1)
+
+	templates, err := app.DB.AllRecurring(member.HouseholdID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	occurrences, err := app.DB.RecurringOccurrences(member.HouseholdID, today, to)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	type key struct {
+		templateID int
+		date       string
+	}
+	changed := map[key]*models.RecurringOccurrence{}
+	for _, o := range occurrences {
+		changed[key{o.TemplateID, o.Date.Format("2006-01-02")}] = o
+	}
+
+	upcoming := []*models.UpcomingOccurrence{}
+
+	for _, t := range templates {
+		rule, err := t.Schedule()
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		from := today
+		if t.PostedThrough != nil && !t.PostedThrough.Before(from) {
+			from = t.PostedThrough.AddDate(0, 0, 1)
+		}
+
+		for _, date := range rule.Between(t.StartDate, from, to) {
+			o := changed[key{t.ID, date.Format("2006-01-02")}]
+			if o != nil && o.Status == models.OccurrencePosted {
+				continue
+			}
+			upcoming = append(upcoming, t.Upcoming(date, o))
+		}
+	}
+
+	sort.SliceStable(upcoming, func(i, j int) bool {
+		return upcoming[i].Date.Before(upcoming[j].Date)
+	})
+
+	app.writeJSON(w, http.StatusOK, upcoming)
+}
+
+// skip one occurrence of a template, so it is not posted
+func (app *application) SkipOccurrence(w http.ResponseWriter, r *http.Request) {
+	log.Printf("SkipOccurrence endpoint hit\n")
+	app.setOccurrence(w, r, models.OccurrenceSkipped)
+}
+
+// change the amount or description one occurrence of a template is posted with
+func (app *application) OverrideOccurrence(w http.ResponseWriter, r *http.Request) {
+	log.Printf("OverrideOccurrence endpoint hit\n")
+	app.setOccurrence(w, r, models.OccurrenceOverride)
+}
+
+// undo the skip or override of one occurrence, so it is posted as the template says
+func (app *application) RestoreOccurrence(w http.ResponseWriter, r *http.Request) {
+	log.Printf("RestoreOccurrence endpoint hit\n")
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	date, err := parseDateParam(chi.URLParam(r, "date"), "date")
+	if err != nil || date == nil {
+		app.errorJSON(w, errors.New("date must be a date formatted as YYYY-MM-DD"))
+		return
+	}
+
+	err = app.repo(r).DeleteRecurringOccurrence(id, member.HouseholdID, *date)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("occurrence is neither skipped nor overridden"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "occurrence restored",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// setOccurrence skips or overrides the occurrence named by the id and date URL params.
+func (app *application) setOccurrence(w http.ResponseWriter, r *http.Request, status string) {
+	member, err := app.householdFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	date, err := parseDateParam(chi.URLParam(r, "date"), "date")
+	if err != nil || date == nil {
+		app.errorJSON(w, errors.New("date must be a date formatted as YYYY-MM-DD"))
+		return
+	}
+
+	template, err := app.DB.OneRecurring(id, member.HouseholdID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("recurring template not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	rule, err := template.Schedule()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if !rule.Matches(template.StartDate, *date) {
+		app.errorJSON(w, errors.New("the template has no occurrence on that date"))
+		return
+	}
+
+	if template.PostedThrough != nil && !date.After(*template.PostedThrough) {
+		app.errorJSON(w, repository.ErrPosted, http.StatusConflict)
+		return
+	}
+
+	occurrence := models.RecurringOccurrence{
+		TemplateID:  id,
+		HouseholdID: member.HouseholdID,
+		Date:        *date,
+		Status:      status,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+
+	if status == models.OccurrenceOverride {
+		var payload struct {
+			Amount      *models.Money `json:"amount"`
+			Description *string       `json:"description"`
+		}
+
+		err = app.readJSON(w, r, &payload)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+
+		if payload.Amount == nil && payload.Description == nil {
+			app.errorJSON(w, errors.New("an override needs an amount or a description"))
+			return
+		}
+
+		if payload.Amount != nil && *payload.Amount <= 0 {
+			app.errorJSON(w, errors.New("amount must be positive"))
+			return
+		}
+
+		occurrence.Amount = payload.Amount
+		occurrence.Description = payload.Description
+	}
+
+	err = app.repo(r).SetRecurringOccurrence(&occurrence)
+	if err != nil {
+		if errors.Is(err, repository.ErrPosted) {
+			app.errorJSON(w, err, http.StatusConflict)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	message := "occurrence skipped"
+	if status == models.OccurrenceOverride {
+		message = "occurrence overridden"
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: message,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// validateRecurring checks the user-supplied fields of a recurring template, normalizing
+// its currency and filling in its rule.
+func validateRecurring(t *models.RecurringTemplate) error {
+	switch t.Kind {
+	case models.RecurringIncome:
+		if t.Source == nil || t.Source.Name == "" {
+			return errors.New("source name is required")
+		}
+	case models.RecurringExpense:
+		if t.Category == nil || t.Category.Name == "" {
+			return errors.New("category name is required")
+		}
+	default:
+		return errors.New("kind must be income or expense")
+	}
+
+	if t.Amount <= 0 {
+		return errors.New("amount must be positive")
+	}
+
+	if t.Currency != "" {
+		currency, err := models.NormalizeCurrency(t.Currency)
+		if err != nil {
+			return err
+		}
+		t.Currency = currency
+	}
+
+	if t.StartDate.IsZero() {
+		return errors.New("start date is required")
+	}
+
+	if t.EndDate != nil && t.EndDate.Before(t.StartDate) {
+		return errors.New("end date must not be before the start date")
+	}
+
+	return t.SetRule()
+}
+
+// setRecurringPartyOwner stamps the template's source or category with the member's
+// household, so it can be created, as added by the member, if the household doesn't
+// have it yet.
+func setRecurringPartyOwner(t *models.RecurringTemplate, member *models.HouseholdMember) {
+	if t.Source != nil {
+		t.Source.UserID = member.UserID
+		t.Source.HouseholdID = member.HouseholdID
+		t.Source.CreatedAt = time.Now()
+		t.Source.UpdatedAt = time.Now()
+	}
+
+	if t.Category != nil {
+		t.Category.UserID = member.UserID
+		t.Category.HouseholdID = member.HouseholdID
+		t.Category.CreatedAt = time.Now()
+		t.Category.UpdatedAt = time.Now()
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"backend/internal/models"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -11,15 +12,25 @@ func (app *application) routes() http.Handler {
 	// create a router mux
 	mux := chi.NewRouter()
 
+	mux.Use(middleware.RequestID)
 	mux.Use(middleware.Recoverer)
 	mux.Use(app.enableCORS)
 
 	mux.Get("/", app.Home)
+	mux.Get("/.well-known/jwks.json", app.JWKS)
 
 	mux.Post("/authenticate", app.authenticate)
+	mux.Post("/authenticate/mfa", app.authenticateMFA)
 	mux.Post("/signup", app.signup)
 	mux.Get("/refresh", app.refreshToken)
 	mux.Get("/logout", app.logout)
+	mux.Post("/forgot-password", app.forgotPassword)
+	mux.Post("/reset-password", app.resetPassword)
+	mux.Post("/verify-email", app.verifyEmail)
+	mux.Post("/resend-verification", app.resendVerification)
+	mux.Get("/auth/oidc", app.AllOIDCProviders)
+	mux.Get("/auth/oidc/{provider}/login", app.oidcLogin)
+	mux.Post("/auth/oidc/{provider}/callback", app.oidcCallback)
 
 	// --> deprecated
 	mux.Get("/movies", app.AllMovies)
@@ -31,6 +42,8 @@ func (app *application) routes() http.Handler {
 
 	mux.Route("/admin", func(mux chi.Router){
 		mux.Use(app.authRequired)
+		mux.Use(app.enforceScopes)
+		mux.Use(app.enforceHousehold)
 
 		// --> deprecated
 		mux.Get("/movies", app.MovieCatalog)
@@ -43,11 +56,83 @@ func (app *application) routes() http.Handler {
 		// new
 		mux.Get("/incomes", app.AllIncomes)
 		mux.Post("/incomes/new", app.InsertIncome)
+		mux.Patch("/incomes/{id}", app.UpdateIncome)
+		mux.Delete("/incomes/{id}", app.DeleteIncome)
 		mux.Get("/sources", app.AllSources)
 		mux.Get("/expenses", app.AllExpenses)
 		mux.Post("/expenses/new", app.InsertExpense)
+		mux.Patch("/expenses/{id}", app.UpdateExpense)
+		mux.Delete("/expenses/{id}", app.DeleteExpense)
 		mux.Get("/categories", app.AllCategories)
 		mux.Get("/summary", app.GetFinancialSummary)
+		mux.Patch("/user/base-currency", app.UpdateBaseCurrency)
+		mux.Get("/accounts", app.AllAccounts)
+		mux.Post("/accounts/new", app.InsertAccount)
+		mux.Get("/accounts/{id}", app.OneAccount)
+		mux.Patch("/accounts/{id}", app.UpdateAccount)
+		mux.Delete("/accounts/{id}", app.DeleteAccount)
+		mux.Get("/accounts/{id}/ledger", app.AccountLedger)
+		mux.Get("/transfers", app.AllTransfers)
+		mux.Post("/transfers/new", app.InsertTransfer)
+		mux.Delete("/transfers/{id}", app.DeleteTransfer)
+		mux.Get("/budgets", app.AllBudgets)
+		mux.Post("/budgets/new", app.InsertBudget)
+		mux.Get("/budgets/report", app.BudgetReport)
+		mux.Patch("/budgets/{id}", app.UpdateBudget)
+		mux.Delete("/budgets/{id}", app.DeleteBudget)
+		mux.Get("/goals", app.AllGoals)
+		mux.Post("/goals/new", app.InsertGoal)
+		mux.Get("/goals/{id}", app.OneGoal)
+		mux.Patch("/goals/{id}", app.UpdateGoal)
+		mux.Delete("/goals/{id}", app.DeleteGoal)
+		mux.Get("/goals/{id}/progress", app.GoalProgress)
+		mux.Get("/goals/{id}/contributions", app.AllGoalContributions)
+		mux.Post("/goals/{id}/contributions/new", app.InsertGoalContribution)
+		mux.Delete("/goals/{id}/contributions/{contributionID}", app.DeleteGoalContribution)
+		mux.Get("/recurring", app.AllRecurring)
+		mux.Post("/recurring/new", app.InsertRecurring)
+		mux.Get("/recurring/upcoming", app.UpcomingRecurring)
+		mux.Get("/recurring/{id}", app.OneRecurring)
+		mux.Patch("/recurring/{id}", app.UpdateRecurring)
+		mux.Delete("/recurring/{id}", app.DeleteRecurring)
+		mux.Post("/recurring/{id}/occurrences/{date}/skip", app.SkipOccurrence)
+		mux.Put("/recurring/{id}/occurrences/{date}", app.OverrideOccurrence)
+		mux.Delete("/recurring/{id}/occurrences/{date}", app.RestoreOccurrence)
+		mux.Get("/import/mappings", app.AllImportMappings)
+		mux.Post("/import/mappings/new", app.InsertImportMapping)
+		mux.Patch("/import/mappings/{id}", app.UpdateImportMapping)
+		mux.Delete("/import/mappings/{id}", app.DeleteImportMapping)
+		mux.Post("/import/csv/preview", app.PreviewCSVImport)
+		mux.Post("/import/csv/commit", app.CommitCSVImport)
+		mux.Post("/import/ofx/preview", app.PreviewOFXImport)
+		mux.Post("/import/ofx/commit", app.CommitOFXImport)
+		mux.Post("/import/journal/preview", app.PreviewJournalImport)
+		mux.Post("/import/journal/commit", app.CommitJournalImport)
+		mux.Get("/export/journal", app.ExportJournal)
+		mux.Get("/export", app.ExportData)
+		mux.Get("/sessions", app.AllSessions)
+		mux.Delete("/sessions", app.DeleteAllSessions)
+		mux.Delete("/sessions/{id}", app.DeleteSession)
+		mux.Get("/mfa", app.MFAStatus)
+		mux.Post("/mfa/totp/enroll", app.EnrollTOTP)
+		mux.Post("/mfa/totp/confirm", app.ConfirmTOTP)
+		mux.Post("/mfa/totp/disable", app.DisableTOTP)
+		mux.Get("/tokens", app.AllAccessTokens)
+		mux.Post("/tokens/new", app.InsertAccessToken)
+		mux.Delete("/tokens/{id}", app.DeleteAccessToken)
+		mux.Get("/households", app.AllHouseholds)
+		mux.Post("/households/new", app.InsertHousehold)
+		mux.Post("/households/join", app.JoinHousehold)
+		mux.With(app.requireHouseholdRole(models.RoleViewer)).Get("/households/{id}/members", app.AllHouseholdMembers)
+		mux.With(app.requireHouseholdRole(models.RoleViewer)).Delete("/households/{id}/membership", app.LeaveHousehold)
+		mux.With(app.requireHouseholdRole(models.RoleOwner)).Patch("/households/{id}", app.UpdateHousehold)
+		mux.With(app.requireHouseholdRole(models.RoleOwner)).Delete("/households/{id}", app.DeleteHousehold)
+		mux.With(app.requireHouseholdRole(models.RoleOwner)).Patch("/households/{id}/members/{userID}", app.UpdateHouseholdMember)
+		mux.With(app.requireHouseholdRole(models.RoleOwner)).Delete("/households/{id}/members/{userID}", app.DeleteHouseholdMember)
+		mux.With(app.requireHouseholdRole(models.RoleOwner)).Get("/households/{id}/invites", app.AllHouseholdInvites)
+		mux.With(app.requireHouseholdRole(models.RoleOwner)).Post("/households/{id}/invites/new", app.InsertHouseholdInvite)
+		mux.With(app.requireHouseholdRole(models.RoleOwner)).Delete("/households/{id}/invites/{inviteID}", app.DeleteHouseholdInvite)
+		mux.Get("/audit/{entity}/{id}", app.AuditHistory)
 	})
 
 	return mux
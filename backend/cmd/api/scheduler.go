@@ -0,0 +1,104 @@
+package main
+
+import (
+	"backend/internal/models"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// schedulerInterval is how often due recurring occurrences are posted.
+const schedulerInterval = time.Hour
+
+// runScheduler posts due recurring occurrences and removes expired tokens now, and again
+// every interval. It never returns, so run it in its own goroutine.
+func (app *application) runScheduler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		app.postRecurring(models.Today())
+		app.removeExpired(time.Now())
+		<-ticker.C
+	}
+}
+
+// removeExpired deletes the tokens that can no longer be used.
+func (app *application) removeExpired(now time.Time) {
+	_, err := app.DB.DeleteExpiredPasswordResets(now)
+	if err != nil {
+		log.Printf("error removing expired password resets: %v\n", err)
+	}
+
+	_, err = app.DB.DeleteExpiredSessions(now)
+	if err != nil {
+		log.Printf("error removing expired sessions: %v\n", err)
+	}
+
+	_, err = app.DB.DeleteExpiredHouseholdInvites(now)
+	if err != nil {
+		log.Printf("error removing expired household invites: %v\n", err)
+	}
+
+	_, err = app.loginLimits.store.Prune(now.Add(-loginLimitWindow))
+	if err != nil {
+		log.Printf("error removing old failed sign ins: %v\n", err)
+	}
+}
+
+// postRecurring posts every occurrence, up to today, that has not been handled yet.
+// Each occurrence is marked posted in the transaction that posts it, so running it twice,
+// or on two servers at once, never posts an occurrence twice.
+func (app *application) postRecurring(today time.Time) {
+	templates, err := app.DB.DueRecurring(today)
+	if err != nil {
+		log.Printf("error loading recurring templates: %v\n", err)
+		return
+	}
+
+	for _, t := range templates {
+		err = app.postTemplate(t, today)
+		if err != nil {
+			log.Printf("error posting recurring template %d: %v\n", t.ID, err)
+		}
+	}
+}
+
+// postTemplate posts the occurrences of one template from where it was last posted
+// through today.
+func (app *application) postTemplate(t *models.RecurringTemplate, today time.Time) error {
+	rule, err := t.Schedule()
+	if err != nil {
+		return err
+	}
+
+	from := t.StartDate
+	if t.PostedThrough != nil {
+		from = t.PostedThrough.AddDate(0, 0, 1)
+	}
+
+	for _, date := range rule.Between(t.StartDate, from, today) {
+		err = app.postOccurrence(t, date)
+		if err != nil {
+			// stop here, so this occurrence is tried again on the next run
+			if date.After(from) {
+				_ = app.DB.SetRecurringPostedThrough(t.ID, date.AddDate(0, 0, -1))
+			}
+			return err
+		}
+	}
+
+	return app.DB.SetRecurringPostedThrough(t.ID, today)
+}
+
+// postOccurrence posts one occurrence of a template through the same insert path as a
+// hand-entered income or expense, unless it is skipped or already posted.
+func (app *application) postOccurrence(t *models.RecurringTemplate, date time.Time) error {
+	err := app.DB.PostRecurringOccurrence(t, date)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+
+	return err
+}
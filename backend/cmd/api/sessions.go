@@ -0,0 +1,158 @@
+package main
+
+import (
+	"backend/internal/models"
+	"database/sql"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxUserAgentLength is how much of a user agent is kept with a session.
+const maxUserAgentLength = 512
+
+// returns the sessions of the user that can still be refreshed; the one of the request's
+// refresh cookie is marked as current
+func (app *application) AllSessions(w http.ResponseWriter, r *http.Request) {
+	log.Printf("AllSessions endpoint hit\n")
+	userID, err := app.getUserIDFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	sessions, err := app.DB.AllSessions(userID, time.Now())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if refreshToken, ok := app.refreshCookie(r); ok {
+		hash := hashToken(refreshToken)
+		for _, s := range sessions {
+			s.Current = s.TokenHash == hash
+		}
+	}
+
+	app.writeJSON(w, http.StatusOK, sessions)
+}
+
+// revokes one session of the user, logging that device out once its access token expires
+func (app *application) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	log.Printf("DeleteSession endpoint hit\n")
+	userID, err := app.getUserIDFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	err = app.DB.RevokeUserSession(userID, chi.URLParam(r, "id"), time.Now())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("session not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "session revoked",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// revokes every session of the user, this one included, logging them out everywhere
+func (app *application) DeleteAllSessions(w http.ResponseWriter, r *http.Request) {
+	log.Printf("DeleteAllSessions endpoint hit\n")
+	userID, err := app.getUserIDFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	_, err = app.DB.RevokeAllSessions(userID, time.Now())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, app.auth.GetExpiredRefreshCookie())
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "logged out everywhere",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// startSession issues a token pair for a new login of user, stores its refresh token as
+// the first session of a new family and sets it as the refresh cookie.
+func (app *application) startSession(w http.ResponseWriter, r *http.Request, user *jwtUser) (TokenPairs, error) {
+	tokens, err := app.auth.GenerateTokenPair(user)
+	if err != nil {
+		return TokenPairs{}, err
+	}
+
+	familyID, err := generateToken()
+	if err != nil {
+		return TokenPairs{}, err
+	}
+
+	session := models.Session{
+		UserID:     user.ID,
+		FamilyID:   familyID,
+		TokenHash:  hashToken(tokens.RefreshToken),
+		UserAgent:  userAgent(r),
+		IPAddress:  clientIP(r),
+		ExpiresAt:  time.Now().Add(app.auth.RefreshExpiry),
+		CreatedAt:  time.Now(),
+		LastUsedAt: time.Now(),
+	}
+
+	err = app.DB.InsertSession(&session)
+	if err != nil {
+		return TokenPairs{}, err
+	}
+
+	http.SetCookie(w, app.auth.GetRefreshCookie(tokens.RefreshToken))
+
+	return tokens, nil
+}
+
+// refreshCookie returns the refresh token the request carries, if any.
+func (app *application) refreshCookie(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(app.auth.CookieName)
+	if err != nil || cookie.Value == "" {
+		return "", false
+	}
+	return cookie.Value, true
+}
+
+// userAgent returns the user agent of the request as valid UTF-8, cut to
+// maxUserAgentLength bytes.
+func userAgent(r *http.Request) string {
+	ua := r.UserAgent()
+	if len(ua) > maxUserAgentLength {
+		ua = ua[:maxUserAgentLength]
+	}
+	return strings.ToValidUTF8(ua, "")
+}
+
+// clientIP returns the address the request came from. Forwarding headers are ignored,
+// as any client can set them.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
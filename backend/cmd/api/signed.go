@@ -0,0 +1,71 @@
+package main
+
+import (
+	"backend/internal/models"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signToken returns a token naming a user until expires, signed for one purpose together
+// with binding, some state of the user that makes the token stop working once it changes.
+// Unlike access tokens these are not JWTs, so neither can ever pass for the other.
+func (app *application) signToken(purpose string, userID int, expires time.Time, binding string) string {
+	payload := fmt.Sprintf("%d:%d", userID, expires.Unix())
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(app.tokenMAC(purpose, payload, binding))
+}
+
+// checkSignedToken returns the user a token was signed for, if it was signed for purpose,
+// has not expired, and its signature holds for what binding returns for the user now.
+func (app *application) checkSignedToken(purpose, token string, now time.Time, binding func(*models.User) string) (*models.User, bool) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, false
+	}
+
+	id, exp, ok := strings.Cut(string(payload), ":")
+	if !ok {
+		return nil, false
+	}
+	userID, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, false
+	}
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || now.Unix() > expires {
+		return nil, false
+	}
+
+	user, err := app.DB.GetUserByID(userID)
+	if err != nil {
+		return nil, false
+	}
+
+	if !hmac.Equal(mac, app.tokenMAC(purpose, string(payload), binding(user))) {
+		return nil, false
+	}
+
+	return user, true
+}
+
+// tokenMAC signs the payload and binding of a token. The purpose is signed too, so a
+// token signed for one purpose is no good for another.
+func (app *application) tokenMAC(purpose, payload, binding string) []byte {
+	h := hmac.New(sha256.New, []byte(app.JWTSecret))
+	h.Write([]byte(purpose + "\n" + payload + "\n" + binding))
+	return h.Sum(nil)
+}
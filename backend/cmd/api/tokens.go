@@ -0,0 +1,221 @@
+package main
+
+import (
+	"backend/internal/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// scopeResources maps the first segment of each /admin path to the resource its scopes
+// are named after. Paths missing here, like the ones that manage sessions, two-factor
+// authentication and the tokens themselves, are closed to access tokens.
+var scopeResources = map[string]string{
+	"incomes":    "incomes",
+	"sources":    "incomes",
+	"expenses":   "expenses",
+	"categories": "expenses",
+	"accounts":   "accounts",
+	"transfers":  "accounts",
+	"budgets":    "budgets",
+	"goals":      "goals",
+	"recurring":  "recurring",
+	"import":     "imports",
+	"export":     "exports",
+	"summary":    "summary",
+	"user":       "profile",
+}
+
+// returns the user's personal access tokens, without the tokens themselves
+func (app *application) AllAccessTokens(w http.ResponseWriter, r *http.Request) {
+	log.Printf("AllAccessTokens endpoint hit\n")
+	userID, err := app.getUserIDFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	tokens, err := app.DB.AllAccessTokens(userID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, tokens)
+}
+
+// creates a personal access token with a name, optional scopes and an optional expiry,
+// and returns it; this is the only time the token is shown
+func (app *application) InsertAccessToken(w http.ResponseWriter, r *http.Request) {
+	log.Printf("InsertAccessToken endpoint hit\n")
+	userID, err := app.getUserIDFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	var requestPayload struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	requestPayload.Name = strings.TrimSpace(requestPayload.Name)
+	if requestPayload.Name == "" || len(requestPayload.Name) > 255 {
+		app.errorJSON(w, errors.New("name must be between 1 and 255 characters"))
+		return
+	}
+
+	err = models.ValidateScopes(requestPayload.Scopes)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if requestPayload.ExpiresAt != nil && !requestPayload.ExpiresAt.After(time.Now()) {
+		app.errorJSON(w, errors.New("expires_at must be in the future"))
+		return
+	}
+
+	secret, err := generateToken()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	token := models.AccessTokenPrefix + secret
+
+	accessToken := models.AccessToken{
+		UserID:    userID,
+		Name:      requestPayload.Name,
+		TokenHash: hashToken(token),
+		Scopes:    requestPayload.Scopes,
+		ExpiresAt: requestPayload.ExpiresAt,
+		CreatedAt: time.Now(),
+	}
+
+	err = app.DB.InsertAccessToken(&accessToken)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "access token created",
+		Data: struct {
+			*models.AccessToken
+			Token string `json:"token"`
+		}{&accessToken, token},
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// revokes one of the user's personal access tokens
+func (app *application) DeleteAccessToken(w http.ResponseWriter, r *http.Request) {
+	log.Printf("DeleteAccessToken endpoint hit\n")
+	userID, err := app.getUserIDFromContext(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.DB.DeleteAccessToken(userID, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("access token not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "access token revoked",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// verifyRequest authenticates the bearer token of a request, which is either an access
+// JWT or a personal access token. For a personal access token, it returns the token
+// too, and claims naming its user.
+func (app *application) verifyRequest(w http.ResponseWriter, r *http.Request) (*Claims, *models.AccessToken, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer "+models.AccessTokenPrefix) {
+		_, claims, err := app.auth.GetTokenFromHeaderAndVerify(w, r)
+		return claims, nil, err
+	}
+
+	w.Header().Add("Vary", "Authorization")
+
+	token, err := app.DB.UseAccessToken(hashToken(strings.TrimPrefix(header, "Bearer ")), time.Now())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, errors.New("invalid access token")
+		}
+		return nil, nil, err
+	}
+
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: fmt.Sprint(token.UserID),
+			Issuer:  app.auth.Issuer,
+		},
+	}
+
+	return claims, token, nil
+}
+
+// enforceScopes keeps requests made with a personal access token to what its scopes
+// allow: GET requests need the read scope of the path's resource, anything else the
+// write scope. Requests made with a JWT pass.
+func (app *application) enforceScopes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := r.Context().Value(accessTokenKey).(*models.AccessToken)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		segment := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/admin/"), "/", 2)[0]
+		resource, ok := scopeResources[segment]
+		if !ok {
+			app.errorJSON(w, errors.New("access tokens cannot be used here"), http.StatusForbidden)
+			return
+		}
+
+		scope := "write:" + resource
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			scope = "read:" + resource
+		}
+
+		if !token.Allows(scope) {
+			app.errorJSON(w, fmt.Errorf("access token lacks the %s scope", scope), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
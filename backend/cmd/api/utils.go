@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"strconv"
@@ -57,6 +58,25 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, data in
 	return nil
 }
 
+// readUpload reads the file sent in the named field of a multipart form, refusing
+// requests larger than maxBytes.
+func (app *application) readUpload(w http.ResponseWriter, r *http.Request, field string, maxBytes int64) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+	err := r.ParseMultipartForm(maxBytes)
+	if err != nil {
+		return nil, fmt.Errorf("upload must be a multipart form of at most %d MB", maxBytes>>20)
+	}
+
+	file, _, err := r.FormFile(field)
+	if err != nil {
+		return nil, fmt.Errorf("upload has no %s file", field)
+	}
+	defer file.Close()
+
+	return io.ReadAll(file)
+}
+
 func (app *application) errorJSON(w http.ResponseWriter, err error, status ...int) error {
 	statusCode := http.StatusBadRequest
 
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"backend/internal/mailer"
+	"backend/internal/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// verificationExpiry is how long an emailed verification link works.
+const verificationExpiry = time.Hour * 48
+
+// errInvalidVerification is returned for a verification link that is malformed, forged,
+// expired or for an address the user no longer has.
+var errInvalidVerification = errors.New("verification link is invalid or has expired")
+
+// EmailPolicy is what authRequired demands of the email address of an account.
+type EmailPolicy struct {
+	RequireVerified bool          // Whether unverified accounts are kept out of /admin
+	Grace           time.Duration // How long after signup an unverified account is let in anyway
+}
+
+// Allows reports whether the policy lets user into /admin at now. Accounts created before
+// addresses were verified are let in regardless, as they were never sent a link.
+func (p EmailPolicy) Allows(user *models.User, now time.Time) bool {
+	if !p.RequireVerified || !user.EmailVerificationRequired || user.EmailVerifiedAt != nil {
+		return true
+	}
+	return now.Before(user.CreatedAt.Add(p.Grace))
+}
+
+// confirm the email address of an account with the token of a verification link
+func (app *application) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	log.Printf("verifyEmail endpoint hit\n")
+	var requestPayload struct {
+		Token string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	user, err := app.checkVerificationToken(requestPayload.Token, time.Now())
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.DB.VerifyUserEmail(user.ID, user.Email, time.Now())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errInvalidVerification)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "email verified",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// send a new verification link to the given address, if it belongs to an unverified
+// account. The response is the same either way, so it cannot be used to find out which
+// addresses have accounts
+func (app *application) resendVerification(w http.ResponseWriter, r *http.Request) {
+	log.Printf("resendVerification endpoint hit\n")
+	var requestPayload struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if !app.checkMailLimits(w, r, requestPayload.Email) {
+		return
+	}
+
+	user, err := app.DB.GetUserByEmail(requestPayload.Email)
+	if err == nil && user.EmailVerifiedAt == nil {
+		app.sendVerification(user)
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "if the address belongs to an unverified account, a verification link has been sent to it",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// sendVerification emails user a link to verify their address, in the background.
+func (app *application) sendVerification(user *models.User) {
+	token := app.verificationToken(user, time.Now().Add(verificationExpiry))
+
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm this is your email address by opening this link within "+
+			"%d hours:\n\n%s\n\nIf you didn't sign up, ignore this email.\n", user.FirstName,
+			int(verificationExpiry.Hours()), app.frontendURL("/verify-email", url.Values{"token": {token}})),
+	}
+
+	go func() {
+		err := app.Mailer.Send(msg)
+		if err != nil {
+			log.Printf("error sending verification to user %d: %v\n", user.ID, err)
+		}
+	}()
+}
+
+// verificationPurpose is what verification tokens are signed for.
+const verificationPurpose = "email-verification"
+
+// verificationToken returns the token of a verification link for the user's current
+// address, so the link stops working if the address changes.
+func (app *application) verificationToken(user *models.User, expires time.Time) string {
+	return app.signToken(verificationPurpose, user.ID, expires, verificationBinding(user))
+}
+
+// checkVerificationToken returns the user a verification token was issued to, if its
+// signature holds for their current address and it has not expired.
+func (app *application) checkVerificationToken(token string, now time.Time) (*models.User, error) {
+	user, ok := app.checkSignedToken(verificationPurpose, token, now, verificationBinding)
+	if !ok {
+		return nil, errInvalidVerification
+	}
+	return user, nil
+}
+
+// verificationBinding ties a verification token to the user's address.
+func verificationBinding(user *models.User) string {
+	return strings.ToLower(user.Email)
+}
@@ -0,0 +1,38 @@
+// Command mockoidc runs a mock OpenID Connect provider for local development. It signs
+// in anyone who is sent to it, so never expose it. Point the API at it with, e.g.
+//
+//	OIDC_PROVIDERS=mock
+//	OIDC_MOCK_ISSUER=http://localhost:9000
+//	OIDC_MOCK_CLIENT_ID=financial-planner
+package main
+
+import (
+	"backend/internal/oidc/oidctest"
+	"flag"
+	"log"
+	"net/http"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:9000", "address to listen on")
+	issuer := flag.String("issuer", "http://localhost:9000", "issuer URL the provider is reached at")
+	clientID := flag.String("client-id", "financial-planner", "client id the API uses")
+	clientSecret := flag.String("client-secret", "", "client secret the API must send, if any")
+	email := flag.String("email", "user@example.com", "address of the user signed in without a login_hint")
+	flag.Parse()
+
+	p, err := oidctest.New(*issuer, *clientID)
+	if err != nil {
+		log.Fatalf("Failed to create provider: %v", err)
+	}
+	p.ClientSecret = *clientSecret
+	p.User.Email = *email
+	p.User.Subject = "mock|" + *email
+
+	log.Printf("Mock OpenID Connect provider %s listening on %s\n", p.Issuer, *addr)
+
+	err = http.ListenAndServe(*addr, p)
+	if err != nil {
+		log.Fatalf("Failed to start server: %v", err)
+	}
+}
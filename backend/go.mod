@@ -3,18 +3,21 @@ module backend
 go 1.19
 
 require (
-	github.com/go-chi/chi/v5 v5.0.7 // indirect
-	github.com/golang-jwt/jwt/v4 v4.4.2 // indirect
-	github.com/graphql-go/graphql v0.8.0 // indirect
+	github.com/go-chi/chi/v5 v5.0.7
+	github.com/golang-jwt/jwt/v4 v4.4.2
+	github.com/graphql-go/graphql v0.8.0
+	github.com/jackc/pgconn v1.13.0
+	github.com/jackc/pgx/v4 v4.17.2
+	github.com/joho/godotenv v1.5.1
+	golang.org/x/crypto v0.0.0-20220722155217-630584e8d5aa
+)
+
+require (
 	github.com/jackc/chunkreader/v2 v2.0.1 // indirect
-	github.com/jackc/pgconn v1.13.0 // indirect
 	github.com/jackc/pgio v1.0.0 // indirect
 	github.com/jackc/pgpassfile v1.0.0 // indirect
 	github.com/jackc/pgproto3/v2 v2.3.1 // indirect
 	github.com/jackc/pgservicefile v0.0.0-20200714003250-2b9c44734f2b // indirect
 	github.com/jackc/pgtype v1.12.0 // indirect
-	github.com/jackc/pgx/v4 v4.17.2 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
-	golang.org/x/crypto v0.0.0-20220722155217-630584e8d5aa // indirect
 	golang.org/x/text v0.3.7 // indirect
 )
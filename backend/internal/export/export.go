@@ -0,0 +1,209 @@
+// Package export writes a user's data as a series of tables, streamed row by row, as
+// CSV, JSON or XLSX.
+package export
+
+import (
+	"archive/zip"
+	"backend/internal/models"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats data can be exported in.
+const (
+	CSV  = "csv"  // A zip archive holding one CSV file per table
+	JSON = "json" // One object holding an array of row objects per table
+	XLSX = "xlsx" // A workbook with one sheet per table
+)
+
+// Writer writes tables one after the other. Values may be strings, ints, *ints (nil
+// for none), models.Money or time.Time, which is written as a date.
+type Writer interface {
+	// Table ends the current table, if any, and starts the next.
+	Table(name string, columns []string) error
+	// Row writes one row of the current table, one value per column.
+	Row(values ...interface{}) error
+	// Close ends the last table and the file.
+	Close() error
+}
+
+// NewWriter returns a Writer for the given format.
+func NewWriter(w io.Writer, format string) (Writer, error) {
+	switch format {
+	case CSV:
+		return &csvWriter{zw: zip.NewWriter(w)}, nil
+	case JSON:
+		return &jsonWriter{w: bufio.NewWriter(w)}, nil
+	case XLSX:
+		return newXLSXWriter(w), nil
+	}
+	return nil, fmt.Errorf("export format must be %s, %s or %s", CSV, JSON, XLSX)
+}
+
+// ContentType returns the media type of an export in format, and the extension of its file.
+func ContentType(format string) (string, string) {
+	switch format {
+	case CSV:
+		return "application/zip", "zip"
+	case XLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "xlsx"
+	default:
+		return "application/json", "json"
+	}
+}
+
+// text formats a value for a format without types of its own.
+func text(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case *int:
+		if v == nil {
+			return ""
+		}
+		return strconv.Itoa(*v)
+	case models.Money:
+		return v.String()
+	case time.Time:
+		return v.Format("2006-01-02")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+type csvWriter struct {
+	zw *zip.Writer
+	cw *csv.Writer
+}
+
+func (c *csvWriter) Table(name string, columns []string) error {
+	err := c.flush()
+	if err != nil {
+		return err
+	}
+
+	f, err := c.zw.Create(name + ".csv")
+	if err != nil {
+		return err
+	}
+
+	c.cw = csv.NewWriter(f)
+	return c.cw.Write(columns)
+}
+
+func (c *csvWriter) Row(values ...interface{}) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		record[i] = text(v)
+		if s, ok := v.(string); ok {
+			record[i] = escapeFormula(s)
+		}
+	}
+	return c.cw.Write(record)
+}
+
+// escapeFormula keeps a string, which may come from an imported bank statement, from
+// being run as a formula when the CSV is opened in a spreadsheet, by quoting it with a
+// leading ' if it starts like one. XLSX needs none of this, as it writes inline strings.
+func escapeFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func (c *csvWriter) Close() error {
+	err := c.flush()
+	if err != nil {
+		return err
+	}
+	return c.zw.Close()
+}
+
+func (c *csvWriter) flush() error {
+	if c.cw == nil {
+		return nil
+	}
+	c.cw.Flush()
+	return c.cw.Error()
+}
+
+type jsonWriter struct {
+	w       *bufio.Writer
+	columns [][]byte // Columns of the current table, already encoded as keys
+	tables  int
+	rows    int
+}
+
+func (j *jsonWriter) Table(name string, columns []string) error {
+	if j.tables == 0 {
+		j.w.WriteString("{")
+	} else {
+		j.w.WriteString("],")
+	}
+	j.tables++
+	j.rows = 0
+
+	j.columns = make([][]byte, len(columns))
+	for i, column := range columns {
+		j.columns[i], _ = json.Marshal(column)
+	}
+
+	key, _ := json.Marshal(name)
+	j.w.Write(key)
+	_, err := j.w.WriteString(":[")
+	return err
+}
+
+func (j *jsonWriter) Row(values ...interface{}) error {
+	if j.rows > 0 {
+		j.w.WriteString(",")
+	}
+	j.rows++
+
+	j.w.WriteString("{")
+	for i, v := range values {
+		if i > 0 {
+			j.w.WriteString(",")
+		}
+
+		switch t := v.(type) {
+		case time.Time:
+			v = t.Format("2006-01-02")
+		case *int:
+			if t == nil {
+				v = nil
+			} else {
+				v = *t
+			}
+		}
+
+		value, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		j.w.Write(j.columns[i])
+		j.w.WriteString(":")
+		j.w.Write(value)
+	}
+	_, err := j.w.WriteString("}")
+	return err
+}
+
+func (j *jsonWriter) Close() error {
+	if j.tables == 0 {
+		j.w.WriteString("{")
+	} else {
+		j.w.WriteString("]")
+	}
+	j.w.WriteString("}\n")
+	return j.w.Flush()
+}
@@ -0,0 +1,209 @@
+package export
+
+import (
+	"archive/zip"
+	"backend/internal/models"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Cell styles, indexes into the cellXfs of xlsxStyles.
+const (
+	styleDefault = 0
+	styleDate    = 1
+	styleMoney   = 2
+	styleHeader  = 3
+)
+
+// excelEpoch is day zero of the serial dates spreadsheets store.
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// xlsxWriter writes a workbook with just the parts spreadsheets need, one sheet at a
+// time, so no sheet is ever held in memory.
+type xlsxWriter struct {
+	zw     *zip.Writer
+	sheet  *bufio.Writer
+	sheets []string
+}
+
+func newXLSXWriter(w io.Writer) *xlsxWriter {
+	return &xlsxWriter{zw: zip.NewWriter(w)}
+}
+
+func (x *xlsxWriter) Table(name string, columns []string) error {
+	err := x.endSheet()
+	if err != nil {
+		return err
+	}
+
+	x.sheets = append(x.sheets, sheetName(name))
+
+	f, err := x.zw.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", len(x.sheets)))
+	if err != nil {
+		return err
+	}
+
+	x.sheet = bufio.NewWriter(f)
+	x.sheet.WriteString(xml.Header)
+	x.sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	x.sheet.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" state="frozen"/></sheetView></sheetViews>`)
+	x.sheet.WriteString(`<sheetData>`)
+
+	x.sheet.WriteString("<row>")
+	for _, column := range columns {
+		x.stringCell(column, styleHeader)
+	}
+	_, err = x.sheet.WriteString("</row>")
+	return err
+}
+
+func (x *xlsxWriter) Row(values ...interface{}) error {
+	x.sheet.WriteString("<row>")
+
+	for _, v := range values {
+		switch v := v.(type) {
+		case int:
+			fmt.Fprintf(x.sheet, "<c><v>%d</v></c>", v)
+		case *int:
+			if v == nil {
+				x.sheet.WriteString("<c/>")
+			} else {
+				fmt.Fprintf(x.sheet, "<c><v>%d</v></c>", *v)
+			}
+		case models.Money:
+			fmt.Fprintf(x.sheet, `<c s="%d"><v>%s</v></c>`, styleMoney, v)
+		case time.Time:
+			days := v.Sub(excelEpoch).Hours() / 24
+			fmt.Fprintf(x.sheet, `<c s="%d"><v>%s</v></c>`, styleDate, strconv.FormatFloat(days, 'f', -1, 64))
+		default:
+			x.stringCell(text(v), styleDefault)
+		}
+	}
+
+	_, err := x.sheet.WriteString("</row>")
+	return err
+}
+
+func (x *xlsxWriter) Close() error {
+	err := x.endSheet()
+	if err != nil {
+		return err
+	}
+
+	var workbook, rels, types strings.Builder
+
+	workbook.WriteString(xml.Header)
+	workbook.WriteString(`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+
+	rels.WriteString(xml.Header)
+	rels.WriteString(`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+
+	types.WriteString(xml.Header)
+	types.WriteString(`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+
+	for i, name := range x.sheets {
+		n := i + 1
+		fmt.Fprintf(&workbook, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, escape(name), n, n)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, n, n)
+		fmt.Fprintf(&types, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, n)
+	}
+
+	workbook.WriteString(`</sheets></workbook>`)
+	fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, len(x.sheets)+1)
+	rels.WriteString(`</Relationships>`)
+	types.WriteString(`</Types>`)
+
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", types.String()},
+		{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", workbook.String()},
+		{"xl/_rels/workbook.xml.rels", rels.String()},
+		{"xl/styles.xml", xlsxStyles},
+	}
+
+	for _, part := range parts {
+		f, err := x.zw.Create(part.name)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(f, part.body)
+		if err != nil {
+			return err
+		}
+	}
+
+	return x.zw.Close()
+}
+
+func (x *xlsxWriter) endSheet() error {
+	if x.sheet == nil {
+		return nil
+	}
+
+	x.sheet.WriteString(`</sheetData></worksheet>`)
+	err := x.sheet.Flush()
+	x.sheet = nil
+	return err
+}
+
+func (x *xlsxWriter) stringCell(s string, style int) {
+	if style == styleDefault {
+		x.sheet.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+	} else {
+		fmt.Fprintf(x.sheet, `<c s="%d" t="inlineStr"><is><t xml:space="preserve">`, style)
+	}
+	x.sheet.WriteString(escape(s))
+	x.sheet.WriteString(`</t></is></c>`)
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// sheetName makes a table name a valid sheet name: capitalized, at most 31 characters
+// and without the characters sheet names may not hold.
+func sheetName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '-'
+		}
+		return r
+	}, name)
+
+	runes := []rune(name)
+	if len(runes) > 31 {
+		runes = runes[:31]
+	}
+	if len(runes) > 0 {
+		runes[0] = unicode.ToUpper(runes[0])
+	}
+	return string(runes)
+}
+
+// xlsxStyles defines the cell styles: default, date, two-decimal amount and bold header.
+const xlsxStyles = xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+	`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+	`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="4">` +
+	`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+	`<xf numFmtId="14" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`<xf numFmtId="4" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>` +
+	`</cellXfs></styleSheet>`
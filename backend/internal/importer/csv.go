@@ -0,0 +1,315 @@
+// Package importer reads bank statements into incomes and expenses.
+package importer
+
+import (
+	"backend/internal/models"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateTokens maps the placeholders a date format may use to Go's layout, longest first
+// so that "YYYY" is not read as two "YY".
+var dateTokens = []struct{ token, layout string }{
+	{"YYYY", "2006"},
+	{"YY", "06"},
+	{"MMM", "Jan"},
+	{"MM", "01"},
+	{"M", "1"},
+	{"DD", "02"},
+	{"D", "2"},
+}
+
+// DateLayout turns a date format such as "MM/DD/YYYY" or "D MMM YYYY" into a Go time
+// layout. Anything other than the placeholders YYYY, YY, MMM, MM, M, DD and D is kept
+// as written.
+func DateLayout(format string) (string, error) {
+	format = strings.ToUpper(strings.TrimSpace(format))
+
+	var layout strings.Builder
+	year, month, day := false, false, false
+
+	for i := 0; i < len(format); {
+		matched := false
+		for _, t := range dateTokens {
+			if strings.HasPrefix(format[i:], t.token) {
+				layout.WriteString(t.layout)
+				i += len(t.token)
+				matched = true
+
+				switch t.token[0] {
+				case 'Y':
+					year = true
+				case 'M':
+					month = true
+				case 'D':
+					day = true
+				}
+				break
+			}
+		}
+
+		if !matched {
+			layout.WriteByte(format[i])
+			i++
+		}
+	}
+
+	if !year || !month || !day {
+		return "", fmt.Errorf("date format %q needs a year, a month and a day", format)
+	}
+
+	return layout.String(), nil
+}
+
+// ReadCSV reads a bank statement as mapping describes it, without importing anything.
+// Rows that cannot be read are kept in the preview with an error; the returned error
+// is only for a file that cannot be read at all, e.g. one missing a mapped column.
+func ReadCSV(r io.Reader, mapping *models.ImportMapping) (*models.ImportPreview, error) {
+	layout, err := DateLayout(mapping.DateFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	cr.LazyQuotes = true
+	if mapping.Delimiter != "" {
+		cr.Comma = []rune(mapping.Delimiter)[0]
+	}
+
+	var header []string
+	if mapping.HasHeader {
+		header, err = cr.Read()
+		if err != nil {
+			if err == io.EOF {
+				return nil, errors.New("empty statement file")
+			}
+			return nil, err
+		}
+		if len(header) > 0 {
+			header[0] = strings.TrimPrefix(header[0], "\ufeff")
+		}
+	}
+
+	cols, err := resolveColumns(header, mapping)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := models.ImportPreview{Rows: []*models.ImportRow{}}
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if blank(record) {
+			continue
+		}
+
+		line, _ := cr.FieldPos(0)
+
+		row := readRow(record, cols, layout, mapping)
+		row.Line = line
+
+		switch {
+		case row.Error != "":
+			preview.Errors++
+		case row.Kind == models.ImportIncome:
+			preview.Incomes++
+		default:
+			preview.Expenses++
+		}
+
+		preview.Rows = append(preview.Rows, row)
+	}
+
+	return &preview, nil
+}
+
+// columns holds the index of every mapped column, or -1 for one that isn't mapped.
+type columns struct {
+	date, amount, debit, credit, description, category, source int
+}
+
+func resolveColumns(header []string, mapping *models.ImportMapping) (columns, error) {
+	var cols columns
+	var err error
+
+	find := func(name string) int {
+		if err != nil || name == "" {
+			return -1
+		}
+
+		if header != nil {
+			for i, h := range header {
+				if strings.EqualFold(strings.TrimSpace(h), strings.TrimSpace(name)) {
+					return i
+				}
+			}
+			err = fmt.Errorf("statement has no %q column", name)
+			return -1
+		}
+
+		n, convErr := strconv.Atoi(strings.TrimSpace(name))
+		if convErr != nil || n < 1 {
+			err = fmt.Errorf("column %q must be a column number, as the statement has no header", name)
+			return -1
+		}
+		return n - 1
+	}
+
+	cols.date = find(mapping.DateColumn)
+	cols.amount = find(mapping.AmountColumn)
+	cols.debit = find(mapping.DebitColumn)
+	cols.credit = find(mapping.CreditColumn)
+	cols.description = find(mapping.DescriptionColumn)
+	cols.category = find(mapping.CategoryColumn)
+	cols.source = find(mapping.SourceColumn)
+
+	if mapping.AmountSign == models.SignDebitCredit {
+		cols.amount = -1
+	} else {
+		cols.debit, cols.credit = -1, -1
+	}
+
+	return cols, err
+}
+
+// readRow reads one statement line; a row that can't be read gets an error instead.
+func readRow(record []string, cols columns, layout string, mapping *models.ImportMapping) *models.ImportRow {
+	row := models.ImportRow{Description: field(record, cols.description)}
+
+	date, err := time.Parse(layout, field(record, cols.date))
+	if err != nil {
+		row.Error = fmt.Sprintf("date %q does not match the format %s", field(record, cols.date), mapping.DateFormat)
+		return &row
+	}
+	row.Date = date
+
+	var amount models.Money
+
+	switch mapping.AmountSign {
+	case models.SignDebitCredit:
+		debit, debitErr := parseAmount(field(record, cols.debit))
+		credit, creditErr := parseAmount(field(record, cols.credit))
+		if debitErr != nil {
+			row.Error = debitErr.Error()
+			return &row
+		}
+		if creditErr != nil {
+			row.Error = creditErr.Error()
+			return &row
+		}
+		if debit != 0 && credit != 0 {
+			row.Error = "row has both a debit and a credit"
+			return &row
+		}
+		amount = abs(credit) - abs(debit)
+	default:
+		amount, err = parseAmount(field(record, cols.amount))
+		if err != nil {
+			row.Error = err.Error()
+			return &row
+		}
+		if mapping.AmountSign == models.SignExpensePositive {
+			amount = -amount
+		}
+	}
+
+	if amount == 0 {
+		row.Error = "row has no amount"
+		return &row
+	}
+
+	if amount > 0 {
+		row.Kind = models.ImportIncome
+		row.Amount = amount
+		row.Name = field(record, cols.source)
+		if row.Name == "" {
+			row.Name = mapping.DefaultSource
+		}
+	} else {
+		row.Kind = models.ImportExpense
+		row.Amount = -amount
+		row.Name = field(record, cols.category)
+		if row.Name == "" {
+			row.Name = mapping.DefaultCategory
+		}
+	}
+
+	if row.Name == "" {
+		row.Error = "row has no " + map[string]string{models.ImportIncome: "source", models.ImportExpense: "category"}[row.Kind]
+	}
+
+	return &row
+}
+
+// parseAmount reads an amount as banks write it: with an optional currency symbol and
+// thousands separators, negative with a leading or trailing minus or in parentheses.
+// An empty field is zero.
+func parseAmount(s string) (models.Money, error) {
+	raw := s
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	negative := false
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		negative = true
+		s = s[1 : len(s)-1]
+	}
+	if strings.HasSuffix(s, "-") {
+		negative = !negative
+		s = strings.TrimSuffix(s, "-")
+	}
+
+	s = strings.NewReplacer("$", "", ",", "", " ", "", "\u00a0", "").Replace(s)
+
+	amount, err := models.ParseMoney(s)
+	if err != nil {
+		return 0, fmt.Errorf("amount %q is not a number with at most two decimals", raw)
+	}
+
+	if negative {
+		amount = -amount
+	}
+
+	return amount, nil
+}
+
+// field returns the trimmed value of column i, or "" if the column isn't mapped or the
+// row is too short.
+func field(record []string, i int) string {
+	if i < 0 || i >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+func blank(record []string) bool {
+	for _, v := range record {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func abs(m models.Money) models.Money {
+	if m < 0 {
+		return -m
+	}
+	return m
+}
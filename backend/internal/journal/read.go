@@ -0,0 +1,498 @@
+package journal
+
+import (
+	"backend/internal/models"
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Journal is what Read finds in a journal.
+type Journal struct {
+	Sources    []string              // Income sources the journal declares or opens accounts for
+	Categories []string              // Expense categories the journal declares or opens accounts for
+	Preview    *models.ImportPreview // One row per income or expense posting
+}
+
+// beancountDirectives are the dated Beancount entries other than transactions.
+var beancountDirectives = map[string]bool{
+	"open": true, "close": true, "balance": true, "pad": true, "price": true, "note": true,
+	"document": true, "event": true, "commodity": true, "custom": true, "query": true,
+}
+
+// metadataLine matches a "key: value" line, as Beancount writes metadata and Ledger
+// writes tags in comments. Accounts also hold colons, but never followed by a space.
+var metadataLine = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_-]*):(?:\s+(.*))?$`)
+
+// dateLayouts are the transaction dates Read accepts.
+var dateLayouts = []string{"2006-01-02", "2006/01/02", "2006.01.02"}
+
+// transaction is a journal transaction as read, before it becomes rows.
+type transaction struct {
+	line        int
+	date        time.Time
+	description string
+	meta        map[string]string
+	postings    []*posting
+	err         string
+}
+
+type posting struct {
+	account  string
+	amount   models.Money
+	currency string
+	elided   bool // No amount given; it balances the transaction
+}
+
+// Read reads a Ledger, hledger or Beancount journal. It understands the simple journals
+// Writer produces: transactions whose postings move money between one asset or
+// liability account and accounts under Income and Expenses, each of which becomes an
+// income or expense. Transactions it cannot import, such as transfers between two asset
+// accounts, are kept in the preview with an error. Other directives are skipped.
+func Read(r io.Reader) (*Journal, error) {
+	j := Journal{Preview: &models.ImportPreview{Rows: []*models.ImportRow{}}}
+	declared := map[string]bool{}
+
+	declare := func(account string) {
+		kind, name := split(account)
+		if kind == "" || name == "" || declared[account] {
+			return
+		}
+		declared[account] = true
+		if kind == models.ImportIncome {
+			j.Sources = append(j.Sources, name)
+		} else {
+			j.Categories = append(j.Categories, name)
+		}
+	}
+
+	var current *transaction
+	finish := func() {
+		if current != nil {
+			j.add(current)
+			current = nil
+		}
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if line == 1 {
+			text = strings.TrimPrefix(text, "\ufeff")
+		}
+
+		if strings.TrimSpace(text) == "" {
+			finish()
+			continue
+		}
+
+		if text[0] == ' ' || text[0] == '\t' {
+			if current != nil {
+				current.read(strings.TrimSpace(text))
+			}
+			continue
+		}
+
+		finish()
+
+		fields := strings.Fields(text)
+		switch {
+		case strings.ContainsRune(";#*%|", rune(text[0])):
+			// a comment
+
+		case fields[0] == "account" && len(fields) > 1:
+			declare(stripComment(strings.TrimSpace(text[len("account"):])))
+
+		case text[0] >= '0' && text[0] <= '9':
+			date, ok := parseDate(fields[0])
+			if !ok {
+				j.add(&transaction{line: line, err: fmt.Sprintf("date %q is not a full date like 2024-01-31", fields[0])})
+				continue
+			}
+
+			if len(fields) > 1 && beancountDirectives[fields[1]] {
+				if fields[1] == "open" && len(fields) > 2 {
+					declare(fields[2])
+				}
+				continue
+			}
+
+			current = &transaction{
+				line:        line,
+				date:        date,
+				description: description(strings.TrimSpace(text[len(fields[0]):])),
+				meta:        map[string]string{},
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	finish()
+
+	return &j, nil
+}
+
+// read reads one indented line of a transaction: a posting, metadata or a comment.
+func (t *transaction) read(text string) {
+	if text[0] == ';' || text[0] == '#' {
+		if m := metadataLine.FindStringSubmatch(strings.TrimSpace(strings.TrimLeft(text, ";#"))); m != nil {
+			t.meta[m[1]] = strings.TrimSpace(m[2])
+		}
+		return
+	}
+
+	if m := metadataLine.FindStringSubmatch(text); m != nil {
+		t.meta[m[1]] = unquote(strings.TrimSpace(m[2]))
+		return
+	}
+
+	text = stripComment(text)
+	// a Beancount posting may carry its own flag
+	if len(text) > 2 && (text[0] == '*' || text[0] == '!') && text[1] == ' ' {
+		text = strings.TrimSpace(text[2:])
+	}
+
+	// Ledger's virtual postings need not balance, and are left out
+	if text == "" || text[0] == '(' || text[0] == '[' {
+		return
+	}
+
+	var account, amount string
+	if i := separator(text); i >= 0 {
+		account, amount = text[:i], strings.TrimSpace(text[i:])
+	} else if fields := strings.Fields(text); len(fields) > 1 {
+		// Beancount accounts hold no spaces, so one space may end them
+		account, amount = fields[0], strings.Join(fields[1:], " ")
+	} else {
+		account = text
+	}
+
+	p := posting{account: account, elided: amount == ""}
+	if !p.elided {
+		var err error
+		p.amount, p.currency, err = parseAmount(amount)
+		if err != nil && t.err == "" {
+			t.err = err.Error()
+		}
+	}
+
+	t.postings = append(t.postings, &p)
+}
+
+// add turns a transaction into rows of the preview: one for each income or expense
+// posting, or one with the reason the transaction cannot be imported.
+func (j *Journal) add(t *transaction) {
+	rows := t.rows()
+
+	for _, row := range rows {
+		switch {
+		case row.Error != "":
+			j.Preview.Errors++
+		case row.Kind == models.ImportIncome:
+			j.Preview.Incomes++
+		default:
+			j.Preview.Expenses++
+		}
+	}
+
+	j.Preview.Rows = append(j.Preview.Rows, rows...)
+}
+
+func (t *transaction) rows() []*models.ImportRow {
+	failed := func(reason string) []*models.ImportRow {
+		return []*models.ImportRow{{Line: t.line, Date: t.date, Description: t.description, Error: reason}}
+	}
+
+	if t.err != "" {
+		return failed(t.err)
+	}
+
+	var flows, others []*posting
+	var elided *posting
+	var sum models.Money
+	currency := ""
+
+	for _, p := range t.postings {
+		if kind, _ := split(p.account); kind != "" {
+			flows = append(flows, p)
+		} else {
+			others = append(others, p)
+		}
+
+		if p.elided {
+			if elided != nil {
+				return failed("transaction leaves more than one amount out")
+			}
+			elided = p
+			continue
+		}
+
+		if currency != "" && p.currency != currency {
+			currency = "*"
+		} else if currency == "" {
+			currency = p.currency
+		}
+		sum += p.amount
+	}
+
+	if len(flows) == 0 {
+		return failed("transaction has no posting to an Income or Expenses account")
+	}
+
+	if elided != nil {
+		if currency == "*" {
+			return failed("transaction leaves an amount out, but mixes currencies")
+		}
+		elided.amount, elided.currency = -sum, currency
+	}
+
+	// the money comes from or goes to the single other account, if there is one
+	account := ""
+	if len(others) == 1 {
+		root, name, _ := strings.Cut(others[0].account, ":")
+		if strings.EqualFold(root, AssetRoot) || strings.EqualFold(root, "Liabilities") {
+			account = name
+		}
+	}
+
+	rows := []*models.ImportRow{}
+
+	for _, p := range flows {
+		kind, name := split(p.account)
+		row := models.ImportRow{
+			Line:          t.line,
+			Kind:          kind,
+			Date:          t.date,
+			Description:   t.description,
+			Name:          name,
+			Currency:      p.currency,
+			Account:       account,
+			PaymentMethod: t.meta["payment_method"],
+			ExternalID:    t.meta["external_id"],
+		}
+
+		if kind == models.ImportIncome {
+			row.Amount = -p.amount
+			if row.Amount <= 0 {
+				row.Error = fmt.Sprintf("%s is debited; only money received can be imported as income", p.account)
+			}
+		} else {
+			row.Amount = p.amount
+			if row.Amount <= 0 {
+				row.Error = fmt.Sprintf("%s is credited, e.g. by a refund, which cannot be imported as an expense", p.account)
+			}
+		}
+
+		if name == "" && row.Error == "" {
+			row.Error = fmt.Sprintf("%s names no source or category", p.account)
+		}
+
+		rows = append(rows, &row)
+	}
+
+	if len(flows) > 1 {
+		// an income or expense row can only carry one external id
+		for _, row := range rows {
+			row.ExternalID = ""
+		}
+	}
+
+	return rows
+}
+
+// Resolve names the journal's sources and categories after the user's own wherever the
+// journal's name is the account Writer makes of them, so names changed by an export come
+// back as they were. Each row's account is set to the user's account of the same name.
+func (j *Journal) Resolve(sources []*models.Source, categories []*models.Category, accounts []*models.Account) {
+	sourceNames := []string{}
+	for _, s := range sources {
+		sourceNames = append(sourceNames, s.Name)
+	}
+	categoryNames := []string{}
+	for _, c := range categories {
+		categoryNames = append(categoryNames, c.Name)
+	}
+
+	for i, name := range j.Sources {
+		j.Sources[i] = resolve(name, sourceNames)
+	}
+	for i, name := range j.Categories {
+		j.Categories[i] = resolve(name, categoryNames)
+	}
+
+	for _, row := range j.Preview.Rows {
+		if row.Kind == models.ImportIncome {
+			row.Name = resolve(row.Name, sourceNames)
+		} else if row.Kind == models.ImportExpense {
+			row.Name = resolve(row.Name, categoryNames)
+		}
+
+		if row.Account == "" || row.Account == Unassigned {
+			continue
+		}
+		for _, a := range accounts {
+			if matches(row.Account, a.Name) {
+				id := a.ID
+				row.AccountID = &id
+				break
+			}
+		}
+	}
+}
+
+func resolve(name string, names []string) string {
+	for _, n := range names {
+		if matches(name, n) {
+			return n
+		}
+	}
+	return name
+}
+
+// matches reports whether journalName is what Writer makes of name, in either format.
+func matches(journalName, name string) bool {
+	return strings.EqualFold(journalName, ledgerName(name)) || strings.EqualFold(journalName, beancountName(name))
+}
+
+// split returns the kind of row an account's postings become, ImportIncome,
+// ImportExpense or "" for any other account, and the name of its source or category.
+func split(account string) (string, string) {
+	root, name, _ := strings.Cut(account, ":")
+
+	switch strings.ToLower(root) {
+	case "income", "revenue", "revenues":
+		return models.ImportIncome, name
+	case "expenses", "expense":
+		return models.ImportExpense, name
+	}
+
+	return "", ""
+}
+
+func parseDate(s string) (time.Time, bool) {
+	// a Ledger date may carry an auxiliary date after "="
+	s, _, _ = strings.Cut(s, "=")
+
+	for _, layout := range dateLayouts {
+		if d, err := time.Parse(layout, s); err == nil {
+			return d, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// description reads what follows a transaction's date: a flag, a code in parentheses
+// and then, for Beancount, quoted payee and narration, or for Ledger the description up
+// to any comment.
+func description(s string) string {
+	if strings.HasPrefix(s, "txn ") {
+		s = s[len("txn "):]
+	} else if len(s) > 0 && (s[0] == '*' || s[0] == '!') {
+		s = s[1:]
+	}
+	s = strings.TrimSpace(s)
+
+	if strings.HasPrefix(s, "(") {
+		if i := strings.IndexByte(s, ')'); i >= 0 {
+			s = strings.TrimSpace(s[i+1:])
+		}
+	}
+
+	if !strings.HasPrefix(s, `"`) {
+		return strings.TrimSpace(stripComment(s))
+	}
+
+	var parts []string
+	for strings.HasPrefix(s, `"`) {
+		end := 1
+		for end < len(s) && s[end] != '"' {
+			if s[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(s) {
+			break
+		}
+		if part := unquote(s[:end+1]); part != "" {
+			parts = append(parts, part)
+		}
+		s = strings.TrimSpace(s[end+1:])
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// parseAmount reads a posting's amount, e.g. "12.50 CAD", "CAD 12.50", "-$1,200" or
+// "12.50", ignoring any price or cost after it. Dollars without a code are left in the
+// default currency.
+func parseAmount(s string) (models.Money, string, error) {
+	raw := s
+	if i := strings.IndexAny(s, "@{="); i >= 0 {
+		s = s[:i]
+	}
+
+	var number, commodity strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9' || r == '.' || r == '-' || r == '+':
+			number.WriteRune(r)
+		case r == ',' || r == ' ' || r == '\t':
+		default:
+			commodity.WriteRune(r)
+		}
+	}
+
+	amount, err := models.ParseMoney(number.String())
+	if err != nil {
+		return 0, "", fmt.Errorf("amount %q is not a number with at most two decimals", strings.TrimSpace(raw))
+	}
+
+	switch code := commodity.String(); code {
+	case "", "$":
+		return amount, "", nil
+	case "€":
+		return amount, "EUR", nil
+	case "£":
+		return amount, "GBP", nil
+	default:
+		currency, err := models.NormalizeCurrency(code)
+		if err != nil {
+			return 0, "", fmt.Errorf("commodity %q is not a currency code", code)
+		}
+		return amount, currency, nil
+	}
+}
+
+// separator returns where a Ledger posting's account ends: at a tab or two spaces.
+func separator(s string) int {
+	i := strings.Index(s, "  ")
+	if t := strings.IndexByte(s, '\t'); t >= 0 && (i < 0 || t < i) {
+		i = t
+	}
+	return i
+}
+
+func stripComment(s string) string {
+	if i := strings.IndexByte(s, ';'); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(s[1 : len(s)-1])
+	}
+	return s
+}
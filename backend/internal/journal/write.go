@@ -0,0 +1,183 @@
+// Package journal writes and reads plain-text accounting journals, in the formats of
+// Ledger (which hledger also reads) and Beancount. Income sources become accounts under
+// Income, expense categories accounts under Expenses and the user's accounts accounts
+// under Assets, e.g. Income:Salary, Expenses:Groceries and Assets:Everyday-Chequing.
+package journal
+
+import (
+	"backend/internal/models"
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// Formats a journal can be written in.
+const (
+	Ledger    = "ledger"
+	Beancount = "beancount"
+)
+
+// Roots of the account tree.
+const (
+	IncomeRoot  = "Income"
+	ExpenseRoot = "Expenses"
+	AssetRoot   = "Assets"
+)
+
+// Unassigned is the asset account of incomes and expenses not tied to one of the user's
+// accounts.
+const Unassigned = "Unassigned"
+
+// openDate is the date Beancount accounts are opened on, before any transaction.
+const openDate = "1970-01-01"
+
+// Writer writes incomes and expenses as a journal, one transaction at a time.
+type Writer struct {
+	w      *bufio.Writer
+	format string
+}
+
+// NewWriter returns a Writer for the given format, Ledger or Beancount.
+func NewWriter(w io.Writer, format string) (*Writer, error) {
+	if format != Ledger && format != Beancount {
+		return nil, fmt.Errorf("journal format must be %s or %s", Ledger, Beancount)
+	}
+
+	return &Writer{w: bufio.NewWriter(w), format: format}, nil
+}
+
+// Account returns the journal account for name under root. Ledger names are kept as
+// they are, apart from colons, which would nest accounts; Beancount names are reduced to
+// the letters, digits and dashes it allows, starting with a capital.
+func (jw *Writer) Account(root, name string) string {
+	if jw.format == Beancount {
+		return root + ":" + beancountName(name)
+	}
+	return root + ":" + ledgerName(name)
+}
+
+// Header declares every account the journal uses, and for Beancount the currency the
+// user reports in.
+func (jw *Writer) Header(currency string, accounts []string) error {
+	if jw.format == Beancount {
+		fmt.Fprintf(jw.w, "option \"operating_currency\" \"%s\"\n\n", currency)
+		for _, account := range accounts {
+			fmt.Fprintf(jw.w, "%s open %s\n", openDate, account)
+		}
+	} else {
+		for _, account := range accounts {
+			fmt.Fprintf(jw.w, "account %s\n", account)
+		}
+	}
+
+	_, err := jw.w.WriteString("\n")
+	return err
+}
+
+// Income writes one income, from its source's account into account.
+func (jw *Writer) Income(income *models.Income, account string) error {
+	meta := map[string]string{}
+	if income.ExternalID != "" {
+		meta["external_id"] = income.ExternalID
+	}
+
+	jw.transaction(income.Date.Format("2006-01-02"), income.Description, meta)
+	jw.posting(jw.Account(IncomeRoot, income.Source.Name), -income.Amount, income.Currency)
+	jw.posting(account, income.Amount, income.Currency)
+
+	_, err := jw.w.WriteString("\n")
+	return err
+}
+
+// Expense writes one expense, from account into its category's account.
+func (jw *Writer) Expense(expense *models.Expense, account string) error {
+	meta := map[string]string{}
+	if expense.PaymentMethod != "" {
+		meta["payment_method"] = expense.PaymentMethod
+	}
+	if expense.ExternalID != "" {
+		meta["external_id"] = expense.ExternalID
+	}
+
+	jw.transaction(expense.Date.Format("2006-01-02"), expense.Description, meta)
+	jw.posting(jw.Account(ExpenseRoot, expense.Category.Name), expense.Amount, expense.Currency)
+	jw.posting(account, -expense.Amount, expense.Currency)
+
+	_, err := jw.w.WriteString("\n")
+	return err
+}
+
+// Flush writes any buffered output.
+func (jw *Writer) Flush() error {
+	return jw.w.Flush()
+}
+
+// transaction writes a transaction's first line and its metadata, which Ledger keeps in
+// a comment.
+func (jw *Writer) transaction(date, description string, meta map[string]string) {
+	description = strings.Join(strings.Fields(description), " ")
+
+	if jw.format == Beancount {
+		fmt.Fprintf(jw.w, "%s * %s\n", date, quote(description))
+		for _, key := range []string{"payment_method", "external_id"} {
+			if v, ok := meta[key]; ok {
+				fmt.Fprintf(jw.w, "    %s: %s\n", key, quote(v))
+			}
+		}
+		return
+	}
+
+	fmt.Fprintln(jw.w, strings.TrimSpace(date+" "+description))
+	for _, key := range []string{"payment_method", "external_id"} {
+		if v, ok := meta[key]; ok {
+			fmt.Fprintf(jw.w, "    ; %s: %s\n", key, strings.Join(strings.Fields(v), " "))
+		}
+	}
+}
+
+func (jw *Writer) posting(account string, amount models.Money, currency string) {
+	fmt.Fprintf(jw.w, "    %s  %s %s\n", account, amount, currency)
+}
+
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// ledgerName makes name usable as one component of a Ledger account: no colons, and no
+// runs of spaces, which would end the account name.
+func ledgerName(name string) string {
+	name = strings.Join(strings.Fields(strings.ReplaceAll(name, ":", "-")), " ")
+	if name == "" {
+		return "Unnamed"
+	}
+	return name
+}
+
+// beancountName makes name usable as one component of a Beancount account: letters,
+// digits and dashes, starting with a capital letter or a digit.
+func beancountName(name string) string {
+	var b strings.Builder
+	dash := false
+
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			dash = false
+			if b.Len() == 0 {
+				r = unicode.ToUpper(r)
+			}
+			b.WriteRune(r)
+			continue
+		}
+		dash = true
+	}
+
+	if b.Len() == 0 {
+		return "Unnamed"
+	}
+	return b.String()
+}
@@ -0,0 +1,208 @@
+// Package jwk reads and writes JSON Web Key sets (RFC 7517), the public keys identity
+// providers, and this app, publish to verify their tokens with.
+package jwk
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Key is one public key of a set.
+type Key struct {
+	ID        string           // The kid tokens name the key by; may be empty
+	Algorithm string           // The alg the key is for; may be empty
+	Use       string           // "sig" for signing keys; may be empty
+	Public    crypto.PublicKey // *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey
+}
+
+// jsonKey is a key as it is written in a set.
+type jsonKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// ParseSet returns the keys of a JSON Web Key set. Keys of a type it does not know, or
+// that are for encryption, are left out rather than failing the set.
+func ParseSet(data []byte) ([]Key, error) {
+	var set struct {
+		Keys []jsonKey `json:"keys"`
+	}
+
+	err := json.Unmarshal(data, &set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key set: %w", err)
+	}
+
+	var keys []Key
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		public, err := k.public()
+		if errors.Is(err, errUnsupported) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", k.Kid, err)
+		}
+
+		keys = append(keys, Key{ID: k.Kid, Algorithm: k.Alg, Use: k.Use, Public: public})
+	}
+
+	return keys, nil
+}
+
+// MarshalJSON writes k as it appears in a set.
+func (k Key) MarshalJSON() ([]byte, error) {
+	jk, err := encode(k.Public)
+	if err != nil {
+		return nil, err
+	}
+	jk.Kid = k.ID
+	jk.Alg = k.Algorithm
+	jk.Use = k.Use
+	return json.Marshal(jk)
+}
+
+// Thumbprint returns the RFC 7638 thumbprint of a public key, a stable id for it.
+func Thumbprint(public crypto.PublicKey) (string, error) {
+	jk, err := encode(public)
+	if err != nil {
+		return "", err
+	}
+
+	// the required members only, in lexicographic order, without whitespace
+	var members string
+	switch jk.Kty {
+	case "RSA":
+		members = fmt.Sprintf(`{"e":%q,"kty":%q,"n":%q}`, jk.E, jk.Kty, jk.N)
+	case "EC":
+		members = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, jk.Crv, jk.Kty, jk.X, jk.Y)
+	case "OKP":
+		members = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q}`, jk.Crv, jk.Kty, jk.X)
+	}
+
+	sum := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// encode returns the key parameters of a public key.
+func encode(public crypto.PublicKey) (jsonKey, error) {
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		return jsonKey{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}, nil
+
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		return jsonKey{
+			Kty: "EC",
+			Crv: pub.Curve.Params().Name,
+			X:   base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size))),
+			Y:   base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size))),
+		}, nil
+
+	case ed25519.PublicKey:
+		return jsonKey{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(pub),
+		}, nil
+	}
+
+	return jsonKey{}, errUnsupported
+}
+
+// errUnsupported is returned for a key of a type or curve this package does not read.
+var errUnsupported = errors.New("unsupported key type")
+
+// public decodes the public key of k.
+func (k jsonKey) public() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errUnsupported
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, errUnsupported
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid key size")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, errUnsupported
+}
+
+// decodeInt decodes a base64url big-endian integer.
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("missing key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
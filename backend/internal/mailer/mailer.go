@@ -0,0 +1,167 @@
+// Package mailer sends the application's emails, through an SMTP server or, for local
+// development, into files or the log.
+package mailer
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message is one plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends messages.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// SMTPMailer sends messages through an SMTP server. Port 465 is spoken over TLS from the
+// start; any other port is upgraded with STARTTLS when the server offers it.
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string // No authentication if empty
+	Password string
+	From     string
+}
+
+// Send delivers msg to the server.
+func (m *SMTPMailer) Send(msg Message) error {
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	data, err := format(m.From, msg)
+	if err != nil {
+		return err
+	}
+
+	if m.Port != 465 {
+		return smtp.SendMail(addr, auth, m.From, []string{msg.To}, data)
+	}
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: m.Host})
+	if err != nil {
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if auth != nil {
+		err = c.Auth(auth)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.Mail(m.From)
+	if err != nil {
+		return err
+	}
+	err = c.Rcpt(msg.To)
+	if err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// FileMailer writes each message into Dir as an .eml file, which mail clients can open.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+// Send writes msg to a new file.
+func (m *FileMailer) Send(msg Message) error {
+	err := os.MkdirAll(m.Dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405.000000000"), sanitize(msg.To))
+
+	data, err := format(m.From, msg)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(m.Dir, name), data, 0o600)
+}
+
+// LogMailer writes each message to the log instead of sending it.
+type LogMailer struct {
+	From string
+}
+
+// Send logs msg.
+func (m *LogMailer) Send(msg Message) error {
+	log.Printf("mail from %s to %s: %s\n%s\n", m.From, msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// format renders msg as an RFC 5322 message.
+func format(from string, msg Message) ([]byte, error) {
+	if strings.ContainsAny(from+msg.To, "\r\n") {
+		return nil, errors.New("mail addresses must not contain line breaks")
+	}
+
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+
+	return b.Bytes(), nil
+}
+
+// sanitize keeps the characters of an address that are safe in a file name.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("@.-_", r) {
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AccessTokenPrefix starts every personal access token, which tells them apart from JWTs.
+const AccessTokenPrefix = "fpat_"
+
+// AccessScopes are the scopes a personal access token can be limited to. Each write scope
+// grants the matching read scope too.
+var AccessScopes = []string{
+	"read:incomes", "write:incomes",
+	"read:expenses", "write:expenses",
+	"read:accounts", "write:accounts",
+	"read:budgets", "write:budgets",
+	"read:goals", "write:goals",
+	"read:recurring", "write:recurring",
+	"read:imports", "write:imports",
+	"read:exports",
+	"read:summary",
+	"write:profile",
+}
+
+// AccessToken is a long-lived personal access token, for scripts and integrations. Only
+// the hash of the token is stored; the token itself is shown once, when it is created.
+type AccessToken struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"-"`            // Foreign key to the User table
+	Name       string     `json:"name"`         // What the token is for
+	TokenHash  string     `json:"-"`            // SHA-256 of the token, hex encoded
+	Scopes     []string   `json:"scopes"`       // What the token may do; everything but managing the account if empty
+	ExpiresAt  *time.Time `json:"expires_at"`   // When the token stops working; never if nil
+	LastUsedAt *time.Time `json:"last_used_at"` // When the token last authenticated a request
+	CreatedAt  time.Time  `json:"created_at"`   // Timestamp of creation
+}
+
+// Allows reports whether the token has scope, directly or through the write scope of
+// the same resource.
+func (t *AccessToken) Allows(scope string) bool {
+	if len(t.Scopes) == 0 {
+		return true
+	}
+
+	write := ""
+	if strings.HasPrefix(scope, "read:") {
+		write = "write:" + strings.TrimPrefix(scope, "read:")
+	}
+
+	for _, s := range t.Scopes {
+		if s == scope || s == write {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateScopes checks every scope is one of AccessScopes.
+func ValidateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		known := false
+		for _, s := range AccessScopes {
+			if s == scope {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// AccountTypes are the kinds of account a user can track.
+var AccountTypes = []string{"chequing", "savings", "credit_card", "cash", "investment", "other"}
+
+// Account is a place money is held, e.g. a chequing account or a credit card.
+type Account struct {
+	ID             int       `json:"id"`
+	UserID         int       `json:"user_id"`         // Foreign key to the User table
+	Name           string    `json:"name"`            // Name of the account, e.g. "Everyday Chequing"
+	Type           string    `json:"type"`            // One of AccountTypes
+	Currency       string    `json:"currency"`        // ISO 4217 code the account is held in
+	OpeningBalance Money     `json:"opening_balance"` // Balance before any recorded transaction
+	Balance        Money     `json:"balance"`         // Current balance; computed, never stored
+	CreatedAt      time.Time `json:"-"`               // Timestamp of creation
+	UpdatedAt      time.Time `json:"-"`               // Timestamp of last update
+}
+
+// Transfer moves money between two of a user's accounts. It is neither income nor an
+// expense, so it only affects account balances.
+type Transfer struct {
+	ID            int       `json:"id"`
+	UserID        int       `json:"user_id"`         // Foreign key to the User table
+	FromAccountID int       `json:"from_account_id"` // Account the money leaves
+	ToAccountID   int       `json:"to_account_id"`   // Account the money arrives in
+	Amount        Money     `json:"amount"`          // Amount moved, in the accounts' currency
+	Date          time.Time `json:"date"`            // Date of the transfer
+	Description   string    `json:"description"`     // Additional details about the transfer
+	CreatedAt     time.Time `json:"-"`               // Timestamp of creation
+	UpdatedAt     time.Time `json:"-"`               // Timestamp of last update
+}
+
+// LedgerEntry is one transaction in an account's history, with the balance after it.
+type LedgerEntry struct {
+	Date        time.Time `json:"date"`
+	Kind        string    `json:"kind"` // "income", "expense" or "transfer"
+	ID          int       `json:"id"`   // ID of the income, expense or transfer
+	Description string    `json:"description"`
+	Amount      Money     `json:"amount"`  // Signed: money in is positive, money out negative
+	Balance     Money     `json:"balance"` // Running balance after this entry
+}
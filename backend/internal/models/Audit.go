@@ -0,0 +1,65 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Actions an AuditEntry records.
+const (
+	AuditInsert = "insert"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+)
+
+// Entities whose changes are recorded in the audit log.
+const (
+	AuditIncome              = "income"
+	AuditExpense             = "expense"
+	AuditSource              = "source"
+	AuditCategory            = "category"
+	AuditAccount             = "account"
+	AuditTransfer            = "transfer"
+	AuditBudget              = "budget"
+	AuditGoal                = "goal"
+	AuditGoalContribution    = "goal_contribution"
+	AuditRecurring           = "recurring"
+	AuditRecurringOccurrence = "recurring_occurrence" // Browsed by the id of its template
+	AuditImportMapping       = "import_mapping"
+	AuditHousehold           = "household"
+	AuditHouseholdMember     = "household_member" // Browsed by the id of the user
+	AuditHouseholdInvite     = "household_invite"
+)
+
+// AuditActor is who, and which request, the writes made through a repository are
+// recorded as. Writes made without one, like the scheduler's, are recorded as the
+// system's.
+type AuditActor struct {
+	UserID        int
+	AccessTokenID *int // The personal access token the request was made with, if any
+	IPAddress     string
+	UserAgent     string
+	RequestID     string
+	Method        string
+	Path          string
+}
+
+// AuditEntry is one change to one record. Entries are only ever appended.
+type AuditEntry struct {
+	ID            int             `json:"id"`
+	ActorID       *int            `json:"actor_id"`        // The user who made the change; nil for the system
+	AccessTokenID *int            `json:"access_token_id"` // The personal access token it was made with, if any
+	HouseholdID   *int            `json:"household_id"`    // The household the record belongs to; nil for a user's own
+	OwnerID       *int            `json:"owner_id"`        // The user the record belongs to, or who recorded it
+	Entity        string          `json:"entity"`          // One of the Audit entity constants
+	EntityID      int             `json:"entity_id"`
+	Action        string          `json:"action"` // AuditInsert, AuditUpdate or AuditDelete
+	Before        json.RawMessage `json:"before"` // The record before the change; null for an insert
+	After         json.RawMessage `json:"after"`  // The record after the change; null for a delete
+	IPAddress     string          `json:"ip_address"`
+	UserAgent     string          `json:"user_agent"`
+	RequestID     string          `json:"request_id"`
+	Method        string          `json:"method"`
+	Path          string          `json:"path"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"math"
+	"time"
+)
+
+// Budget is a monthly spending limit for one expense category.
+type Budget struct {
+	ID          int       `json:"id"`
+	UserID      int       `json:"user_id"`      // Foreign key to the User table; who set it
+	HouseholdID int       `json:"household_id"` // Foreign key to the Household table
+	CategoryID  int       `json:"category_id"`  // Foreign key to the Category table
+	Category    *Category `json:"category"`     // Category the limit applies to
+	Amount      Money     `json:"amount"`       // Monthly limit, in the household's base currency
+	CreatedAt   time.Time `json:"-"`            // Timestamp of creation
+	UpdatedAt   time.Time `json:"-"`            // Timestamp of last update
+}
+
+// BudgetStatus compares one budget against what was actually spent in a month.
+type BudgetStatus struct {
+	BudgetID    int     `json:"budget_id"`
+	Category    string  `json:"category"`
+	Limit       Money   `json:"limit"`
+	Spent       Money   `json:"spent"`
+	Remaining   Money   `json:"remaining"`    // Zero once the limit is reached
+	PercentUsed float64 `json:"percent_used"` // Spent as a percentage of the limit, to one decimal
+	Overspend   Money   `json:"overspend"`    // Amount spent past the limit, or zero
+}
+
+// BudgetMonth is the budget-vs-actual report for one month.
+type BudgetMonth struct {
+	Month      string          `json:"month"` // e.g. "January 2006"
+	Period     Period          `json:"period"`
+	TotalLimit Money           `json:"total_limit"`
+	TotalSpent Money           `json:"total_spent"`
+	Budgets    []*BudgetStatus `json:"budgets"`
+}
+
+// Status compares the budget against the amount spent in its category.
+func (b *Budget) Status(spent Money) *BudgetStatus {
+	status := BudgetStatus{
+		BudgetID: b.ID,
+		Limit:    b.Amount,
+		Spent:    spent,
+	}
+	if b.Category != nil {
+		status.Category = b.Category.Name
+	}
+
+	if spent < b.Amount {
+		status.Remaining = b.Amount - spent
+	} else {
+		status.Overspend = spent - b.Amount
+	}
+
+	if b.Amount > 0 {
+		status.PercentUsed = math.Round(float64(spent)*1000/float64(b.Amount)) / 10
+	}
+
+	return &status
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultCurrency is the base currency of new users who don't choose one.
+const DefaultCurrency = "CAD"
+
+// ExchangeRate says that on Date, one unit of Base was worth Rate units of Quote.
+type ExchangeRate struct {
+	Date  time.Time `json:"date"`
+	Base  string    `json:"base"`  // ISO 4217 code, e.g. "USD"
+	Quote string    `json:"quote"` // ISO 4217 code, e.g. "CAD"
+	Rate  float64   `json:"rate"`
+}
+
+// NormalizeCurrency upper-cases a currency code and checks that it looks like an ISO
+// 4217 code.
+func NormalizeCurrency(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) != 3 {
+		return "", fmt.Errorf("invalid currency code %q", code)
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return "", fmt.Errorf("invalid currency code %q", code)
+		}
+	}
+	return code, nil
+}
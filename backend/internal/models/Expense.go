@@ -4,23 +4,28 @@ import "time"
 
 // Category represents a category for expenses.
 type Category struct {
-	ID        int       `json:"id"`
-	UserID    int       `json:"user_id"` // Foreign key to the User table
-	Name      string    `json:"name"`    // Name of the category, e.g., "Groceries", "Rent"
-	CreatedAt time.Time `json:"-"`       // Timestamp of creation
-	UpdatedAt time.Time `json:"-"`       // Timestamp of last update
+	ID          int       `json:"id"`
+	UserID      int       `json:"user_id"`      // Foreign key to the User table; who added it
+	HouseholdID int       `json:"household_id"` // Foreign key to the Household table
+	Name        string    `json:"name"`         // Name of the category, e.g., "Groceries", "Rent"
+	CreatedAt   time.Time `json:"-"`            // Timestamp of creation
+	UpdatedAt   time.Time `json:"-"`            // Timestamp of last update
 }
 
 // Expense represents an expense record.
 type Expense struct {
 	ID            int       `json:"id"`
-	UserID        int       `json:"user_id"`        // Foreign key to the User table
-	Amount        float64   `json:"amount"`         // Amount of the expense
-	CategoryID    int       `json:"category_id"`    // Foreign key to the Category table
-	Category      *Category `json:"category"`       // Category of the expense
-	Date          time.Time `json:"date"`           // Date the expense was incurred
-	Description   string    `json:"description"`    // Additional details about the expense
-	PaymentMethod string    `json:"payment_method"` // Method of payment, e.g., "Credit Card", "Cash"
-	CreatedAt     time.Time `json:"-"`              // Timestamp of creation
-	UpdatedAt     time.Time `json:"-"`              // Timestamp of last update
+	UserID        int       `json:"user_id"`               // Foreign key to the User table; who recorded it
+	HouseholdID   int       `json:"household_id"`          // Foreign key to the Household table
+	Amount        Money     `json:"amount"`                // Amount of the expense
+	Currency      string    `json:"currency"`              // ISO 4217 code of the amount; defaults to the household's base currency
+	CategoryID    int       `json:"category_id"`           // Foreign key to the Category table
+	Category      *Category `json:"category"`              // Category of the expense
+	AccountID     *int      `json:"account_id"`            // Account the expense was paid from, if any
+	Date          time.Time `json:"date"`                  // Date the expense was incurred
+	Description   string    `json:"description"`           // Additional details about the expense
+	PaymentMethod string    `json:"payment_method"`        // Method of payment, e.g., "Credit Card", "Cash"
+	ExternalID    string    `json:"external_id,omitempty"` // Id of the transaction in the statement it was imported from, if any
+	CreatedAt     time.Time `json:"-"`                     // Timestamp of creation
+	UpdatedAt     time.Time `json:"-"`                     // Timestamp of last update
 }
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// DefaultPageSize and MaxPageSize bound how many rows one listing request returns.
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+// TransactionFilter narrows, orders and pages a listing of incomes or expenses.
+// Zero values mean "no restriction".
+type TransactionFilter struct {
+	From          *time.Time // Earliest date, inclusive
+	To            *time.Time // Latest date, inclusive
+	MinAmount     *Money     // Smallest amount, inclusive
+	MaxAmount     *Money     // Largest amount, inclusive
+	SourceIDs     []int      // Only incomes from these sources
+	CategoryIDs   []int      // Only expenses in these categories
+	PaymentMethod string     // Only expenses paid this way
+	Description   string     // Case-insensitive substring of the description
+	SortField     string     // One of SortFields; defaults to "date"
+	SortDesc      bool       // Sort direction
+	Limit         int        // Page size; defaults to DefaultPageSize
+	After         *Cursor    // Continue after this row, from a previous page
+}
+
+// SortFields are the fields a transaction listing can be ordered by.
+var SortFields = []string{"date", "amount", "description", "created_at"}
+
+// Cursor marks the last row of a page, so the next page can continue right after it.
+// It records the sort it was issued for, and is only valid with that same sort.
+type Cursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d"`
+	Value string `json:"v"` // Sort column of the last row, as text
+	ID    int    `json:"id"`
+}
+
+// Encode returns the cursor as an opaque token for clients.
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor parses a token produced by Cursor.Encode.
+func DecodeCursor(token string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	var c Cursor
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	return &c, nil
+}
+
+// IncomePage is one page of a filtered income listing.
+type IncomePage struct {
+	Incomes    []*Income `json:"incomes"`
+	NextCursor string    `json:"next_cursor,omitempty"` // Empty on the last page
+	Total      int       `json:"total"`                 // Matching rows across all pages
+}
+
+// ExpensePage is one page of a filtered expense listing.
+type ExpensePage struct {
+	Expenses   []*Expense `json:"expenses"`
+	NextCursor string     `json:"next_cursor,omitempty"` // Empty on the last page
+	Total      int        `json:"total"`                 // Matching rows across all pages
+}
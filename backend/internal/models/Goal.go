@@ -0,0 +1,112 @@
+package models
+
+import (
+	"math"
+	"time"
+)
+
+// Goal is a savings target, e.g. an emergency fund or a down payment, to reach by a date.
+type Goal struct {
+	ID           int       `json:"id"`
+	UserID       int       `json:"user_id"`       // Foreign key to the User table; who set it
+	HouseholdID  int       `json:"household_id"`  // Foreign key to the Household table
+	Name         string    `json:"name"`          // Name of the goal, e.g. "Emergency Fund"
+	TargetAmount Money     `json:"target_amount"` // Amount to save, in the household's base currency
+	TargetDate   time.Time `json:"target_date"`   // Date the amount should be saved by
+	AccountID    *int      `json:"account_id"`    // Optional account the savings are held in
+	CategoryID   *int      `json:"category_id"`   // Optional category the savings will be spent on
+	Category     *Category `json:"category"`      // Category matching CategoryID, if any
+	Saved        Money     `json:"saved"`         // Sum of contributions; computed, never stored
+	CreatedAt    time.Time `json:"-"`             // Timestamp of creation
+	UpdatedAt    time.Time `json:"-"`             // Timestamp of last update
+}
+
+// GoalContribution is money set aside towards a goal.
+type GoalContribution struct {
+	ID          int       `json:"id"`
+	UserID      int       `json:"user_id"`     // Foreign key to the User table; who set it aside
+	GoalID      int       `json:"goal_id"`     // Foreign key to the Goal table
+	Amount      Money     `json:"amount"`      // Amount set aside, in the household's base currency
+	Date        time.Time `json:"date"`        // Date of the contribution
+	Description string    `json:"description"` // Additional details about the contribution
+	CreatedAt   time.Time `json:"-"`           // Timestamp of creation
+	UpdatedAt   time.Time `json:"-"`           // Timestamp of last update
+}
+
+// GoalProgress reports how far along a goal is, and whether it will be reached in time.
+type GoalProgress struct {
+	GoalID              int        `json:"goal_id"`
+	Name                string     `json:"name"`
+	TargetAmount        Money      `json:"target_amount"`
+	TargetDate          time.Time  `json:"target_date"`
+	Saved               Money      `json:"saved"`
+	Remaining           Money      `json:"remaining"`            // Zero once the target is reached
+	PercentComplete     float64    `json:"percent_complete"`     // Saved as a percentage of the target, to one decimal
+	Reached             bool       `json:"reached"`              // Whether the target has been saved
+	MonthsLeft          int        `json:"months_left"`          // Whole months until the target date; zero once past
+	RequiredMonthly     Money      `json:"required_monthly"`     // Monthly contribution needed to reach the target in time
+	AverageNetIncome    Money      `json:"average_net_income"`   // Average monthly net income the projection is based on
+	ProjectedCompletion *time.Time `json:"projected_completion"` // Nil if reached, or if net income is not positive
+	OnTrack             bool       `json:"on_track"`             // Whether the projection meets the target date
+}
+
+// Progress reports the goal's progress as of today, projecting completion from the
+// household's average monthly net income.
+func (g *Goal) Progress(averageNetIncome Money, today time.Time) *GoalProgress {
+	progress := GoalProgress{
+		GoalID:           g.ID,
+		Name:             g.Name,
+		TargetAmount:     g.TargetAmount,
+		TargetDate:       g.TargetDate,
+		Saved:            g.Saved,
+		AverageNetIncome: averageNetIncome,
+	}
+
+	if g.TargetAmount > 0 {
+		progress.PercentComplete = math.Round(float64(g.Saved)*1000/float64(g.TargetAmount)) / 10
+	}
+
+	if g.Saved >= g.TargetAmount {
+		progress.Reached = true
+		progress.OnTrack = true
+		return &progress
+	}
+
+	progress.Remaining = g.TargetAmount - g.Saved
+	progress.MonthsLeft = monthsBetween(today, g.TargetDate)
+
+	// once the date has passed, or is less than a month away, the rest is due at once
+	if progress.MonthsLeft > 0 {
+		progress.RequiredMonthly = divideUp(progress.Remaining, Money(progress.MonthsLeft))
+	} else {
+		progress.RequiredMonthly = progress.Remaining
+	}
+
+	if averageNetIncome > 0 {
+		months := int(divideUp(progress.Remaining, averageNetIncome))
+		projected := today.AddDate(0, months, 0)
+		progress.ProjectedCompletion = &projected
+		progress.OnTrack = !projected.After(g.TargetDate)
+	}
+
+	return &progress
+}
+
+// monthsBetween counts the whole months from one date to a later one.
+func monthsBetween(from, to time.Time) int {
+	if to.Before(from) {
+		return 0
+	}
+
+	months := (to.Year()-from.Year())*12 + int(to.Month()-from.Month())
+	if to.Day() < from.Day() {
+		months--
+	}
+
+	return months
+}
+
+// divideUp divides two positive amounts, rounding up to the next cent.
+func divideUp(a, b Money) Money {
+	return (a + b - 1) / b
+}
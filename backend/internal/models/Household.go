@@ -0,0 +1,64 @@
+package models
+
+import "time"
+
+// Roles a member can have in a household. Each can do everything the ones after it can.
+const (
+	RoleOwner  = "owner"  // Manages the household, its members and its invites
+	RoleEditor = "editor" // Adds, changes and deletes the household's records
+	RoleViewer = "viewer" // Only reads the household's records
+)
+
+// roleRanks orders the roles, from the least to the most a member can do.
+var roleRanks = map[string]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleOwner:  3,
+}
+
+// ValidRole reports whether role is one of the household roles.
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// Household is a group of users who share their incomes, expenses, sources, categories,
+// budgets, goals and recurring templates. Every user starts with a household of their
+// own, and can be invited into others.
+type Household struct {
+	ID           int       `json:"id"`
+	Name         string    `json:"name"`
+	BaseCurrency string    `json:"base_currency"` // ISO 4217 code the household's summaries are converted into
+	Role         string    `json:"role"`          // The role of the user it was read for; not stored
+	CreatedAt    time.Time `json:"created_at"`    // Timestamp of creation
+	UpdatedAt    time.Time `json:"-"`             // Timestamp of last update
+}
+
+// HouseholdMember is one user's membership of a household.
+type HouseholdMember struct {
+	HouseholdID int       `json:"household_id"` // Foreign key to the Household table
+	UserID      int       `json:"user_id"`      // Foreign key to the User table
+	Role        string    `json:"role"`         // RoleOwner, RoleEditor or RoleViewer
+	FirstName   string    `json:"first_name"`   // Of the user; read, never stored
+	LastName    string    `json:"last_name"`    // Of the user; read, never stored
+	Email       string    `json:"email"`        // Of the user; read, never stored
+	CreatedAt   time.Time `json:"joined_at"`    // When the user joined
+	UpdatedAt   time.Time `json:"-"`            // Timestamp of last update
+}
+
+// Can reports whether the member's role allows what role may do.
+func (m *HouseholdMember) Can(role string) bool {
+	return roleRanks[m.Role] >= roleRanks[role]
+}
+
+// HouseholdInvite is a link that lets whoever follows it join a household with a role.
+// Only the hash of its token is stored; the link is shown once, when it is created.
+type HouseholdInvite struct {
+	ID          int       `json:"id"`
+	HouseholdID int       `json:"household_id"` // Foreign key to the Household table
+	TokenHash   string    `json:"-"`            // SHA-256 of the token, hex encoded
+	Role        string    `json:"role"`         // Role the invited user joins with
+	CreatedBy   int       `json:"created_by"`   // Foreign key to the User table
+	ExpiresAt   time.Time `json:"expires_at"`   // When the link stops working
+	CreatedAt   time.Time `json:"created_at"`   // Timestamp of creation
+}
@@ -0,0 +1,208 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Kinds of transaction an imported row becomes.
+const (
+	ImportIncome  = "income"
+	ImportExpense = "expense"
+)
+
+// Sign conventions a bank statement can use for its amounts.
+const (
+	SignIncomePositive  = "income_positive"  // One signed amount column; money in is positive
+	SignExpensePositive = "expense_positive" // One signed amount column; money out is positive
+	SignDebitCredit     = "debit_credit"     // Separate unsigned columns for money out and money in
+)
+
+// ImportMapping tells the importer how to read one bank's CSV export. Columns are named
+// by their header, or by their 1-based position if the file has no header row.
+type ImportMapping struct {
+	ID                int       `json:"id"`
+	UserID            int       `json:"user_id"`            // Foreign key to the User table
+	Name              string    `json:"name"`               // Name of the mapping, e.g. "Chequing export"
+	HasHeader         bool      `json:"has_header"`         // Whether the first row names the columns
+	Delimiter         string    `json:"delimiter"`          // Field separator; defaults to a comma
+	DateColumn        string    `json:"date_column"`        // Column holding the date
+	DateFormat        string    `json:"date_format"`        // e.g. "YYYY-MM-DD" or "MM/DD/YYYY"
+	AmountSign        string    `json:"amount_sign"`        // One of the Sign constants
+	AmountColumn      string    `json:"amount_column"`      // Signed amount column, unless debit_credit
+	DebitColumn       string    `json:"debit_column"`       // Money-out column, for debit_credit
+	CreditColumn      string    `json:"credit_column"`      // Money-in column, for debit_credit
+	DescriptionColumn string    `json:"description_column"` // Optional column holding the description
+	CategoryColumn    string    `json:"category_column"`    // Optional column naming the expense category
+	SourceColumn      string    `json:"source_column"`      // Optional column naming the income source
+	DefaultCategory   string    `json:"default_category"`   // Category of expenses without one in the file
+	DefaultSource     string    `json:"default_source"`     // Source of incomes without one in the file
+	Currency          string    `json:"currency"`           // ISO 4217 code; empty to default like a new income or expense
+	AccountID         *int      `json:"account_id"`         // Account the statement belongs to, if any
+	CreatedAt         time.Time `json:"-"`                  // Timestamp of creation
+	UpdatedAt         time.Time `json:"-"`                  // Timestamp of last update
+}
+
+// ImportRow is one statement line, as it will be imported.
+type ImportRow struct {
+	Line          int       `json:"line"` // Line number in the file, counting the header; position of the transaction in an OFX file
+	Kind          string    `json:"kind"` // ImportIncome or ImportExpense
+	Date          time.Time `json:"date"`
+	Amount        Money     `json:"amount"` // Always positive; Kind gives the direction
+	Description   string    `json:"description"`
+	Name          string    `json:"name"`                     // Source or category name
+	Currency      string    `json:"currency,omitempty"`       // ISO 4217 code the statement gives, if any
+	ExternalID    string    `json:"external_id,omitempty"`    // Statement's id of the transaction, if it has one
+	Account       string    `json:"account,omitempty"`        // Account the file names, if any
+	AccountID     *int      `json:"account_id,omitempty"`     // Account the row is held in, if not the import's
+	PaymentMethod string    `json:"payment_method,omitempty"` // Payment method of an expense, if the file gives one
+	Duplicate     bool      `json:"duplicate,omitempty"`      // Whether the transaction was imported before
+	Error         string    `json:"error,omitempty"`          // Why the row cannot be imported, if it can't
+}
+
+// ImportPreview is the dry run of an import: every row, and what would be inserted.
+type ImportPreview struct {
+	Rows       []*ImportRow `json:"rows"`
+	Incomes    int          `json:"incomes"`              // Rows that would be inserted as incomes
+	Expenses   int          `json:"expenses"`             // Rows that would be inserted as expenses
+	Errors     int          `json:"errors"`               // Rows that cannot be imported
+	Duplicates int          `json:"duplicates,omitempty"` // Rows imported before, which are skipped
+}
+
+// Validate checks that the mapping names every column its sign convention needs.
+func (m *ImportMapping) Validate() error {
+	if m.Name == "" {
+		return errors.New("mapping name is required")
+	}
+
+	if m.DateColumn == "" || m.DateFormat == "" {
+		return errors.New("date column and date format are required")
+	}
+
+	switch m.AmountSign {
+	case SignIncomePositive, SignExpensePositive:
+		if m.AmountColumn == "" {
+			return errors.New("amount column is required")
+		}
+	case SignDebitCredit:
+		if m.DebitColumn == "" || m.CreditColumn == "" {
+			return errors.New("debit and credit columns are required")
+		}
+	default:
+		return errors.New("amount sign must be income_positive, expense_positive or debit_credit")
+	}
+
+	if len([]rune(m.Delimiter)) > 1 {
+		return errors.New("delimiter must be a single character")
+	}
+
+	if m.CategoryColumn == "" && m.DefaultCategory == "" {
+		return errors.New("a category column or a default category is required")
+	}
+
+	if m.SourceColumn == "" && m.DefaultSource == "" {
+		return errors.New("a source column or a default source is required")
+	}
+
+	return nil
+}
+
+// CheckCurrencies marks the importable rows whose currency is not that of the account
+// they would be held in as unimportable, since an account holds only its own currency.
+// Rows without a currency of their own are in currency, and rows without an account of
+// their own are held in accountID; a row without either takes the account's.
+func (p *ImportPreview) CheckCurrencies(currency string, accountID *int, accounts []*Account) {
+	currencies := map[int]string{}
+	for _, a := range accounts {
+		currencies[a.ID] = a.Currency
+	}
+
+	for _, row := range p.Rows {
+		if row.Error != "" {
+			continue
+		}
+
+		rowCurrency := currency
+		if row.Currency != "" {
+			rowCurrency = row.Currency
+		}
+
+		rowAccountID := accountID
+		if row.AccountID != nil {
+			rowAccountID = row.AccountID
+		}
+
+		if rowCurrency == "" || rowAccountID == nil || currencies[*rowAccountID] == rowCurrency {
+			continue
+		}
+
+		row.Error = fmt.Sprintf("%s is not the currency of the account, %s", rowCurrency, currencies[*rowAccountID])
+		p.Errors++
+		if row.Duplicate {
+			row.Duplicate = false
+			p.Duplicates--
+		} else if row.Kind == ImportIncome {
+			p.Incomes--
+		} else {
+			p.Expenses--
+		}
+	}
+}
+
+// Transactions turns the importable rows of the preview into incomes and expenses for
+// the user, held in the given account. Rows without a currency of their own are in the
+// given currency.
+func (p *ImportPreview) Transactions(userID int, currency string, accountID *int) ([]*Income, []*Expense) {
+	now := time.Now()
+	incomes := []*Income{}
+	expenses := []*Expense{}
+
+	for _, row := range p.Rows {
+		if row.Error != "" || row.Duplicate {
+			continue
+		}
+
+		rowCurrency := currency
+		if row.Currency != "" {
+			rowCurrency = row.Currency
+		}
+
+		rowAccountID := accountID
+		if row.AccountID != nil {
+			rowAccountID = row.AccountID
+		}
+
+		if row.Kind == ImportIncome {
+			incomes = append(incomes, &Income{
+				UserID:      userID,
+				Amount:      row.Amount,
+				Currency:    rowCurrency,
+				Source:      &Source{UserID: userID, Name: row.Name, CreatedAt: now, UpdatedAt: now},
+				AccountID:   rowAccountID,
+				Date:        row.Date,
+				Description: row.Description,
+				ExternalID:  row.ExternalID,
+				CreatedAt:   now,
+				UpdatedAt:   now,
+			})
+			continue
+		}
+
+		expenses = append(expenses, &Expense{
+			UserID:        userID,
+			Amount:        row.Amount,
+			Currency:      rowCurrency,
+			Category:      &Category{UserID: userID, Name: row.Name, CreatedAt: now, UpdatedAt: now},
+			AccountID:     rowAccountID,
+			Date:          row.Date,
+			Description:   row.Description,
+			PaymentMethod: row.PaymentMethod,
+			ExternalID:    row.ExternalID,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		})
+	}
+
+	return incomes, expenses
+}
@@ -4,22 +4,27 @@ import "time"
 
 // Source represents a source of income.
 type Source struct {
-	ID        int       `json:"id"`
-	UserID    int       `json:"user_id"` // Foreign key to the User table
-	Name      string    `json:"name"`    // Name of the source, e.g., "Salary", "Freelance"
-	CreatedAt time.Time `json:"-"`       // Timestamp of creation
-	UpdatedAt time.Time `json:"-"`       // Timestamp of last update
+	ID          int       `json:"id"`
+	UserID      int       `json:"user_id"`      // Foreign key to the User table; who added it
+	HouseholdID int       `json:"household_id"` // Foreign key to the Household table
+	Name        string    `json:"name"`         // Name of the source, e.g., "Salary", "Freelance"
+	CreatedAt   time.Time `json:"-"`            // Timestamp of creation
+	UpdatedAt   time.Time `json:"-"`            // Timestamp of last update
 }
 
 // Income represents an income record.
 type Income struct {
 	ID          int       `json:"id"`
-	UserID      int       `json:"user_id"`     // Foreign key to the User table
-	Amount      float64   `json:"amount"`      // Amount of the income
-	SourceID    int       `json:"source_id"`   // ID of the source
-	Source      *Source   `json:"source"`      // Source of income
-	Date        time.Time `json:"date"`        // Date the income was received
-	Description string    `json:"description"` // Additional details about the income
-	CreatedAt   time.Time `json:"-"`           // Timestamp of creation
-	UpdatedAt   time.Time `json:"-"`           // Timestamp of last update
+	UserID      int       `json:"user_id"`               // Foreign key to the User table; who recorded it
+	HouseholdID int       `json:"household_id"`          // Foreign key to the Household table
+	Amount      Money     `json:"amount"`                // Amount of the income
+	Currency    string    `json:"currency"`              // ISO 4217 code of the amount; defaults to the household's base currency
+	SourceID    int       `json:"source_id"`             // ID of the source
+	Source      *Source   `json:"source"`                // Source of income
+	AccountID   *int      `json:"account_id"`            // Account the income was paid into, if any
+	Date        time.Time `json:"date"`                  // Date the income was received
+	Description string    `json:"description"`           // Additional details about the income
+	ExternalID  string    `json:"external_id,omitempty"` // Id of the transaction in the statement it was imported from, if any
+	CreatedAt   time.Time `json:"-"`                     // Timestamp of creation
+	UpdatedAt   time.Time `json:"-"`                     // Timestamp of last update
 }
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Reasons a LoginAttempt succeeded or failed.
+const (
+	LoginSucceeded          = "success"
+	LoginMFARequired        = "mfa_required"
+	LoginInvalidCredentials = "invalid_credentials"
+	LoginInvalidMFACode     = "invalid_mfa_code"
+	LoginRateLimited        = "rate_limited"
+)
+
+// LoginAttempt is one try at signing in, kept as an audit trail.
+type LoginAttempt struct {
+	ID        int       `json:"id"`
+	UserID    *int      `json:"user_id"`    // Foreign key to the User table; nil if the address is unknown
+	Email     string    `json:"email"`      // Address the attempt was made for
+	IPAddress string    `json:"ip_address"` // Address the attempt came from
+	UserAgent string    `json:"user_agent"`
+	Success   bool      `json:"success"` // Whether the password, or code, was right
+	Reason    string    `json:"reason"`  // One of the Login constants
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,145 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount of currency, held as a whole number of cents so that sums and
+// differences stay exact. It scans from and is stored as NUMERIC(10,2), and encodes to
+// JSON as a number with two decimals, e.g. 1234.57.
+type Money int64
+
+// ErrTooManyDecimals is returned when an amount has fractions of a cent.
+var ErrTooManyDecimals = errors.New("amount must not have more than two decimals")
+
+// ParseMoney parses a decimal amount such as "12", "-3.5" or "1234.57". Amounts with
+// more than two decimals are rejected rather than rounded.
+func ParseMoney(s string) (Money, error) {
+	return parseMoney(s, false)
+}
+
+// parseMoney parses a plain decimal string. When round is set, digits past the cents are
+// rounded half away from zero instead of rejected; that is used for values computed by
+// the database, which may carry extra scale.
+func parseMoney(s string, round bool) (Money, error) {
+	s = strings.TrimSpace(s)
+	neg := false
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" || !isDigits(whole) || !isDigits(frac) {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+
+	roundUp := false
+	if len(frac) > 2 {
+		if !round && strings.TrimRight(frac[2:], "0") != "" {
+			return 0, ErrTooManyDecimals
+		}
+		roundUp = frac[2] >= '5'
+		frac = frac[:2]
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+
+	if whole == "" {
+		whole = "0"
+	}
+	cents, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if roundUp {
+		cents++
+	}
+	if neg {
+		cents = -cents
+	}
+
+	return Money(cents), nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Cents returns the amount as a whole number of cents.
+func (m Money) Cents() int64 {
+	return int64(m)
+}
+
+// String formats the amount with exactly two decimals, e.g. "-0.50".
+func (m Money) String() string {
+	sign := ""
+	cents := int64(m)
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// MarshalJSON encodes the amount as a JSON number with two decimals.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a numeric string, and rejects fractions of a cent.
+func (m *Money) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "null" {
+		return nil
+	}
+
+	v, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+
+	*m = v
+	return nil
+}
+
+// Scan implements sql.Scanner for NUMERIC columns.
+func (m *Money) Scan(src interface{}) error {
+	var v Money
+	var err error
+
+	switch src := src.(type) {
+	case string:
+		v, err = parseMoney(src, true)
+	case []byte:
+		v, err = parseMoney(string(src), true)
+	case int64:
+		v = Money(src * 100)
+	case float64:
+		v, err = parseMoney(strconv.FormatFloat(src, 'f', -1, 64), true)
+	case nil:
+		return errors.New("cannot scan NULL into Money")
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	*m = v
+	return nil
+}
+
+// Value implements driver.Valuer, passing the amount to the database as a decimal string.
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// PasswordReset is a request to reset a user's password. Only the hash of its token is
+// stored; the token itself is emailed to the user, and works once, until it expires.
+type PasswordReset struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`    // Foreign key to the User table
+	TokenHash string     `json:"-"`          // SHA-256 of the emailed token, hex encoded
+	ExpiresAt time.Time  `json:"expires_at"` // When the token stops working
+	UsedAt    *time.Time `json:"used_at"`    // When the token was used, if it was
+	CreatedAt time.Time  `json:"-"`          // Timestamp of creation
+}
@@ -0,0 +1,191 @@
+package models
+
+import (
+	"backend/internal/recurrence"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Kinds of transaction a recurring template posts.
+const (
+	RecurringIncome  = "income"
+	RecurringExpense = "expense"
+)
+
+// FrequencyCustom marks a template scheduled by its own recurrence rule rather than one
+// of the recurrence.Presets.
+const FrequencyCustom = "custom"
+
+// Statuses of one occurrence of a recurring template.
+const (
+	OccurrenceScheduled = "scheduled" // Will be posted as the template says
+	OccurrenceOverride  = "override"  // Will be posted with a different amount or description
+	OccurrenceSkipped   = "skipped"   // Will not be posted
+	OccurrencePosted    = "posted"    // Has been posted
+)
+
+// RecurringTemplate is an income or expense that repeats on a schedule, e.g. a paycheque
+// or rent. Due occurrences are posted automatically.
+type RecurringTemplate struct {
+	ID            int        `json:"id"`
+	UserID        int        `json:"user_id"`        // Foreign key to the User table; who set it up
+	HouseholdID   int        `json:"household_id"`   // Foreign key to the Household table
+	Kind          string     `json:"kind"`           // RecurringIncome or RecurringExpense
+	Amount        Money      `json:"amount"`         // Amount of each occurrence
+	Currency      string     `json:"currency"`       // ISO 4217 code; empty to default like a new income or expense
+	SourceID      *int       `json:"source_id"`      // Source of an income template
+	Source        *Source    `json:"source"`         // Source matching SourceID, if any
+	CategoryID    *int       `json:"category_id"`    // Category of an expense template
+	Category      *Category  `json:"category"`       // Category matching CategoryID, if any
+	AccountID     *int       `json:"account_id"`     // Account each occurrence is posted to, if any
+	Description   string     `json:"description"`    // Description of each occurrence
+	PaymentMethod string     `json:"payment_method"` // Payment method of an expense template
+	Frequency     string     `json:"frequency"`      // A key of recurrence.Presets, or FrequencyCustom
+	Rule          string     `json:"rule"`           // Recurrence rule; derived from Frequency unless custom
+	StartDate     time.Time  `json:"start_date"`     // First day the schedule may fall on
+	EndDate       *time.Time `json:"end_date"`       // Last day the schedule may fall on, if any
+	PostedThrough *time.Time `json:"posted_through"` // Every occurrence up to this date has been handled
+	CreatedAt     time.Time  `json:"-"`              // Timestamp of creation
+	UpdatedAt     time.Time  `json:"-"`              // Timestamp of last update
+}
+
+// RecurringOccurrence records a change to, or the posting of, one occurrence of a
+// recurring template.
+type RecurringOccurrence struct {
+	TemplateID  int       `json:"template_id"`
+	HouseholdID int       `json:"household_id"`
+	Date        time.Time `json:"date"`
+	Status      string    `json:"status"`      // OccurrenceOverride, OccurrenceSkipped or OccurrencePosted
+	Amount      *Money    `json:"amount"`      // Overriding amount, if any
+	Description *string   `json:"description"` // Overriding description, if any
+	CreatedAt   time.Time `json:"-"`
+	UpdatedAt   time.Time `json:"-"`
+}
+
+// UpcomingOccurrence is one future occurrence of a recurring template, as it will be posted.
+type UpcomingOccurrence struct {
+	TemplateID  int       `json:"template_id"`
+	Kind        string    `json:"kind"`
+	Date        time.Time `json:"date"`
+	Amount      Money     `json:"amount"`
+	Currency    string    `json:"currency"`
+	Name        string    `json:"name"` // Source or category name
+	Description string    `json:"description"`
+	Status      string    `json:"status"` // OccurrenceScheduled, OccurrenceOverride or OccurrenceSkipped
+}
+
+// Schedule parses the template's recurrence rule, capped at its end date.
+func (t *RecurringTemplate) Schedule() (*recurrence.Rule, error) {
+	rule, err := recurrence.Parse(t.Rule)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.EndDate != nil && (rule.Until.IsZero() || t.EndDate.Before(rule.Until)) {
+		rule.Until = *t.EndDate
+	}
+
+	return rule, nil
+}
+
+// SetRule fills in the template's rule from its frequency, or checks and normalizes its
+// own rule if the frequency is custom.
+func (t *RecurringTemplate) SetRule() error {
+	if t.Frequency == FrequencyCustom {
+		rule, err := recurrence.Parse(t.Rule)
+		if err != nil {
+			return err
+		}
+		t.Rule = rule.String()
+		return nil
+	}
+
+	rule, ok := recurrence.Presets[t.Frequency]
+	if !ok {
+		return fmt.Errorf("unknown frequency %q", t.Frequency)
+	}
+	t.Rule = rule
+
+	return nil
+}
+
+// Name is the source or category name of the template.
+func (t *RecurringTemplate) Name() string {
+	if t.Kind == RecurringIncome && t.Source != nil {
+		return t.Source.Name
+	}
+	if t.Kind == RecurringExpense && t.Category != nil {
+		return t.Category.Name
+	}
+	return ""
+}
+
+// Upcoming describes the occurrence on date, applying an override or skip if there is one.
+func (t *RecurringTemplate) Upcoming(date time.Time, o *RecurringOccurrence) *UpcomingOccurrence {
+	upcoming := UpcomingOccurrence{
+		TemplateID:  t.ID,
+		Kind:        t.Kind,
+		Date:        date,
+		Amount:      t.Amount,
+		Currency:    t.Currency,
+		Name:        t.Name(),
+		Description: t.Description,
+		Status:      OccurrenceScheduled,
+	}
+
+	if o != nil {
+		upcoming.Status = o.Status
+		if o.Amount != nil {
+			upcoming.Amount = *o.Amount
+		}
+		if o.Description != nil {
+			upcoming.Description = *o.Description
+		}
+	}
+
+	return &upcoming
+}
+
+// Income builds the income to post for an occurrence of an income template.
+func (t *RecurringTemplate) Income(o *UpcomingOccurrence) (*Income, error) {
+	if t.Kind != RecurringIncome || t.Source == nil {
+		return nil, errors.New("template does not post incomes")
+	}
+
+	now := time.Now()
+	return &Income{
+		UserID:      t.UserID,
+		HouseholdID: t.HouseholdID,
+		Amount:      o.Amount,
+		Currency:    t.Currency,
+		Source:      &Source{UserID: t.UserID, HouseholdID: t.HouseholdID, Name: t.Source.Name, CreatedAt: now, UpdatedAt: now},
+		AccountID:   t.AccountID,
+		Date:        o.Date,
+		Description: o.Description,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}, nil
+}
+
+// Expense builds the expense to post for an occurrence of an expense template.
+func (t *RecurringTemplate) Expense(o *UpcomingOccurrence) (*Expense, error) {
+	if t.Kind != RecurringExpense || t.Category == nil {
+		return nil, errors.New("template does not post expenses")
+	}
+
+	now := time.Now()
+	return &Expense{
+		UserID:        t.UserID,
+		HouseholdID:   t.HouseholdID,
+		Amount:        o.Amount,
+		Currency:      t.Currency,
+		Category:      &Category{UserID: t.UserID, HouseholdID: t.HouseholdID, Name: t.Category.Name, CreatedAt: now, UpdatedAt: now},
+		AccountID:     t.AccountID,
+		Date:          o.Date,
+		Description:   o.Description,
+		PaymentMethod: t.PaymentMethod,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}, nil
+}
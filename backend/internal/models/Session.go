@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Session is one refresh token of a login. Only the hash of the token is stored. Each
+// refresh rotates the token into a new session of the same family, so every token ever
+// issued for a login can be traced, and revoked, together. To the user, the family is
+// the session, and its id is the family id.
+type Session struct {
+	ID         int        `json:"-"`
+	UserID     int        `json:"user_id"`      // Foreign key to the User table
+	FamilyID   string     `json:"id"`           // Shared by every token rotated from the same login
+	TokenHash  string     `json:"-"`            // SHA-256 of the refresh token, hex encoded
+	UserAgent  string     `json:"user_agent"`   // User agent of the last login or refresh
+	IPAddress  string     `json:"ip_address"`   // Address of the last login or refresh
+	ExpiresAt  time.Time  `json:"expires_at"`   // When the token stops working
+	RotatedAt  *time.Time `json:"-"`            // When the token was exchanged for the next one
+	RevokedAt  *time.Time `json:"-"`            // When the family was revoked, on logout or reuse
+	CreatedAt  time.Time  `json:"created_at"`   // When the login happened; kept through rotations
+	LastUsedAt time.Time  `json:"last_used_at"` // When the token was issued, by login or refresh
+	Current    bool       `json:"current"`      // Whether it is the session of the request; not stored
+}
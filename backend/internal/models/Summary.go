@@ -0,0 +1,178 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Granularity is the size of the buckets a summary period is broken down into.
+type Granularity string
+
+const (
+	Week    Granularity = "week"
+	Month   Granularity = "month"
+	Quarter Granularity = "quarter"
+	Year    Granularity = "year"
+)
+
+// MaxSummaryBuckets bounds how many buckets one summary may be broken down into.
+const MaxSummaryBuckets = 520
+
+// ParseGranularity validates a granularity name.
+func ParseGranularity(s string) (Granularity, error) {
+	switch g := Granularity(s); g {
+	case Week, Month, Quarter, Year:
+		return g, nil
+	}
+	return "", errors.New("granularity must be one of week, month, quarter, year")
+}
+
+// Truncate returns the first day of the bucket containing t. Weeks start on Monday,
+// matching Postgres' date_trunc.
+func (g Granularity) Truncate(t time.Time) time.Time {
+	y, m, d := t.Date()
+	switch g {
+	case Week:
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, m, d-offset, 0, 0, 0, 0, time.UTC)
+	case Quarter:
+		return time.Date(y, m-(m-1)%3, 1, 0, 0, 0, 0, time.UTC)
+	case Year:
+		return time.Date(y, 1, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// Next returns the first day of the bucket after the one starting at start.
+func (g Granularity) Next(start time.Time) time.Time {
+	switch g {
+	case Week:
+		return start.AddDate(0, 0, 7)
+	case Quarter:
+		return start.AddDate(0, 3, 0)
+	case Year:
+		return start.AddDate(1, 0, 0)
+	default:
+		return start.AddDate(0, 1, 0)
+	}
+}
+
+// Interval returns the bucket size as a Postgres interval.
+func (g Granularity) Interval() string {
+	switch g {
+	case Week:
+		return "1 week"
+	case Quarter:
+		return "3 months"
+	case Year:
+		return "1 year"
+	default:
+		return "1 month"
+	}
+}
+
+// Label names the bucket starting at start, e.g. "January 2006" for a month.
+func (g Granularity) Label(start time.Time) string {
+	switch g {
+	case Week:
+		return "Week of " + start.Format("Jan 2, 2006")
+	case Quarter:
+		return fmt.Sprintf("Q%d %d", (int(start.Month())-1)/3+1, start.Year())
+	case Year:
+		return start.Format("2006")
+	default:
+		return start.Format("January 2006")
+	}
+}
+
+// Period is a span of calendar days, both ends inclusive.
+type Period struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// TrailingMonths returns the period covering the current month and the n-1 months
+// before it, up to today.
+func TrailingMonths(n int) Period {
+	today := Today()
+	return Period{
+		From: Month.Truncate(today).AddDate(0, -(n - 1), 0),
+		To:   today,
+	}
+}
+
+// Today is the current UTC date, at midnight.
+func Today() time.Time {
+	now := time.Now().UTC()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// Buckets counts the buckets of size g the period spans.
+func (p Period) Buckets(g Granularity) int {
+	n := 0
+	for t := g.Truncate(p.From); !t.After(p.To); t = g.Next(t) {
+		n++
+		if n > MaxSummaryBuckets {
+			break
+		}
+	}
+	return n
+}
+
+// FinancialSummary is the dashboard overview: all-time totals, plus a breakdown of the
+// requested period into buckets.
+type FinancialSummary struct {
+	AccountBalance           Money            `json:"account_balance"`             // Total income minus total expenses
+	IncomeSumTotal           Money            `json:"income_sum_total"`            // All-time income
+	ExpenseSumTotal          Money            `json:"expense_sum_total"`           // All-time expenses
+	OverallIncomeBySource    map[string]Money `json:"overall_income_by_source"`    // All-time income, keyed by source name
+	OverallExpenseByCategory map[string]Money `json:"overall_expense_by_category"` // All-time expenses, keyed by category name
+	BaseCurrency             string           `json:"base_currency"`               // Currency every amount is converted into
+	MissingRates             int              `json:"missing_rates"`               // Transactions left out for lack of an exchange rate
+	Period                   Period           `json:"period"`                      // Span of the breakdown
+	Granularity              Granularity      `json:"granularity"`                 // Size of each bucket
+	Accounts                 []*Account       `json:"accounts"`                    // Every account with its current balance
+	Months                   []*BucketSummary `json:"months"`                      // Oldest bucket first; named months for compatibility
+}
+
+// BucketSummary is the breakdown of one bucket (a week, month, quarter or year) in a
+// FinancialSummary.
+type BucketSummary struct {
+	Label             string            `json:"month"` // e.g. "January 2006"; named month for compatibility
+	Start             time.Time         `json:"start"` // First day of the bucket
+	NetIncome         Money             `json:"net_income"`
+	IncomeSum         Money             `json:"income_sum"`
+	ExpenseSum        Money             `json:"expense_sum"`
+	IncomeBySource    map[string]Money  `json:"income_by_source"`
+	ExpenseByCategory map[string]Money  `json:"expense_by_category"`
+	Top3Income        []*SourceAmount   `json:"top3IncomeThisMonth"`
+	Top3Expense       []*CategoryAmount `json:"top3ExpenseThisMonth"`
+}
+
+// SourceAmount is the income received from one source.
+type SourceAmount struct {
+	Source string `json:"source"`
+	Amount Money  `json:"amount"`
+}
+
+// CategoryAmount is the amount spent in one category.
+type CategoryAmount struct {
+	Category string `json:"category"`
+	Amount   Money  `json:"amount"`
+}
+
+// AverageNetIncome is the mean net income of the summary's buckets, or zero if it has none.
+func (s *FinancialSummary) AverageNetIncome() Money {
+	if len(s.Months) == 0 {
+		return 0
+	}
+
+	var total Money
+	for _, b := range s.Months {
+		total += b.NetIncome
+	}
+
+	return total / Money(len(s.Months))
+}
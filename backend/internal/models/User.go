@@ -8,13 +8,18 @@ import (
 )
 
 type User struct {
-	ID        int       `json:"id"`
-	FirstName string    `json:"first_name"`
-	LastName  string    `json:"last_name"`
-	Email     string    `json:"email"`
-	Password  string    `json:"password"`
-	CreatedAt time.Time `json:"-"`
-	UpdatedAt time.Time `json:"-"`
+	ID                        int        `json:"id"`
+	FirstName                 string     `json:"first_name"`
+	LastName                  string     `json:"last_name"`
+	Email                     string     `json:"email"`
+	Password                  string     `json:"password"`
+	BaseCurrency              string     `json:"base_currency"`     // ISO 4217 code that summaries are converted into
+	EmailVerifiedAt           *time.Time `json:"email_verified_at"` // When the user confirmed their address; nil until then
+	EmailVerificationRequired bool       `json:"-"`                 // Whether the account was created once addresses had to be verified
+	TOTPSecret                string     `json:"-"`                 // Base32 TOTP secret, set once enrollment starts
+	TOTPEnabledAt             *time.Time `json:"totp_enabled_at"`   // When two-factor authentication was confirmed; nil if off
+	CreatedAt                 time.Time  `json:"-"`
+	UpdatedAt                 time.Time  `json:"-"`
 }
 
 
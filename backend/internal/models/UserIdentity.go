@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// UserIdentity links a user to their account at an external OpenID Connect provider, so
+// they can sign in there instead of with a password.
+type UserIdentity struct {
+	ID          int        `json:"id"`
+	UserID      int        `json:"user_id"`       // Foreign key to the User table
+	Provider    string     `json:"provider"`      // Name the provider is configured under
+	Subject     string     `json:"subject"`       // The user's id at the provider; never reassigned
+	Email       string     `json:"email"`         // Address the provider gave when the identity was linked
+	CreatedAt   time.Time  `json:"created_at"`    // Timestamp of linking
+	LastLoginAt *time.Time `json:"last_login_at"` // When the user last signed in with it
+}
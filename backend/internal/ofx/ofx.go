@@ -0,0 +1,294 @@
+// Package ofx reads bank and credit card statements from OFX and QFX files, both the
+// SGML flavour of OFX 1.x and the XML of OFX 2.x.
+package ofx
+
+import (
+	"backend/internal/models"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Statement is one account's statement in an OFX file.
+type Statement struct {
+	AccountID    string         // ACCTID of the bank or credit card account
+	Currency     string         // CURDEF, the statement's default currency
+	Transactions []*Transaction // Every STMTTRN, in file order
+}
+
+// Transaction is one STMTTRN entry of a statement.
+type Transaction struct {
+	FITID  string       // Bank's unique id of the transaction within the account
+	Type   string       // TRNTYPE, e.g. "DEBIT" or "CREDIT"
+	Posted time.Time    // DTPOSTED, as a date
+	Amount models.Money // TRNAMT; positive for money in, negative for money out
+	Name   string       // NAME or PAYEE name
+	Memo   string       // MEMO
+}
+
+// node is an element of the OFX tree. Leaf elements have a value, aggregates children.
+type node struct {
+	name     string
+	value    string
+	children []*node
+}
+
+// Parse reads every bank and credit card statement in an OFX or QFX file.
+func Parse(r io.Reader) ([]*Statement, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := parseTree(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	statements := []*Statement{}
+
+	for _, rs := range root.findAll("STMTRS", "CCSTMTRS") {
+		statement := Statement{
+			AccountID: rs.text("ACCTID"),
+			Currency:  strings.ToUpper(rs.text("CURDEF")),
+		}
+
+		for _, trn := range rs.findAll("STMTTRN") {
+			t, err := readTransaction(trn)
+			if err != nil {
+				return nil, err
+			}
+			statement.Transactions = append(statement.Transactions, t)
+		}
+
+		statements = append(statements, &statement)
+	}
+
+	if len(statements) == 0 {
+		return nil, errors.New("file holds no bank or credit card statement")
+	}
+
+	return statements, nil
+}
+
+// Preview lays out the transactions of the statements as an import: money in becomes an
+// income from source, money out an expense in category. If source is empty, an income's
+// source is the payee's name. Every row keeps the account and FITID of its transaction
+// as its external id, so a file imported twice is only inserted once.
+func Preview(statements []*Statement, source, category string) *models.ImportPreview {
+	preview := models.ImportPreview{Rows: []*models.ImportRow{}}
+	line := 0
+
+	for _, s := range statements {
+		var currency string
+		var currencyErr error
+		if s.Currency != "" {
+			currency, currencyErr = models.NormalizeCurrency(s.Currency)
+		}
+
+		for _, t := range s.Transactions {
+			line++
+
+			row := models.ImportRow{
+				Line:        line,
+				Date:        t.Posted,
+				Description: t.Name,
+				Currency:    currency,
+				ExternalID:  ExternalID(s.AccountID, t.FITID),
+			}
+			if t.Memo != "" && t.Memo != t.Name {
+				row.Description = strings.TrimSpace(t.Name + " " + t.Memo)
+			}
+
+			switch {
+			case currencyErr != nil:
+				row.Error = currencyErr.Error()
+			case t.Amount == 0:
+				row.Error = "transaction has no amount"
+			case t.Amount > 0:
+				row.Kind = models.ImportIncome
+				row.Amount = t.Amount
+				row.Name = source
+				if row.Name == "" {
+					row.Name = t.Name
+				}
+				if row.Name == "" {
+					row.Error = "transaction has no payee to use as its source"
+				}
+			default:
+				row.Kind = models.ImportExpense
+				row.Amount = -t.Amount
+				row.Name = category
+			}
+
+			switch {
+			case row.Error != "":
+				preview.Errors++
+			case row.Kind == models.ImportIncome:
+				preview.Incomes++
+			default:
+				preview.Expenses++
+			}
+
+			preview.Rows = append(preview.Rows, &row)
+		}
+	}
+
+	return &preview
+}
+
+// ExternalID is the id an imported transaction is stored under: its FITID is only
+// unique within the account.
+func ExternalID(accountID, fitID string) string {
+	return "ofx:" + accountID + ":" + fitID
+}
+
+func readTransaction(trn *node) (*Transaction, error) {
+	t := Transaction{
+		FITID: trn.text("FITID"),
+		Type:  strings.ToUpper(trn.text("TRNTYPE")),
+		Name:  trn.text("NAME"),
+		Memo:  trn.text("MEMO"),
+	}
+
+	if t.FITID == "" {
+		return nil, errors.New("transaction has no FITID")
+	}
+
+	if t.Name == "" {
+		t.Name = trn.text("PAYEEID")
+	}
+
+	posted := trn.text("DTPOSTED")
+	if len(posted) < 8 {
+		return nil, fmt.Errorf("transaction %s has no valid DTPOSTED", t.FITID)
+	}
+	date, err := time.Parse("20060102", posted[:8])
+	if err != nil {
+		return nil, fmt.Errorf("transaction %s has no valid DTPOSTED", t.FITID)
+	}
+	t.Posted = date
+
+	amount := strings.TrimSpace(trn.text("TRNAMT"))
+	// some banks write a decimal comma
+	if !strings.Contains(amount, ".") {
+		amount = strings.Replace(amount, ",", ".", 1)
+	}
+	t.Amount, err = models.ParseMoney(amount)
+	if err != nil {
+		return nil, fmt.Errorf("transaction %s has an invalid TRNAMT %q", t.FITID, trn.text("TRNAMT"))
+	}
+
+	return &t, nil
+}
+
+// parseTree builds the element tree from the <OFX> tag on, skipping the header before
+// it. Leaf elements may be left unclosed, as SGML allows.
+func parseTree(s string) (*node, error) {
+	start := strings.Index(strings.ToUpper(s), "<OFX>")
+	if start < 0 {
+		return nil, errors.New("file is not OFX: no <OFX> element")
+	}
+	s = s[start:]
+
+	root := &node{}
+	stack := []*node{root}
+
+	for len(s) > 0 {
+		if s[0] != '<' {
+			end := strings.IndexByte(s, '<')
+			if end < 0 {
+				end = len(s)
+			}
+			text := strings.TrimSpace(s[:end])
+			s = s[end:]
+
+			top := stack[len(stack)-1]
+			if text != "" && top != root {
+				top.value = unescape(text)
+			}
+			continue
+		}
+
+		end := strings.IndexByte(s, '>')
+		if end < 0 {
+			return nil, errors.New("file is not OFX: unterminated tag")
+		}
+		tag := strings.TrimSpace(s[1:end])
+		s = s[end+1:]
+
+		switch {
+		case tag == "" || tag[0] == '?' || tag[0] == '!':
+			continue
+
+		case tag[0] == '/':
+			name := strings.ToUpper(strings.TrimSpace(tag[1:]))
+			// close the element, and any unclosed leaf elements inside it
+			for i := len(stack) - 1; i > 0; i-- {
+				if stack[i].name == name {
+					stack = stack[:i]
+					break
+				}
+			}
+
+		default:
+			selfClosing := strings.HasSuffix(tag, "/")
+			name := strings.ToUpper(strings.Fields(strings.TrimSuffix(tag, "/"))[0])
+
+			// an unclosed leaf element ends where the next element starts
+			if top := stack[len(stack)-1]; top.value != "" {
+				stack = stack[:len(stack)-1]
+			}
+
+			n := &node{name: name}
+			parent := stack[len(stack)-1]
+			parent.children = append(parent.children, n)
+			if !selfClosing {
+				stack = append(stack, n)
+			}
+		}
+	}
+
+	return root, nil
+}
+
+// findAll returns the descendants of n with any of the given names, in document order,
+// without looking inside a match.
+func (n *node) findAll(names ...string) []*node {
+	var found []*node
+	for _, c := range n.children {
+		matched := false
+		for _, name := range names {
+			if c.name == name {
+				matched = true
+			}
+		}
+		if matched {
+			found = append(found, c)
+			continue
+		}
+		found = append(found, c.findAll(names...)...)
+	}
+	return found
+}
+
+// text returns the value of the first descendant of n with the given name, or "".
+func (n *node) text(name string) string {
+	for _, c := range n.children {
+		if c.name == name && c.value != "" {
+			return c.value
+		}
+		if v := c.text(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+var entities = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&quot;", `"`, "&apos;", "'", "&nbsp;", " ", "&amp;", "&")
+
+func unescape(s string) string {
+	return entities.Replace(s)
+}
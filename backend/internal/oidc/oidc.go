@@ -0,0 +1,371 @@
+// Package oidc signs users in with an OpenID Connect identity provider, through the
+// authorization code flow with PKCE: discovery, the authorization URL, the code exchange,
+// and verification of the ID token against the provider's published keys.
+package oidc
+
+import (
+	"backend/internal/jwk"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	// leeway is how far the provider's clock may be off from ours.
+	leeway = time.Minute
+
+	// keysRefresh is how often, at most, the provider's keys are fetched again for a
+	// token signed with a key we have not seen.
+	keysRefresh = time.Minute
+
+	// maxResponse caps what is read from the provider.
+	maxResponse = 1 << 20
+)
+
+// signingMethods are the algorithms ID tokens may be signed with. Symmetric ones are
+// left out, as they would need the client secret as the key.
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// Config identifies the app to a provider.
+type Config struct {
+	Issuer       string   // The provider's issuer URL, which discovery starts from
+	ClientID     string   // The app's client id at the provider
+	ClientSecret string   // The app's client secret; empty for a public client
+	RedirectURL  string   // Where the provider sends the user back to, with the code
+	Scopes       []string // Scopes to ask for beyond openid; email and profile if nil
+}
+
+// Metadata is the part of a provider's discovery document the flow needs.
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Claims are the claims of a verified ID token that identify the user.
+type Claims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	GivenName     string
+	FamilyName    string
+}
+
+// Provider is one identity provider. Its discovery document is fetched on first use,
+// and its keys whenever a token names one it has not seen.
+type Provider struct {
+	Config Config
+	Client *http.Client // A client with a 10 second timeout if nil
+
+	mu        sync.Mutex
+	metadata  *Metadata
+	keys      []jwk.Key
+	keysFetch time.Time
+}
+
+// NewProvider returns a provider for config.
+func NewProvider(config Config) *Provider {
+	return &Provider{Config: config}
+}
+
+// Metadata returns the provider's discovery document, fetching it the first time. The
+// document must be for the configured issuer.
+func (p *Provider) Metadata(ctx context.Context) (*Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var m Metadata
+	err := p.get(ctx, strings.TrimRight(p.Config.Issuer, "/")+"/.well-known/openid-configuration", &m)
+	if err != nil {
+		return nil, fmt.Errorf("discovery failed: %w", err)
+	}
+
+	if m.Issuer != p.Config.Issuer {
+		return nil, fmt.Errorf("discovery returned issuer %q, want %q", m.Issuer, p.Config.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, errors.New("discovery document is missing endpoints")
+	}
+
+	p.metadata = &m
+	return p.metadata, nil
+}
+
+// AuthCodeURL returns the URL to send the user to, to sign in at the provider. state and
+// nonce are echoed back, in the redirect and the ID token; challenge is the PKCE challenge
+// of the verifier that will redeem the code.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, challenge string) (string, error) {
+	m, err := p.Metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	scopes := p.Config.Scopes
+	if scopes == nil {
+		scopes = []string{"email", "profile"}
+	}
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.Config.ClientID},
+		"redirect_uri":          {p.Config.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, scopes...), " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(m.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return m.AuthorizationEndpoint + sep + query.Encode(), nil
+}
+
+// Exchange redeems an authorization code with its PKCE verifier, and returns the claims
+// of the ID token it is exchanged for, once verified against nonce.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	m, err := p.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.Config.RedirectURL},
+		"client_id":     {p.Config.ClientID},
+		"code_verifier": {verifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.Config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.Config.ClientID), url.QueryEscape(p.Config.ClientSecret))
+	}
+
+	res, err := p.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+
+	err = json.NewDecoder(io.LimitReader(res.Body, maxResponse)).Decode(&token)
+	if err != nil && res.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("invalid token response: %w", err)
+	}
+	if res.StatusCode != http.StatusOK || token.Error != "" {
+		return nil, fmt.Errorf("token request failed: %s %s %s", res.Status, token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	return p.Verify(ctx, token.IDToken, nonce)
+}
+
+// idClaims are the claims read from an ID token.
+type idClaims struct {
+	jwt.RegisteredClaims
+	Nonce         string    `json:"nonce"`
+	AZP           string    `json:"azp"`
+	Email         string    `json:"email"`
+	EmailVerified boolClaim `json:"email_verified"`
+	Name          string    `json:"name"`
+	GivenName     string    `json:"given_name"`
+	FamilyName    string    `json:"family_name"`
+}
+
+// Valid is left to Verify, which allows for leeway.
+func (c *idClaims) Valid() error {
+	return nil
+}
+
+// boolClaim is a boolean claim some providers send as a string.
+type boolClaim bool
+
+func (b *boolClaim) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", `"true"`:
+		*b = true
+	case "false", `"false"`, "null":
+		*b = false
+	default:
+		return fmt.Errorf("invalid boolean claim %s", data)
+	}
+	return nil
+}
+
+// Verify checks an ID token: its signature against the provider's keys, and that it was
+// issued by the provider, for this client, with nonce, and has not expired.
+func (p *Provider) Verify(ctx context.Context, rawIDToken, nonce string) (*Claims, error) {
+	m, err := p.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &idClaims{}
+	parser := jwt.Parser{ValidMethods: signingMethods}
+
+	_, err = parser.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid, token.Method.Alg())
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %w", err)
+	}
+
+	now := time.Now()
+
+	switch {
+	case claims.Issuer != m.Issuer:
+		return nil, errors.New("id token has the wrong issuer")
+	case !claims.VerifyAudience(p.Config.ClientID, true):
+		return nil, errors.New("id token is for another client")
+	case len(claims.Audience) > 1 && claims.AZP != p.Config.ClientID:
+		return nil, errors.New("id token was issued to another client")
+	case claims.ExpiresAt == nil || now.After(claims.ExpiresAt.Add(leeway)):
+		return nil, errors.New("id token has expired")
+	case claims.IssuedAt != nil && now.Add(leeway).Before(claims.IssuedAt.Time):
+		return nil, errors.New("id token was issued in the future")
+	case nonce == "" || claims.Nonce != nonce:
+		return nil, errors.New("id token has the wrong nonce")
+	case claims.Subject == "":
+		return nil, errors.New("id token has no subject")
+	}
+
+	return &Claims{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          claims.Name,
+		GivenName:     claims.GivenName,
+		FamilyName:    claims.FamilyName,
+	}, nil
+}
+
+// key returns the provider's key with the given kid, for alg. If there is none, the
+// keys are fetched again, as the provider may have rotated them.
+func (p *Provider) key(ctx context.Context, kid, alg string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := findKey(p.keys, kid, alg); ok {
+		return key, nil
+	}
+
+	if time.Since(p.keysFetch) < keysRefresh {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	p.keysFetch = time.Now()
+
+	var raw json.RawMessage
+	err := p.get(ctx, p.metadata.JWKSURI, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("fetching keys failed: %w", err)
+	}
+
+	keys, err := jwk.ParseSet(raw)
+	if err != nil {
+		return nil, err
+	}
+	p.keys = keys
+
+	if key, ok := findKey(p.keys, kid, alg); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// findKey returns the key named kid, or if the token names none, the only key for alg.
+func findKey(keys []jwk.Key, kid, alg string) (interface{}, bool) {
+	var found []jwk.Key
+	for _, k := range keys {
+		if k.Algorithm != "" && k.Algorithm != alg {
+			continue
+		}
+		if kid == "" || k.ID == kid {
+			found = append(found, k)
+		}
+	}
+	if len(found) != 1 {
+		return nil, false
+	}
+	return found[0].Public, true
+}
+
+// get fetches a JSON document into v.
+func (p *Provider) get(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, res.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(res.Body, maxResponse)).Decode(v)
+}
+
+// defaultClient is used when a provider has no client of its own.
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
+
+func (p *Provider) client() *http.Client {
+	if p.Client != nil {
+		return p.Client
+	}
+	return defaultClient
+}
+
+// NewVerifier returns a random PKCE code verifier, which also serves for state and nonce.
+func NewVerifier() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Challenge returns the S256 PKCE challenge of a verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
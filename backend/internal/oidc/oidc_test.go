@@ -0,0 +1,192 @@
+package oidc_test
+
+import (
+	"backend/internal/oidc"
+	"backend/internal/oidc/oidctest"
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID    = "test-client"
+	testRedirectURL = "http://app.example/callback"
+)
+
+// newTestProvider starts a mock provider, and returns it with a provider configured for it.
+func newTestProvider(t *testing.T) (*oidctest.Provider, *oidc.Provider) {
+	t.Helper()
+
+	mock, srv, err := oidctest.NewServer(testClientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+
+	p := oidc.NewProvider(oidc.Config{
+		Issuer:      mock.Issuer,
+		ClientID:    testClientID,
+		RedirectURL: testRedirectURL,
+	})
+
+	return mock, p
+}
+
+// authorize signs in at the mock provider with the verifier's challenge and nonce, and
+// returns the code it redirects back with.
+func authorize(t *testing.T, p *oidc.Provider, verifier, nonce string) string {
+	t.Helper()
+
+	authURL, err := p.AuthCodeURL(context.Background(), "state", nonce, oidc.Challenge(verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	res, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("authorize returned %s", res.Status)
+	}
+
+	back, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := back.Query().Get("code")
+	if code == "" {
+		t.Fatalf("authorize redirected to %s, without a code", back)
+	}
+	return code
+}
+
+func TestExchange(t *testing.T) {
+	mock, p := newTestProvider(t)
+
+	code := authorize(t, p, "verifier", "nonce")
+
+	claims, err := p.Exchange(context.Background(), code, "verifier", "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Subject != mock.User.Subject || claims.Email != mock.User.Email || !claims.EmailVerified {
+		t.Errorf("got claims %+v, want those of %+v", claims, mock.User)
+	}
+}
+
+func TestExchangeRejects(t *testing.T) {
+	tests := []struct {
+		name     string
+		verifier string
+		nonce    string
+		want     string
+	}{
+		{"wrong nonce", "verifier", "other-nonce", "wrong nonce"},
+		{"PKCE verifier mismatch", "other-verifier", "nonce", "invalid_grant"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, p := newTestProvider(t)
+
+			code := authorize(t, p, "verifier", "nonce")
+
+			_, err := p.Exchange(context.Background(), code, tt.verifier, tt.nonce)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want one about %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(mock *oidctest.Provider, claims map[string]interface{}) string
+		want   string
+	}{
+		{
+			name: "wrong audience",
+			change: func(mock *oidctest.Provider, claims map[string]interface{}) string {
+				claims["aud"] = "other-client"
+				return mock.KeyID()
+			},
+			want: "another client",
+		},
+		{
+			name: "expired token",
+			change: func(mock *oidctest.Provider, claims map[string]interface{}) string {
+				claims["iat"] = time.Now().Add(-time.Hour).Unix()
+				claims["exp"] = time.Now().Add(-time.Minute * 10).Unix()
+				return mock.KeyID()
+			},
+			want: "expired",
+		},
+		{
+			name: "unknown kid",
+			change: func(mock *oidctest.Provider, claims map[string]interface{}) string {
+				return "other-key"
+			},
+			want: "unknown signing key",
+		},
+		{
+			name: "wrong nonce",
+			change: func(mock *oidctest.Provider, claims map[string]interface{}) string {
+				claims["nonce"] = "other-nonce"
+				return mock.KeyID()
+			},
+			want: "wrong nonce",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock, p := newTestProvider(t)
+
+			claims := mock.Claims(mock.User, "nonce", time.Now())
+			kid := tt.change(mock, claims)
+
+			token, err := mock.Sign(claims, kid)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = p.Verify(context.Background(), token, "nonce")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want one about %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	mock, p := newTestProvider(t)
+
+	token, err := mock.Sign(mock.Claims(mock.User, "nonce", time.Now()), mock.KeyID())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := p.Verify(context.Background(), token, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Subject != mock.User.Subject {
+		t.Errorf("got subject %q, want %q", claims.Subject, mock.User.Subject)
+	}
+}
@@ -0,0 +1,289 @@
+// Package oidctest is a mock OpenID Connect provider, for trying the login flow without a
+// real one. It signs in whoever is asked for, without a password, so it must never be
+// reachable outside development.
+package oidctest
+
+import (
+	"backend/internal/oidc"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// codeExpiry is how long an authorization code can be redeemed for.
+const codeExpiry = time.Minute
+
+// User is who the provider signs in.
+type User struct {
+	Subject    string
+	Email      string
+	GivenName  string
+	FamilyName string
+}
+
+// Provider is a mock provider. /authorize signs in User straight away, or, given a
+// login_hint, a user with that address, and redirects back with a code.
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string // Required at the token endpoint if set
+	User         User
+
+	key   *rsa.PrivateKey
+	keyID string
+
+	mu     sync.Mutex
+	grants map[string]grant
+}
+
+// grant is what an authorization code was issued for.
+type grant struct {
+	clientID    string
+	redirectURI string
+	challenge   string
+	nonce       string
+	user        User
+	expires     time.Time
+}
+
+// New returns a mock provider for issuer, the URL it is served at.
+func New(issuer, clientID string) (*Provider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Provider{
+		Issuer:   strings.TrimRight(issuer, "/"),
+		ClientID: clientID,
+		User: User{
+			Subject:    "mock-user",
+			Email:      "user@example.com",
+			GivenName:  "Mock",
+			FamilyName: "User",
+		},
+		key:    key,
+		keyID:  "mock-key",
+		grants: make(map[string]grant),
+	}, nil
+}
+
+// NewServer starts a mock provider on a local port; close the server when done.
+func NewServer(clientID string) (*Provider, *httptest.Server, error) {
+	var p *Provider
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.ServeHTTP(w, r)
+	}))
+
+	p, err := New(srv.URL, clientID)
+	if err != nil {
+		srv.Close()
+		return nil, nil, err
+	}
+
+	return p, srv, nil
+}
+
+// ServeHTTP serves discovery, the authorization and token endpoints, and the key set.
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		p.discovery(w)
+	case "/authorize":
+		p.authorize(w, r)
+	case "/token":
+		p.token(w, r)
+	case "/jwks":
+		p.jwks(w)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (p *Provider) discovery(w http.ResponseWriter) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.Issuer,
+		"authorization_endpoint":                p.Issuer + "/authorize",
+		"token_endpoint":                        p.Issuer + "/token",
+		"jwks_uri":                              p.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	redirectURI := q.Get("redirect_uri")
+	if q.Get("client_id") != p.ClientID || redirectURI == "" {
+		http.Error(w, "unknown client or redirect_uri", http.StatusBadRequest)
+		return
+	}
+	if q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "only the code flow with S256 PKCE is supported", http.StatusBadRequest)
+		return
+	}
+
+	user := p.User
+	if hint := q.Get("login_hint"); hint != "" {
+		user = User{Subject: "mock|" + hint, Email: hint, GivenName: "Mock", FamilyName: "User"}
+	}
+
+	code, err := oidc.NewVerifier()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	p.mu.Lock()
+	p.grants[code] = grant{
+		clientID:    p.ClientID,
+		redirectURI: redirectURI,
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+		user:        user,
+		expires:     time.Now().Add(codeExpiry),
+	}
+	p.mu.Unlock()
+
+	back, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	query := back.Query()
+	query.Set("code", code)
+	query.Set("state", q.Get("state"))
+	back.RawQuery = query.Encode()
+
+	http.Redirect(w, r, back.String(), http.StatusFound)
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+	if clientID != p.ClientID || subtle.ConstantTimeCompare([]byte(secret), []byte(p.ClientSecret)) != 1 {
+		tokenError(w, "invalid_client")
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type")
+		return
+	}
+
+	// codes work once
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	g, ok := p.grants[code]
+	delete(p.grants, code)
+	p.mu.Unlock()
+
+	if !ok || time.Now().After(g.expires) || g.clientID != clientID || g.redirectURI != r.PostForm.Get("redirect_uri") ||
+		oidc.Challenge(r.PostForm.Get("code_verifier")) != g.challenge {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	signed, err := p.Sign(p.Claims(g.user, g.nonce, time.Now()), p.keyID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	accessToken, err := oidc.NewVerifier()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     signed,
+	})
+}
+
+// Claims returns the claims of the ID token the provider issues to user at now, for its
+// client and with nonce. They last five minutes.
+func (p *Provider) Claims(user User, nonce string, now time.Time) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            p.Issuer,
+		"aud":            p.ClientID,
+		"sub":            user.Subject,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          nonce,
+		"email":          user.Email,
+		"email_verified": true,
+		"name":           strings.TrimSpace(user.GivenName + " " + user.FamilyName),
+		"given_name":     user.GivenName,
+		"family_name":    user.FamilyName,
+	}
+}
+
+// Sign signs claims as an ID token with the provider's key, naming kid as the key, so
+// tests can make tokens the provider would never issue.
+func (p *Provider) Sign(claims jwt.MapClaims, kid string) (string, error) {
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = kid
+	return idToken.SignedString(p.key)
+}
+
+// KeyID is the kid of the key the provider signs with.
+func (p *Provider) KeyID() string {
+	return p.keyID
+}
+
+func (p *Provider) jwks(w http.ResponseWriter) {
+	pub := p.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": p.keyID,
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
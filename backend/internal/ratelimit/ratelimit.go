@@ -0,0 +1,150 @@
+// Package ratelimit slows down guessing: once a key, such as an address or an account,
+// has failed a few times, each further failure makes it wait twice as long before the
+// next try, up to a lockout.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Entry is the recent failures of a key.
+type Entry struct {
+	Failures int       // Failures since the count last restarted
+	Last     time.Time // When the last failure happened
+}
+
+// Store keeps entries. Stores may be shared by limiters, which prefix their keys.
+type Store interface {
+	// Reserve decides whether key may try at now and, if so, records the try as a
+	// failure, in one step, so concurrent tries can't all pass before any is counted.
+	// wait is given the entry before the try, restarted if its last failure is more than
+	// window before now, and returns how long the key has to wait; the try is recorded
+	// only if that is 0. Reserve returns what wait did.
+	Reserve(key string, now time.Time, window time.Duration, wait func(Entry) time.Duration) (time.Duration, error)
+
+	// Release takes back one failure of key, for a reserved try that succeeded.
+	Release(key string) error
+
+	// Reset forgets the failures of key.
+	Reset(key string) error
+
+	// Prune forgets every key whose last failure was before before, and returns how
+	// many it forgot.
+	Prune(before time.Time) (int, error)
+}
+
+// Limiter decides how long a key has to wait before its next try.
+type Limiter struct {
+	Store  Store
+	Prefix string        // Prepended to every key, so limiters can share a store
+	Free   int           // Failures allowed before any wait
+	Base   time.Duration // Wait after the first failure beyond Free; doubled by each one after
+	Max    time.Duration // Longest wait, reached by repeated failures: the lockout
+	Window time.Duration // How long failures are remembered
+}
+
+// Reserve returns how long key has to wait, at now, before its next try; 0 if it may try.
+// If it may, the try is counted as a failure until Release or Reset is called.
+func (l *Limiter) Reserve(key string, now time.Time) (time.Duration, error) {
+	return l.Store.Reserve(l.Prefix+key, now, l.Window, func(e Entry) time.Duration {
+		return l.wait(e, now)
+	})
+}
+
+// Release takes back the failure a reserved try of key was counted as, once it succeeded.
+func (l *Limiter) Release(key string) error {
+	return l.Store.Release(l.Prefix + key)
+}
+
+// Reset forgets the failures of key, after it succeeded.
+func (l *Limiter) Reset(key string) error {
+	return l.Store.Reset(l.Prefix + key)
+}
+
+// wait returns how long the key of e has to wait at now.
+func (l *Limiter) wait(e Entry, now time.Time) time.Duration {
+	if e.Failures <= l.Free || now.Sub(e.Last) > l.Window {
+		return 0
+	}
+
+	delay := l.Max
+	if n := e.Failures - l.Free - 1; n < 62 {
+		if d := l.Base << n; d > 0 && d < l.Max {
+			delay = d
+		}
+	}
+
+	until := e.Last.Add(delay)
+	if !until.After(now) {
+		return 0
+	}
+	return until.Sub(now)
+}
+
+// MemoryStore keeps entries in memory, which suits a single server.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]Entry
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]Entry)}
+}
+
+// Reserve records a try of key, if wait allows it.
+func (s *MemoryStore) Reserve(key string, now time.Time, window time.Duration, wait func(Entry) time.Duration) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.entries[key]
+	if now.Sub(e.Last) > window {
+		e = Entry{}
+	}
+
+	if d := wait(e); d > 0 {
+		return d, nil
+	}
+
+	e.Failures++
+	e.Last = now
+	s.entries[key] = e
+	return 0, nil
+}
+
+// Release takes back one failure of key.
+func (s *MemoryStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[key]; ok && e.Failures > 0 {
+		e.Failures--
+		s.entries[key] = e
+	}
+	return nil
+}
+
+// Reset forgets key.
+func (s *MemoryStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+// Prune forgets the keys that last failed before before.
+func (s *MemoryStore) Prune(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for key, e := range s.entries {
+		if e.Last.Before(before) {
+			delete(s.entries, key)
+			n++
+		}
+	}
+	return n, nil
+}
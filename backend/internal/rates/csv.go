@@ -0,0 +1,99 @@
+// Package rates reads exchange rates for the exchange_rates table from CSV files.
+package rates
+
+import (
+	"backend/internal/models"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// columns are the header names ReadCSV requires, in any order.
+var columns = []string{"date", "base", "quote", "rate"}
+
+// ReadCSV reads exchange rates from CSV. The first row is a header naming the columns
+// date, base, quote and rate, in any order; other columns are ignored. Each row says
+// that on date (YYYY-MM-DD), one unit of base was worth rate units of quote.
+//
+//	date,base,quote,rate
+//	2024-01-02,USD,CAD,1.3316
+func ReadCSV(r io.Reader) ([]*models.ExchangeRate, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, errors.New("empty rates file")
+		}
+		return nil, err
+	}
+
+	index := make(map[string]int)
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range columns {
+		if _, ok := index[name]; !ok {
+			return nil, fmt.Errorf("rates file has no %s column", name)
+		}
+	}
+
+	var rates []*models.ExchangeRate
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		line, _ := cr.FieldPos(0)
+
+		rate, err := parseRecord(record, index)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		rates = append(rates, rate)
+	}
+
+	return rates, nil
+}
+
+func parseRecord(record []string, index map[string]int) (*models.ExchangeRate, error) {
+	var rate models.ExchangeRate
+	var err error
+
+	rate.Date, err = time.Parse("2006-01-02", record[index["date"]])
+	if err != nil {
+		return nil, fmt.Errorf("invalid date %q", record[index["date"]])
+	}
+
+	rate.Base, err = models.NormalizeCurrency(record[index["base"]])
+	if err != nil {
+		return nil, err
+	}
+
+	rate.Quote, err = models.NormalizeCurrency(record[index["quote"]])
+	if err != nil {
+		return nil, err
+	}
+
+	if rate.Base == rate.Quote {
+		return nil, fmt.Errorf("rate from %s to itself", rate.Base)
+	}
+
+	rate.Rate, err = strconv.ParseFloat(strings.TrimSpace(record[index["rate"]]), 64)
+	if err != nil || rate.Rate <= 0 {
+		return nil, fmt.Errorf("invalid rate %q", record[index["rate"]])
+	}
+
+	return &rate, nil
+}
@@ -0,0 +1,331 @@
+// Package recurrence expands repeating schedules, written as a subset of the iCalendar
+// RRULE syntax, into the dates they fall on.
+package recurrence
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequencies a rule can repeat at.
+const (
+	Daily   = "DAILY"
+	Weekly  = "WEEKLY"
+	Monthly = "MONTHLY"
+	Yearly  = "YEARLY"
+)
+
+// maxIterations bounds how many periods a rule is expanded over, so a rule with a huge
+// window cannot spin forever.
+const maxIterations = 100000
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// Presets are the named schedules a template can use instead of writing a rule.
+// Semi-monthly falls on the 15th and the last day of the month.
+var Presets = map[string]string{
+	"weekly":      "FREQ=WEEKLY",
+	"biweekly":    "FREQ=WEEKLY;INTERVAL=2",
+	"semimonthly": "FREQ=MONTHLY;BYMONTHDAY=15,-1",
+	"monthly":     "FREQ=MONTHLY",
+}
+
+// Rule is a parsed recurrence rule. Parts a rule leaves out are taken from the start
+// date it is expanded from: the weekday for weekly rules, the day of the month for
+// monthly rules, and both for yearly rules.
+type Rule struct {
+	Freq       string
+	Interval   int
+	ByDay      []time.Weekday
+	ByMonthDay []int     // Negative days count back from the end of the month
+	Count      int       // Zero for no limit
+	Until      time.Time // Zero for no limit
+}
+
+// Parse reads a rule such as "FREQ=MONTHLY;INTERVAL=1;BYMONTHDAY=1,15". It supports the
+// FREQ, INTERVAL, BYDAY, BYMONTHDAY, COUNT and UNTIL parts; UNTIL is a YYYYMMDD date.
+func Parse(s string) (*Rule, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(strings.ToUpper(s)), "RRULE:")
+	if s == "" {
+		return nil, errors.New("empty recurrence rule")
+	}
+
+	rule := Rule{Interval: 1}
+
+	for _, part := range strings.Split(s, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("malformed rule part %q", part)
+		}
+
+		switch key {
+		case "FREQ":
+			switch value {
+			case Daily, Weekly, Monthly, Yearly:
+				rule.Freq = value
+			default:
+				return nil, fmt.Errorf("unsupported frequency %q", value)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid interval %q", value)
+			}
+			rule.Interval = n
+		case "BYDAY":
+			for _, d := range strings.Split(value, ",") {
+				day, ok := weekdays[d]
+				if !ok {
+					return nil, fmt.Errorf("invalid weekday %q", d)
+				}
+				rule.ByDay = append(rule.ByDay, day)
+			}
+		case "BYMONTHDAY":
+			for _, d := range strings.Split(value, ",") {
+				n, err := strconv.Atoi(d)
+				if err != nil || n == 0 || n < -31 || n > 31 {
+					return nil, fmt.Errorf("invalid day of month %q", d)
+				}
+				rule.ByMonthDay = append(rule.ByMonthDay, n)
+			}
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid count %q", value)
+			}
+			rule.Count = n
+		case "UNTIL":
+			until, err := time.Parse("20060102", value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid until date %q", value)
+			}
+			rule.Until = until
+		default:
+			return nil, fmt.Errorf("unsupported rule part %q", key)
+		}
+	}
+
+	if rule.Freq == "" {
+		return nil, errors.New("recurrence rule has no FREQ")
+	}
+
+	if len(rule.ByDay) > 0 && rule.Freq != Weekly && rule.Freq != Monthly {
+		return nil, errors.New("BYDAY is only supported for weekly and monthly rules")
+	}
+
+	if len(rule.ByMonthDay) > 0 && rule.Freq != Monthly {
+		return nil, errors.New("BYMONTHDAY is only supported for monthly rules")
+	}
+
+	if len(rule.ByDay) > 0 && len(rule.ByMonthDay) > 0 {
+		return nil, errors.New("BYDAY and BYMONTHDAY cannot be combined")
+	}
+
+	return &rule, nil
+}
+
+// String writes the rule back in its canonical form.
+func (r *Rule) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, day := range r.ByDay {
+			days[i] = strings.ToUpper(day.String()[:2])
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+
+	if len(r.ByMonthDay) > 0 {
+		days := make([]string, len(r.ByMonthDay))
+		for i, day := range r.ByMonthDay {
+			days[i] = strconv.Itoa(day)
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.Format("20060102"))
+	}
+
+	return strings.Join(parts, ";")
+}
+
+// Between returns the dates, oldest first, that a schedule starting on start falls on
+// from from to to, both inclusive. The start date counts as an occurrence only if the
+// rule matches it.
+func (r *Rule) Between(start, from, to time.Time) []time.Time {
+	start, from, to = date(start), date(from), date(to)
+	if !r.Until.IsZero() && date(r.Until).Before(to) {
+		to = date(r.Until)
+	}
+
+	dates := []time.Time{}
+	seen := 0
+
+	period := r.firstPeriod(start)
+	for i := 0; i < maxIterations && !period.After(to); i++ {
+		for _, d := range r.expand(period, start) {
+			if d.Before(start) || d.After(to) {
+				continue
+			}
+
+			seen++
+			if r.Count > 0 && seen > r.Count {
+				return dates
+			}
+
+			if !d.Before(from) {
+				dates = append(dates, d)
+			}
+		}
+
+		period = r.nextPeriod(period)
+	}
+
+	return dates
+}
+
+// Matches reports whether a schedule starting on start falls on day.
+func (r *Rule) Matches(start, day time.Time) bool {
+	return len(r.Between(start, day, day)) > 0
+}
+
+// firstPeriod is the start of the day, week (from Monday), month or year holding start.
+func (r *Rule) firstPeriod(start time.Time) time.Time {
+	switch r.Freq {
+	case Weekly:
+		offset := (int(start.Weekday()) + 6) % 7
+		return start.AddDate(0, 0, -offset)
+	case Monthly:
+		return time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case Yearly:
+		return time.Date(start.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return start
+	}
+}
+
+// nextPeriod steps one interval forward from the start of a period.
+func (r *Rule) nextPeriod(period time.Time) time.Time {
+	switch r.Freq {
+	case Weekly:
+		return period.AddDate(0, 0, 7*r.Interval)
+	case Monthly:
+		return period.AddDate(0, r.Interval, 0)
+	case Yearly:
+		return period.AddDate(r.Interval, 0, 0)
+	default:
+		return period.AddDate(0, 0, r.Interval)
+	}
+}
+
+// expand lists the dates the rule falls on within one period, oldest first.
+func (r *Rule) expand(period, start time.Time) []time.Time {
+	switch r.Freq {
+	case Weekly:
+		days := r.ByDay
+		if len(days) == 0 {
+			days = []time.Weekday{start.Weekday()}
+		}
+
+		dates := []time.Time{}
+		for _, day := range days {
+			offset := (int(day) + 6) % 7
+			dates = append(dates, period.AddDate(0, 0, offset))
+		}
+		return dedupe(sorted(dates))
+
+	case Monthly:
+		last := daysIn(period.Year(), period.Month())
+
+		if len(r.ByDay) > 0 {
+			dates := []time.Time{}
+			for d := 1; d <= last; d++ {
+				t := period.AddDate(0, 0, d-1)
+				for _, day := range r.ByDay {
+					if t.Weekday() == day {
+						dates = append(dates, t)
+						break
+					}
+				}
+			}
+			return dates
+		}
+
+		// without BYMONTHDAY, keep the start's day, moved back in shorter months
+		if len(r.ByMonthDay) == 0 {
+			day := start.Day()
+			if day > last {
+				day = last
+			}
+			return []time.Time{period.AddDate(0, 0, day-1)}
+		}
+
+		dates := []time.Time{}
+		for _, day := range r.ByMonthDay {
+			if day < 0 {
+				day = last + day + 1
+			}
+			if day < 1 || day > last {
+				continue
+			}
+			dates = append(dates, period.AddDate(0, 0, day-1))
+		}
+		return dedupe(sorted(dates))
+
+	case Yearly:
+		day := start.Day()
+		if last := daysIn(period.Year(), start.Month()); day > last {
+			day = last
+		}
+		return []time.Time{time.Date(period.Year(), start.Month(), day, 0, 0, 0, 0, time.UTC)}
+
+	default:
+		return []time.Time{period}
+	}
+}
+
+// date drops the time of day, keeping the calendar date.
+func date(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+func sorted(dates []time.Time) []time.Time {
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+	return dates
+}
+
+func dedupe(dates []time.Time) []time.Time {
+	out := dates[:0]
+	for i, d := range dates {
+		if i == 0 || !d.Equal(dates[i-1]) {
+			out = append(out, d)
+		}
+	}
+	return out
+}
@@ -0,0 +1,105 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"context"
+	"strings"
+	"time"
+)
+
+// AllAccessTokens returns the personal access tokens of the user, the newest first.
+func (m *PostgresDBRepo) AllAccessTokens(userID int) ([]*models.AccessToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, name, scopes, expires_at, last_used_at, created_at
+			from access_tokens where user_id = $1 order by created_at desc, id desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*models.AccessToken{}
+
+	for rows.Next() {
+		var token models.AccessToken
+		var scopes string
+		err := rows.Scan(
+			&token.ID,
+			&token.UserID,
+			&token.Name,
+			&scopes,
+			&token.ExpiresAt,
+			&token.LastUsedAt,
+			&token.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		token.Scopes = strings.Fields(scopes)
+		tokens = append(tokens, &token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// InsertAccessToken stores a new personal access token, and sets its id.
+func (m *PostgresDBRepo) InsertAccessToken(token *models.AccessToken) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into access_tokens (user_id, name, token_hash, scopes, expires_at, created_at)
+			values ($1, $2, $3, $4, $5, $6) returning id`
+
+	return m.DB.QueryRowContext(ctx, stmt, token.UserID, token.Name, token.TokenHash,
+		strings.Join(token.Scopes, " "), token.ExpiresAt, token.CreatedAt).Scan(&token.ID)
+}
+
+// DeleteAccessToken revokes one of the user's personal access tokens. It returns
+// sql.ErrNoRows if the user has no such token.
+func (m *PostgresDBRepo) DeleteAccessToken(userID, id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, `delete from access_tokens where id = $1 and user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	return expectOneRow(res)
+}
+
+// UseAccessToken returns the unexpired personal access token with the given hash, and
+// records that it was used at now. It returns sql.ErrNoRows if there is no such token.
+func (m *PostgresDBRepo) UseAccessToken(tokenHash string, now time.Time) (*models.AccessToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `update access_tokens set last_used_at = $1
+			where token_hash = $2 and (expires_at is null or expires_at > $1)
+			returning id, user_id, name, scopes, expires_at, last_used_at, created_at`
+
+	var token models.AccessToken
+	var scopes string
+
+	err := m.DB.QueryRowContext(ctx, query, now, tokenHash).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Name,
+		&scopes,
+		&token.ExpiresAt,
+		&token.LastUsedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Scopes = strings.Fields(scopes)
+	token.TokenHash = tokenHash
+
+	return &token, nil
+}
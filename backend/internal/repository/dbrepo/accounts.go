@@ -0,0 +1,331 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"errors"
+
+	"github.com/jackc/pgconn"
+)
+
+// accountColumns selects an account aliased as a, with its current balance: the opening
+// balance, plus incomes and transfers in, minus expenses and transfers out.
+const accountColumns = `a.id, a.user_id, a.name, a.type, a.currency, a.opening_balance,
+		a.opening_balance
+		+ coalesce((select sum(amount) from incomes where account_id = a.id), 0)
+		- coalesce((select sum(amount) from expenses where account_id = a.id), 0)
+		+ coalesce((select sum(amount) from transfers where to_account_id = a.id), 0)
+		- coalesce((select sum(amount) from transfers where from_account_id = a.id), 0),
+		a.created_at, a.updated_at`
+
+func scanAccount(row interface {
+	Scan(dest ...interface{}) error
+}) (*models.Account, error) {
+	var account models.Account
+	err := row.Scan(
+		&account.ID,
+		&account.UserID,
+		&account.Name,
+		&account.Type,
+		&account.Currency,
+		&account.OpeningBalance,
+		&account.Balance,
+		&account.CreatedAt,
+		&account.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &account, nil
+}
+
+// AllAccounts returns the user's accounts with their current balances, sorted by name.
+func (m *PostgresDBRepo) AllAccounts(userID int) ([]*models.Account, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + accountColumns + ` from accounts a where a.user_id = $1 order by a.name`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []*models.Account{}
+
+	for rows.Next() {
+		account, err := scanAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+
+	return accounts, rows.Err()
+}
+
+// OneAccount returns one account with its current balance, if it belongs to the user.
+func (m *PostgresDBRepo) OneAccount(id, userID int) (*models.Account, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + accountColumns + ` from accounts a where a.id = $1 and a.user_id = $2`
+
+	return scanAccount(m.DB.QueryRowContext(ctx, query, id, userID))
+}
+
+// InsertAccount inserts one account and returns its id.
+func (m *PostgresDBRepo) InsertAccount(account *models.Account) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into accounts (user_id, name, type, currency, opening_balance, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	newID, err := m.insertAudited(ctx, models.AuditAccount, stmt,
+		account.UserID,
+		account.Name,
+		account.Type,
+		account.Currency,
+		account.OpeningBalance,
+		account.CreatedAt,
+		account.UpdatedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateAccount updates one account belonging to account.UserID. An account's
+// transactions and transfers are in its currency, so once it has any its currency
+// cannot change, and returns repository.ErrInUse. It returns sql.ErrNoRows if no
+// matching account exists for the user.
+func (m *PostgresDBRepo) UpdateAccount(account *models.Account) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the snapshot locks the account, so nothing is added to it meanwhile
+	key := recordKey(models.AuditAccount, account.ID)
+	before, err := snapshot(ctx, tx, key)
+	if err != nil {
+		return err
+	}
+
+	query := `select exists (select 1 from accounts where id = $1 and user_id = $2 and currency <> $3)
+			and (exists (select 1 from incomes where account_id = $1)
+				or exists (select 1 from expenses where account_id = $1)
+				or exists (select 1 from transfers where from_account_id = $1 or to_account_id = $1))`
+
+	var inUse bool
+	err = tx.QueryRowContext(ctx, query, account.ID, account.UserID, account.Currency).Scan(&inUse)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return repository.ErrInUse
+	}
+
+	stmt := `update accounts set name = $1, type = $2, currency = $3, opening_balance = $4, updated_at = $5
+			where id = $6 and user_id = $7`
+
+	res, err := tx.ExecContext(ctx, stmt,
+		account.Name,
+		account.Type,
+		account.Currency,
+		account.OpeningBalance,
+		account.UpdatedAt,
+		account.ID,
+		account.UserID,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = expectOneRow(res)
+	if err != nil {
+		return err
+	}
+
+	err = m.logChange(ctx, tx, models.AuditUpdate, key, before)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteAccount deletes one account, by id, if it belongs to the user. Incomes, expenses,
+// goals, recurring templates and import mappings assigned to it are kept, unassigned, and
+// each is logged as changed. An account with transfers cannot be deleted until they are,
+// and returns repository.ErrInUse. It returns sql.ErrNoRows if no matching account exists.
+func (m *PostgresDBRepo) DeleteAccount(id, userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var deps []dependents
+	for _, entity := range []string{models.AuditIncome, models.AuditExpense, models.AuditGoal,
+		models.AuditRecurring, models.AuditImportMapping} {
+		deps = append(deps, dependents{entity: entity, action: models.AuditUpdate, where: "account_id = $1",
+			args: []interface{}{id}})
+	}
+
+	stmt := `delete from accounts where id = $1 and user_id = $2`
+
+	err := m.deleteAudited(ctx, recordKey(models.AuditAccount, id), deps, stmt, id, userID)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+		return repository.ErrInUse
+	}
+
+	return err
+}
+
+// AccountLedger returns every transaction of one of the user's accounts, oldest first,
+// each with the running balance after it. It returns sql.ErrNoRows if the account does
+// not exist for the user.
+func (m *PostgresDBRepo) AccountLedger(id, userID int) ([]*models.LedgerEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var openingBalance models.Money
+	err := m.DB.QueryRowContext(ctx, `select opening_balance from accounts where id = $1 and user_id = $2`,
+		id, userID).Scan(&openingBalance)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		with entries as (
+			select date, 'income' as kind, id, description, amount
+			from incomes where account_id = $1 and user_id = $2
+			union all
+			select date, 'expense', id, description, -amount
+			from expenses where account_id = $1 and user_id = $2
+			union all
+			select date, 'transfer', id, description, amount
+			from transfers where to_account_id = $1 and user_id = $2
+			union all
+			select date, 'transfer', id, description, -amount
+			from transfers where from_account_id = $1 and user_id = $2
+		)
+		select date, kind, id, coalesce(description, ''), amount,
+			$3::numeric + sum(amount) over (order by date, kind, id rows between unbounded preceding and current row)
+		from entries
+		order by date, kind, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, id, userID, openingBalance)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ledger := []*models.LedgerEntry{}
+
+	for rows.Next() {
+		var entry models.LedgerEntry
+		err := rows.Scan(
+			&entry.Date,
+			&entry.Kind,
+			&entry.ID,
+			&entry.Description,
+			&entry.Amount,
+			&entry.Balance,
+		)
+		if err != nil {
+			return nil, err
+		}
+		ledger = append(ledger, &entry)
+	}
+
+	return ledger, rows.Err()
+}
+
+// AllTransfers returns the user's transfers, newest first.
+func (m *PostgresDBRepo) AllTransfers(userID int) ([]*models.Transfer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, from_account_id, to_account_id, amount, date, coalesce(description, ''),
+			created_at, updated_at from transfers where user_id = $1 order by date desc, id desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transfers := []*models.Transfer{}
+
+	for rows.Next() {
+		var transfer models.Transfer
+		err := rows.Scan(
+			&transfer.ID,
+			&transfer.UserID,
+			&transfer.FromAccountID,
+			&transfer.ToAccountID,
+			&transfer.Amount,
+			&transfer.Date,
+			&transfer.Description,
+			&transfer.CreatedAt,
+			&transfer.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, &transfer)
+	}
+
+	return transfers, rows.Err()
+}
+
+// InsertTransfer inserts one transfer between two of transfer.UserID's accounts and
+// returns its id. It returns sql.ErrNoRows if either account does not belong to the user.
+func (m *PostgresDBRepo) InsertTransfer(transfer *models.Transfer) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	// only insert when both accounts belong to the user
+	stmt := `insert into transfers (user_id, from_account_id, to_account_id, amount, date, description, created_at, updated_at)
+			select $1, $2, $3, $4, $5, $6, $7, $8
+			where (select count(*) from accounts where user_id = $1 and id in ($2, $3)) = 2
+			returning id`
+
+	newID, err := m.insertAudited(ctx, models.AuditTransfer, stmt,
+		transfer.UserID,
+		transfer.FromAccountID,
+		transfer.ToAccountID,
+		transfer.Amount,
+		transfer.Date,
+		transfer.Description,
+		transfer.CreatedAt,
+		transfer.UpdatedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteTransfer deletes one transfer, by id, if it belongs to the user. It returns
+// sql.ErrNoRows if no matching transfer exists for the user.
+func (m *PostgresDBRepo) DeleteTransfer(id, userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `delete from transfers where id = $1 and user_id = $2`
+
+	return m.execAudited(ctx, models.AuditDelete, recordKey(models.AuditTransfer, id), stmt, id, userID)
+}
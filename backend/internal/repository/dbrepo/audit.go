@@ -0,0 +1,417 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// auditTables maps each audited entity to its table.
+var auditTables = map[string]string{
+	models.AuditIncome:              "incomes",
+	models.AuditExpense:             "expenses",
+	models.AuditSource:              "sources",
+	models.AuditCategory:            "categories",
+	models.AuditAccount:             "accounts",
+	models.AuditTransfer:            "transfers",
+	models.AuditBudget:              "budgets",
+	models.AuditGoal:                "goals",
+	models.AuditGoalContribution:    "goal_contributions",
+	models.AuditRecurring:           "recurring_templates",
+	models.AuditRecurringOccurrence: "recurring_occurrences",
+	models.AuditImportMapping:       "import_mappings",
+	models.AuditHousehold:           "households",
+	models.AuditHouseholdMember:     "household_members",
+	models.AuditHouseholdInvite:     "household_invites",
+}
+
+// auditKey names one audited record.
+type auditKey struct {
+	entity string
+	id     int // What the record is browsed by
+
+	// where finds the row, with args, for records not keyed by id alone
+	where string
+	args  []interface{}
+
+	// householdID is the household of a record that doesn't hold one itself
+	householdID *int
+}
+
+// recordKey names the record of entity with the given id.
+func recordKey(entity string, id int) auditKey {
+	return auditKey{entity: entity, id: id}
+}
+
+// WithActor returns a copy of the repository whose writes are recorded in the audit log
+// as made by actor.
+func (m *PostgresDBRepo) WithActor(actor models.AuditActor) repository.DatabaseRepo {
+	c := *m
+	c.actor = &actor
+	return &c
+}
+
+// snapshot returns the record key names, as JSON, locking it for the rest of the
+// transaction. It returns nil if there is no such record.
+func snapshot(ctx context.Context, tx *sql.Tx, key auditKey) (json.RawMessage, error) {
+	where, args := key.where, key.args
+	if where == "" {
+		where, args = "id = $1", []interface{}{key.id}
+	}
+
+	// invites keep only the hash of their token, but even that stays out of the log
+	query := `select to_jsonb(t) - 'token_hash' from ` + auditTables[key.entity] + ` t where ` + where + ` for update`
+
+	var row []byte
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return row, nil
+}
+
+// logChange appends the change a write just made to the record key names to the audit
+// log, as made by the repository's actor. The record is read as it is now, unless it
+// was deleted; before is what snapshot returned ahead of the write, and nil for an
+// insert.
+func (m *PostgresDBRepo) logChange(ctx context.Context, tx *sql.Tx, action string, key auditKey, before json.RawMessage) error {
+	var after json.RawMessage
+	if action != models.AuditDelete {
+		var err error
+		after, err = snapshot(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	entry := models.AuditEntry{
+		Entity:    key.entity,
+		EntityID:  key.id,
+		Action:    action,
+		Before:    before,
+		After:     after,
+		CreatedAt: time.Now(),
+	}
+
+	// who the record belongs to is read from the record itself
+	var owner struct {
+		HouseholdID *int `json:"household_id"`
+		UserID      *int `json:"user_id"`
+	}
+	row := after
+	if row == nil {
+		row = before
+	}
+	if row != nil {
+		err := json.Unmarshal(row, &owner)
+		if err != nil {
+			return err
+		}
+	}
+
+	entry.HouseholdID, entry.OwnerID = owner.HouseholdID, owner.UserID
+	if key.entity == models.AuditHousehold {
+		entry.HouseholdID = &key.id
+	}
+	if key.householdID != nil {
+		entry.HouseholdID = key.householdID
+	}
+
+	if m.actor != nil {
+		entry.ActorID = &m.actor.UserID
+		entry.AccessTokenID = m.actor.AccessTokenID
+		entry.IPAddress = m.actor.IPAddress
+		entry.UserAgent = m.actor.UserAgent
+		entry.RequestID = m.actor.RequestID
+		entry.Method = m.actor.Method
+		entry.Path = m.actor.Path
+	}
+
+	stmt := `insert into audit_log (actor_id, access_token_id, household_id, owner_id, entity, entity_id, action,
+				before, after, ip_address, user_agent, request_id, method, path, created_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`
+
+	_, err := tx.ExecContext(ctx, stmt,
+		entry.ActorID,
+		entry.AccessTokenID,
+		entry.HouseholdID,
+		entry.OwnerID,
+		entry.Entity,
+		entry.EntityID,
+		entry.Action,
+		nullJSON(entry.Before),
+		nullJSON(entry.After),
+		entry.IPAddress,
+		entry.UserAgent,
+		entry.RequestID,
+		entry.Method,
+		entry.Path,
+		entry.CreatedAt,
+	)
+	return err
+}
+
+// nullJSON passes a missing record to the database as null.
+func nullJSON(row json.RawMessage) interface{} {
+	if row == nil {
+		return nil
+	}
+	return string(row)
+}
+
+// AuditHistory returns the changes to one record, the oldest first, if the record
+// belongs to the household, or is the user's own.
+func (m *PostgresDBRepo) AuditHistory(entity string, id, householdID, userID int) ([]*models.AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, actor_id, access_token_id, household_id, owner_id, entity, entity_id, action,
+				before, after, ip_address, user_agent, request_id, method, path, created_at
+			from audit_log
+			where entity = $1 and entity_id = $2
+				and (household_id = $3 or (household_id is null and owner_id = $4))
+			order by id`
+
+	rows, err := m.DB.QueryContext(ctx, query, entity, id, householdID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*models.AuditEntry{}
+
+	for rows.Next() {
+		var entry models.AuditEntry
+		var before, after []byte
+		err := rows.Scan(
+			&entry.ID,
+			&entry.ActorID,
+			&entry.AccessTokenID,
+			&entry.HouseholdID,
+			&entry.OwnerID,
+			&entry.Entity,
+			&entry.EntityID,
+			&entry.Action,
+			&before,
+			&after,
+			&entry.IPAddress,
+			&entry.UserAgent,
+			&entry.RequestID,
+			&entry.Method,
+			&entry.Path,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if before != nil {
+			entry.Before = before
+		}
+		if after != nil {
+			entry.After = after
+		}
+		entries = append(entries, &entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// execAudited runs stmt, which must update or delete the one record key names, in a
+// transaction with its entry in the audit log. It returns sql.ErrNoRows if stmt affects
+// no rows.
+func (m *PostgresDBRepo) execAudited(ctx context.Context, action string, key auditKey, stmt string, args ...interface{}) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := snapshot(ctx, tx, key)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	err = expectOneRow(res)
+	if err != nil {
+		return err
+	}
+
+	err = m.logChange(ctx, tx, action, key, before)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertAudited runs stmt, which must insert one record of entity and return its id, in
+// a transaction with its entry in the audit log, and returns the id.
+func (m *PostgresDBRepo) insertAudited(ctx context.Context, entity string, stmt string, args ...interface{}) (int, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var newID int
+	err = tx.QueryRowContext(ctx, stmt, args...).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = m.logChange(ctx, tx, models.AuditInsert, recordKey(entity, newID), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// dependents are the records of entity matching where, with args, that deleting another
+// record reaches through a foreign key: action is models.AuditDelete if the key cascades,
+// and models.AuditUpdate if it sets null. Only records keyed by id can be set null.
+type dependents struct {
+	entity string
+	action string
+	where  string
+	args   []interface{}
+
+	// householdID is the household of records that don't hold one themselves
+	householdID *int
+}
+
+// dependent is one record a delete reaches, as it was before.
+type dependent struct {
+	action string
+	key    auditKey
+	before json.RawMessage
+}
+
+// snapshotDependents returns the records of each of deps, locking them for the rest of
+// the transaction, so what the foreign keys do to them can be logged after the delete.
+func snapshotDependents(ctx context.Context, tx *sql.Tx, deps ...dependents) ([]dependent, error) {
+	var found []dependent
+
+	for _, d := range deps {
+		query := `select to_jsonb(t) - 'token_hash' from ` + auditTables[d.entity] + ` t where ` + d.where + ` for update`
+
+		rows, err := tx.QueryContext(ctx, query, d.args...)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var row []byte
+			err = rows.Scan(&row)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+
+			// records are browsed by their id, members by their user and occurrences by
+			// their template
+			var ids struct {
+				ID         int `json:"id"`
+				UserID     int `json:"user_id"`
+				TemplateID int `json:"template_id"`
+			}
+			err = json.Unmarshal(row, &ids)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+
+			key := recordKey(d.entity, ids.ID)
+			switch d.entity {
+			case models.AuditHouseholdMember:
+				key.id = ids.UserID
+			case models.AuditRecurringOccurrence:
+				key.id = ids.TemplateID
+			}
+			key.householdID = d.householdID
+
+			found = append(found, dependent{action: d.action, key: key, before: row})
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return found, nil
+}
+
+// logDependents logs what a delete did to the records snapshotDependents returned ahead
+// of it.
+func (m *PostgresDBRepo) logDependents(ctx context.Context, tx *sql.Tx, deps []dependent) error {
+	for _, d := range deps {
+		err := m.logChange(ctx, tx, d.action, d.key, d.before)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteAudited runs stmt, which must delete the one record key names, in a transaction
+// with its entry in the audit log, and those of what the delete does to deps through
+// foreign keys.
+func (m *PostgresDBRepo) deleteAudited(ctx context.Context, key auditKey, deps []dependents, stmt string, args ...interface{}) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// lock the record before what refers to it
+	before, err := snapshot(ctx, tx, key)
+	if err != nil {
+		return err
+	}
+
+	found, err := snapshotDependents(ctx, tx, deps...)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	err = expectOneRow(res)
+	if err != nil {
+		return err
+	}
+
+	err = m.logChange(ctx, tx, models.AuditDelete, key, before)
+	if err != nil {
+		return err
+	}
+
+	err = m.logDependents(ctx, tx, found)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
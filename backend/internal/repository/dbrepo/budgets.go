@@ -0,0 +1,219 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"errors"
+
+	"github.com/jackc/pgconn"
+)
+
+// AllBudgets returns the household's budgets with their categories, sorted by category
+// name.
+func (m *PostgresDBRepo) AllBudgets(householdID int) ([]*models.Budget, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select b.id, b.user_id, b.household_id, b.category_id, b.amount, b.created_at, b.updated_at,
+			c.id, c.user_id, c.household_id, c.name, c.created_at, c.updated_at
+			from budgets b join categories c on b.category_id = c.id
+			where b.household_id = $1 order by c.name`
+
+	rows, err := m.DB.QueryContext(ctx, query, householdID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	budgets := []*models.Budget{}
+
+	for rows.Next() {
+		var budget models.Budget
+		var category models.Category
+		err := rows.Scan(
+			&budget.ID,
+			&budget.UserID,
+			&budget.HouseholdID,
+			&budget.CategoryID,
+			&budget.Amount,
+			&budget.CreatedAt,
+			&budget.UpdatedAt,
+			&category.ID,
+			&category.UserID,
+			&category.HouseholdID,
+			&category.Name,
+			&category.CreatedAt,
+			&category.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		budget.Category = &category
+		budgets = append(budgets, &budget)
+	}
+
+	return budgets, rows.Err()
+}
+
+// OneBudget returns one budget, with its category, if it belongs to the household.
+func (m *PostgresDBRepo) OneBudget(id, householdID int) (*models.Budget, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select b.id, b.user_id, b.household_id, b.category_id, b.amount, b.created_at, b.updated_at,
+			c.id, c.user_id, c.household_id, c.name, c.created_at, c.updated_at
+			from budgets b join categories c on b.category_id = c.id
+			where b.id = $1 and b.household_id = $2`
+
+	var budget models.Budget
+	var category models.Category
+
+	err := m.DB.QueryRowContext(ctx, query, id, householdID).Scan(
+		&budget.ID,
+		&budget.UserID,
+		&budget.HouseholdID,
+		&budget.CategoryID,
+		&budget.Amount,
+		&budget.CreatedAt,
+		&budget.UpdatedAt,
+		&category.ID,
+		&category.UserID,
+		&category.HouseholdID,
+		&category.Name,
+		&category.CreatedAt,
+		&category.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	budget.Category = &category
+
+	return &budget, nil
+}
+
+// InsertBudget inserts one budget and returns its id. The category is looked up by
+// name, and created if the household doesn't have it yet. It returns
+// repository.ErrDuplicate if the category already has a budget.
+func (m *PostgresDBRepo) InsertBudget(budget *models.Budget) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	categoryID, err := m.getOrCreateCategory(ctx, tx, budget.HouseholdID, budget.Category.UserID, budget.Category)
+	if err != nil {
+		return 0, err
+	}
+
+	budget.CategoryID = categoryID
+
+	stmt := `insert into budgets (user_id, household_id, category_id, amount, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6) returning id`
+
+	var newID int
+
+	err = tx.QueryRowContext(ctx, stmt,
+		budget.UserID,
+		budget.HouseholdID,
+		budget.CategoryID,
+		budget.Amount,
+		budget.CreatedAt,
+		budget.UpdatedAt,
+	).Scan(&newID)
+	if err != nil {
+		return 0, duplicate(err)
+	}
+
+	err = m.logChange(ctx, tx, models.AuditInsert, recordKey(models.AuditBudget, newID), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateBudget updates one budget belonging to budget.HouseholdID. The category is
+// looked up by name, and created if the household doesn't have it yet. It returns
+// sql.ErrNoRows if no matching budget exists in the household, and
+// repository.ErrDuplicate if the category already has another budget.
+func (m *PostgresDBRepo) UpdateBudget(budget *models.Budget) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	key := recordKey(models.AuditBudget, budget.ID)
+	before, err := snapshot(ctx, tx, key)
+	if err != nil {
+		return err
+	}
+
+	categoryID, err := m.getOrCreateCategory(ctx, tx, budget.HouseholdID, budget.Category.UserID, budget.Category)
+	if err != nil {
+		return err
+	}
+
+	budget.CategoryID = categoryID
+
+	stmt := `update budgets set category_id = $1, amount = $2, updated_at = $3
+			where id = $4 and household_id = $5`
+
+	res, err := tx.ExecContext(ctx, stmt,
+		budget.CategoryID,
+		budget.Amount,
+		budget.UpdatedAt,
+		budget.ID,
+		budget.HouseholdID,
+	)
+	if err != nil {
+		return duplicate(err)
+	}
+
+	err = expectOneRow(res)
+	if err != nil {
+		return err
+	}
+
+	err = m.logChange(ctx, tx, models.AuditUpdate, key, before)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteBudget deletes one budget, by id, if it belongs to the household. It returns
+// sql.ErrNoRows if no matching budget exists in the household.
+func (m *PostgresDBRepo) DeleteBudget(id, householdID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `delete from budgets where id = $1 and household_id = $2`
+
+	return m.execAudited(ctx, models.AuditDelete, recordKey(models.AuditBudget, id), stmt, id, householdID)
+}
+
+// duplicate turns a unique constraint violation into repository.ErrDuplicate.
+func duplicate(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return repository.ErrDuplicate
+	}
+	return err
+}
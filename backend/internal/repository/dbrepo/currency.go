@@ -0,0 +1,62 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"context"
+	"fmt"
+)
+
+// baseCurrency is a SQL expression for the base currency of the household bound to $1.
+const baseCurrency = `(select base_currency from households where id = $1)`
+
+// inBaseCurrency returns a SQL expression for the amount of the income or expense
+// aliased as alias, converted into the base currency of the household bound to $1. It uses
+// the most recent rate on or before the transaction's date, inverting a rate quoted the
+// other way round, and rounds to the cent. The expression is null when no rate is known.
+func inBaseCurrency(alias string) string {
+	return fmt.Sprintf(`(case when %[1]s.currency = %[2]s then %[1]s.amount
+		else round(%[1]s.amount * (
+			select fx.rate from (
+				select date, rate from exchange_rates
+				where base = %[1]s.currency and quote = %[2]s and date <= %[1]s.date
+				union all
+				select date, 1 / rate from exchange_rates
+				where base = %[2]s and quote = %[1]s.currency and date <= %[1]s.date
+			) fx order by fx.date desc limit 1
+		), 2) end)`, alias, baseCurrency)
+}
+
+// UpsertExchangeRates stores rates in a single transaction, replacing any rate already
+// stored for the same pair and date. It returns the number of rates stored.
+func (m *PostgresDBRepo) UpsertExchangeRates(rates []*models.ExchangeRate) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout*10)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `insert into exchange_rates (date, base, quote, rate)
+			values ($1, $2, $3, $4)
+			on conflict (base, quote, date) do update set rate = excluded.rate`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	for _, rate := range rates {
+		_, err := stmt.ExecContext(ctx, rate.Date, rate.Base, rate.Quote, rate.Rate)
+		if err != nil {
+			return 0, fmt.Errorf("storing %s/%s rate for %s: %w", rate.Base, rate.Quote, rate.Date.Format("2006-01-02"), err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(rates), nil
+}
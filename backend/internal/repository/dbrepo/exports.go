@@ -0,0 +1,114 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"context"
+	"time"
+)
+
+// exportTimeout bounds an export, which reads every row a household has.
+const exportTimeout = time.Minute * 5
+
+// EachIncome calls fn with each of the household's incomes, oldest first, as they are read
+// rather than all at once. It stops at the first error fn returns.
+func (m *PostgresDBRepo) EachIncome(householdID int, fn func(*models.Income) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	query := `select i.id, i.user_id, i.household_id, i.amount, i.currency, i.source_id, i.account_id, i.date,
+			coalesce(i.description, ''), coalesce(i.external_id, ''), i.created_at, i.updated_at, s.id, s.name
+			from incomes i join sources s on i.source_id = s.id
+			where i.household_id = $1 order by i.date, i.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, householdID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var income models.Income
+		var source models.Source
+		err := rows.Scan(
+			&income.ID,
+			&income.UserID,
+			&income.HouseholdID,
+			&income.Amount,
+			&income.Currency,
+			&income.SourceID,
+			&income.AccountID,
+			&income.Date,
+			&income.Description,
+			&income.ExternalID,
+			&income.CreatedAt,
+			&income.UpdatedAt,
+			&source.ID,
+			&source.Name,
+		)
+		if err != nil {
+			return err
+		}
+		source.HouseholdID = householdID
+		income.Source = &source
+
+		err = fn(&income)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// EachExpense calls fn with each of the household's expenses, oldest first, as they are read
+// rather than all at once. It stops at the first error fn returns.
+func (m *PostgresDBRepo) EachExpense(householdID int, fn func(*models.Expense) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	query := `select e.id, e.user_id, e.household_id, e.amount, e.currency, e.category_id, e.account_id, e.date,
+			coalesce(e.description, ''), coalesce(e.payment_method, ''), coalesce(e.external_id, ''),
+			e.created_at, e.updated_at, c.id, c.name
+			from expenses e join categories c on e.category_id = c.id
+			where e.household_id = $1 order by e.date, e.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, householdID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var expense models.Expense
+		var category models.Category
+		err := rows.Scan(
+			&expense.ID,
+			&expense.UserID,
+			&expense.HouseholdID,
+			&expense.Amount,
+			&expense.Currency,
+			&expense.CategoryID,
+			&expense.AccountID,
+			&expense.Date,
+			&expense.Description,
+			&expense.PaymentMethod,
+			&expense.ExternalID,
+			&expense.CreatedAt,
+			&expense.UpdatedAt,
+			&category.ID,
+			&category.Name,
+		)
+		if err != nil {
+			return err
+		}
+		category.HouseholdID = householdID
+		expense.Category = &category
+
+		err = fn(&expense)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
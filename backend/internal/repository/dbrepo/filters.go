@@ -0,0 +1,132 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"fmt"
+	"strings"
+)
+
+// sortColumns maps the sort fields of models.TransactionFilter to the column they
+// order by (with %[1]s standing for the table alias), and the type a cursor value is
+// cast back to when continuing after it.
+var sortColumns = map[string]struct{ column, cast string }{
+	"date":        {"%[1]s.date", "date"},
+	"amount":      {"%[1]s.amount", "numeric"},
+	"description": {"coalesce(%[1]s.description, '')", "text"},
+	"created_at":  {"%[1]s.created_at", "timestamptz"},
+}
+
+// transactionQuery holds the pieces of a filtered listing query for incomes or expenses.
+type transactionQuery struct {
+	where     string        // conditions for the total count
+	args      []interface{} // arguments for where
+	pageWhere string        // where, plus the keyset condition when continuing after a cursor
+	pageArgs  []interface{} // arguments for pageWhere, followed by the limit
+	limitArg  int           // placeholder number of the limit in pageArgs
+	orderBy   string        // order by clause, always ending with the id tiebreaker
+	sortKey   string        // sort column expression, selected as text to build the next cursor
+	sortField string
+	sortDesc  bool
+	limit     int
+}
+
+// buildTransactionQuery turns a filter into SQL conditions against the table aliased as
+// alias. groupColumn and groupIDs are the source or category restriction.
+func buildTransactionQuery(alias string, householdID int, filter models.TransactionFilter, groupColumn string, groupIDs []int) (*transactionQuery, error) {
+	sortField := filter.SortField
+	if sortField == "" {
+		sortField = "date"
+	}
+
+	sc, ok := sortColumns[sortField]
+	if !ok {
+		return nil, fmt.Errorf("cannot sort by %q", sortField)
+	}
+	sortKey := fmt.Sprintf(sc.column, alias)
+
+	q := &transactionQuery{
+		sortKey:   sortKey,
+		sortField: sortField,
+		sortDesc:  filter.SortDesc,
+		limit:     filter.Limit,
+	}
+	if q.limit <= 0 {
+		q.limit = models.DefaultPageSize
+	}
+	if q.limit > models.MaxPageSize {
+		q.limit = models.MaxPageSize
+	}
+
+	var conds []string
+	add := func(cond string, arg interface{}) {
+		q.args = append(q.args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(q.args)))
+	}
+
+	add(alias+".household_id = $%d", householdID)
+
+	if filter.From != nil {
+		add(alias+".date >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		add(alias+".date <= $%d", *filter.To)
+	}
+	if filter.MinAmount != nil {
+		add(alias+".amount >= $%d", *filter.MinAmount)
+	}
+	if filter.MaxAmount != nil {
+		add(alias+".amount <= $%d", *filter.MaxAmount)
+	}
+	if len(groupIDs) > 0 {
+		var placeholders []string
+		for _, id := range groupIDs {
+			q.args = append(q.args, id)
+			placeholders = append(placeholders, fmt.Sprintf("$%d", len(q.args)))
+		}
+		conds = append(conds, fmt.Sprintf("%s.%s in (%s)", alias, groupColumn, strings.Join(placeholders, ", ")))
+	}
+	if filter.PaymentMethod != "" {
+		add(alias+".payment_method = $%d", filter.PaymentMethod)
+	}
+	if filter.Description != "" {
+		add("strpos(lower(coalesce("+alias+".description, '')), lower($%d)) > 0", filter.Description)
+	}
+
+	q.where = strings.Join(conds, " and ")
+
+	dir, cmp := "asc", ">"
+	if filter.SortDesc {
+		dir, cmp = "desc", "<"
+	}
+	q.orderBy = fmt.Sprintf("%s %s, %s.id %s", sortKey, dir, alias, dir)
+
+	q.pageWhere = q.where
+	q.pageArgs = append([]interface{}{}, q.args...)
+
+	if filter.After != nil {
+		if filter.After.Sort != sortField || filter.After.Desc != filter.SortDesc {
+			return nil, fmt.Errorf("cursor does not match the requested sort")
+		}
+		n := len(q.pageArgs)
+		q.pageWhere += fmt.Sprintf(" and (%s, %s.id) %s ($%d::%s, $%d)", sortKey, alias, cmp, n+1, sc.cast, n+2)
+		q.pageArgs = append(q.pageArgs, filter.After.Value, filter.After.ID)
+	}
+
+	// fetch one extra row, so the caller can tell whether another page follows
+	q.pageArgs = append(q.pageArgs, q.limit+1)
+	q.limitArg = len(q.pageArgs)
+
+	return q, nil
+}
+
+// nextCursor returns the token for the page that follows the row with the given sort
+// key and id.
+func (q *transactionQuery) nextCursor(sortKey string, id int) string {
+	c := models.Cursor{
+		Sort:  q.sortField,
+		Desc:  q.sortDesc,
+		Value: sortKey,
+		ID:    id,
+	}
+	return c.Encode()
+}
@@ -0,0 +1,330 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"context"
+	"database/sql"
+)
+
+// goalColumns selects a goal aliased as g, with the sum of its contributions and the name
+// of its category, joined as c.
+const goalColumns = `g.id, g.user_id, g.household_id, g.name, g.target_amount, g.target_date, g.account_id, g.category_id,
+		coalesce((select sum(amount) from goal_contributions where goal_id = g.id), 0),
+		coalesce(c.name, ''), g.created_at, g.updated_at`
+
+func scanGoal(row interface {
+	Scan(dest ...interface{}) error
+}) (*models.Goal, error) {
+	var goal models.Goal
+	var categoryName string
+	err := row.Scan(
+		&goal.ID,
+		&goal.UserID,
+		&goal.HouseholdID,
+		&goal.Name,
+		&goal.TargetAmount,
+		&goal.TargetDate,
+		&goal.AccountID,
+		&goal.CategoryID,
+		&goal.Saved,
+		&categoryName,
+		&goal.CreatedAt,
+		&goal.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if goal.CategoryID != nil {
+		goal.Category = &models.Category{
+			ID:          *goal.CategoryID,
+			HouseholdID: goal.HouseholdID,
+			Name:        categoryName,
+		}
+	}
+
+	return &goal, nil
+}
+
+// AllGoals returns the household's goals with the amount saved so far, soonest target
+// first.
+func (m *PostgresDBRepo) AllGoals(householdID int) ([]*models.Goal, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + goalColumns + ` from goals g left join categories c on g.category_id = c.id
+			where g.household_id = $1 order by g.target_date, g.name`
+
+	rows, err := m.DB.QueryContext(ctx, query, householdID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	goals := []*models.Goal{}
+
+	for rows.Next() {
+		goal, err := scanGoal(rows)
+		if err != nil {
+			return nil, err
+		}
+		goals = append(goals, goal)
+	}
+
+	return goals, rows.Err()
+}
+
+// OneGoal returns one goal with the amount saved so far, if it belongs to the household.
+func (m *PostgresDBRepo) OneGoal(id, householdID int) (*models.Goal, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + goalColumns + ` from goals g left join categories c on g.category_id = c.id
+			where g.id = $1 and g.household_id = $2`
+
+	return scanGoal(m.DB.QueryRowContext(ctx, query, id, householdID))
+}
+
+// setGoalCategory looks up the goal's category by name, creating it if the household
+// doesn't have it yet. A goal without a category name is left unlinked.
+func (m *PostgresDBRepo) setGoalCategory(ctx context.Context, tx *sql.Tx, goal *models.Goal) error {
+	if goal.Category == nil || goal.Category.Name == "" {
+		goal.CategoryID = nil
+		goal.Category = nil
+		return nil
+	}
+
+	categoryID, err := m.getOrCreateCategory(ctx, tx, goal.HouseholdID, goal.Category.UserID, goal.Category)
+	if err != nil {
+		return err
+	}
+
+	goal.CategoryID = &categoryID
+
+	return nil
+}
+
+// InsertGoal inserts one goal and returns its id.
+func (m *PostgresDBRepo) InsertGoal(goal *models.Goal) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = m.setGoalCategory(ctx, tx, goal)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `insert into goals (user_id, household_id, name, target_amount, target_date, account_id, category_id,
+				created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+
+	var newID int
+
+	err = tx.QueryRowContext(ctx, stmt,
+		goal.UserID,
+		goal.HouseholdID,
+		goal.Name,
+		goal.TargetAmount,
+		goal.TargetDate,
+		goal.AccountID,
+		goal.CategoryID,
+		goal.CreatedAt,
+		goal.UpdatedAt,
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = m.logChange(ctx, tx, models.AuditInsert, recordKey(models.AuditGoal, newID), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateGoal updates one goal belonging to goal.HouseholdID. It returns sql.ErrNoRows if
+// no matching goal exists in the household.
+func (m *PostgresDBRepo) UpdateGoal(goal *models.Goal) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	key := recordKey(models.AuditGoal, goal.ID)
+	before, err := snapshot(ctx, tx, key)
+	if err != nil {
+		return err
+	}
+
+	err = m.setGoalCategory(ctx, tx, goal)
+	if err != nil {
+		return err
+	}
+
+	stmt := `update goals set name = $1, target_amount = $2, target_date = $3, account_id = $4, category_id = $5,
+			updated_at = $6 where id = $7 and household_id = $8`
+
+	res, err := tx.ExecContext(ctx, stmt,
+		goal.Name,
+		goal.TargetAmount,
+		goal.TargetDate,
+		goal.AccountID,
+		goal.CategoryID,
+		goal.UpdatedAt,
+		goal.ID,
+		goal.HouseholdID,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = expectOneRow(res)
+	if err != nil {
+		return err
+	}
+
+	err = m.logChange(ctx, tx, models.AuditUpdate, key, before)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteGoal deletes one goal, and its contributions, if it belongs to the household.
+// It returns sql.ErrNoRows if no matching goal exists in the household.
+func (m *PostgresDBRepo) DeleteGoal(id, householdID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	deps := []dependents{{
+		entity:      models.AuditGoalContribution,
+		action:      models.AuditDelete,
+		where:       "goal_id = $1",
+		args:        []interface{}{id},
+		householdID: &householdID,
+	}}
+
+	stmt := `delete from goals where id = $1 and household_id = $2`
+
+	return m.deleteAudited(ctx, recordKey(models.AuditGoal, id), deps, stmt, id, householdID)
+}
+
+// AllGoalContributions returns the contributions to one of the household's goals, newest
+// first.
+func (m *PostgresDBRepo) AllGoalContributions(goalID, householdID int) ([]*models.GoalContribution, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, goal_id, amount, date, coalesce(description, ''), created_at, updated_at
+			from goal_contributions
+			where goal_id = $1 and goal_id in (select id from goals where household_id = $2)
+			order by date desc, id desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, goalID, householdID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contributions := []*models.GoalContribution{}
+
+	for rows.Next() {
+		var contribution models.GoalContribution
+		err := rows.Scan(
+			&contribution.ID,
+			&contribution.UserID,
+			&contribution.GoalID,
+			&contribution.Amount,
+			&contribution.Date,
+			&contribution.Description,
+			&contribution.CreatedAt,
+			&contribution.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		contributions = append(contributions, &contribution)
+	}
+
+	return contributions, rows.Err()
+}
+
+// InsertGoalContribution inserts one contribution to one of the household's goals and
+// returns its id. It returns sql.ErrNoRows if the goal does not belong to the household.
+func (m *PostgresDBRepo) InsertGoalContribution(householdID int, contribution *models.GoalContribution) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	// only insert when the goal belongs to the household
+	stmt := `insert into goal_contributions (user_id, goal_id, amount, date, description, created_at, updated_at)
+			select $1, $2, $3, $4, $5, $6, $7
+			where exists (select 1 from goals where id = $2 and household_id = $8)
+			returning id`
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var newID int
+
+	err = tx.QueryRowContext(ctx, stmt,
+		contribution.UserID,
+		contribution.GoalID,
+		contribution.Amount,
+		contribution.Date,
+		contribution.Description,
+		contribution.CreatedAt,
+		contribution.UpdatedAt,
+		householdID,
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	key := recordKey(models.AuditGoalContribution, newID)
+	key.householdID = &householdID
+	err = m.logChange(ctx, tx, models.AuditInsert, key, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteGoalContribution deletes one contribution to a goal, if the goal belongs to the
+// household. It returns sql.ErrNoRows if no matching contribution exists in the household.
+func (m *PostgresDBRepo) DeleteGoalContribution(id, goalID, householdID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `delete from goal_contributions
+			where id = $1 and goal_id = $2 and goal_id in (select id from goals where household_id = $3)`
+
+	key := recordKey(models.AuditGoalContribution, id)
+	key.householdID = &householdID
+	return m.execAudited(ctx, models.AuditDelete, key, stmt, id, goalID, householdID)
+}
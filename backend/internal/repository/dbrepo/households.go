@@ -0,0 +1,561 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"database/sql"
+	"time"
+)
+
+// memberColumns selects a household member aliased as m, with the name and address of
+// the user, joined as u.
+const memberColumns = `m.household_id, m.user_id, m.role, u.first_name, u.last_name, u.email,
+		m.created_at, m.updated_at`
+
+func scanMember(row interface {
+	Scan(dest ...interface{}) error
+}) (*models.HouseholdMember, error) {
+	var member models.HouseholdMember
+	err := row.Scan(
+		&member.HouseholdID,
+		&member.UserID,
+		&member.Role,
+		&member.FirstName,
+		&member.LastName,
+		&member.Email,
+		&member.CreatedAt,
+		&member.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &member, nil
+}
+
+// insertPersonalHousehold gives a new user a household of their own, which they own, in
+// the user's base currency.
+func (m *PostgresDBRepo) insertPersonalHousehold(ctx context.Context, tx *sql.Tx, userID int, user models.User) error {
+	household := models.Household{
+		Name:         "Personal",
+		BaseCurrency: user.BaseCurrency,
+		CreatedAt:    user.CreatedAt,
+		UpdatedAt:    user.UpdatedAt,
+	}
+
+	return m.insertHousehold(ctx, tx, &household, userID)
+}
+
+// insertHousehold inserts a household, sets its id and adds ownerID as its owner.
+func (m *PostgresDBRepo) insertHousehold(ctx context.Context, tx *sql.Tx, household *models.Household, ownerID int) error {
+	stmt := `insert into households (name, base_currency, created_at, updated_at)
+			values ($1, $2, $3, $4) returning id`
+
+	err := tx.QueryRowContext(ctx, stmt, household.Name, household.BaseCurrency,
+		household.CreatedAt, household.UpdatedAt).Scan(&household.ID)
+	if err != nil {
+		return err
+	}
+
+	err = m.logChange(ctx, tx, models.AuditInsert, recordKey(models.AuditHousehold, household.ID), nil)
+	if err != nil {
+		return err
+	}
+
+	stmt = `insert into household_members (household_id, user_id, role, created_at, updated_at)
+			values ($1, $2, $3, $4, $5)`
+
+	_, err = tx.ExecContext(ctx, stmt, household.ID, ownerID, models.RoleOwner, household.CreatedAt, household.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	return m.logChange(ctx, tx, models.AuditInsert, memberKey(household.ID, ownerID), nil)
+}
+
+// memberKey names the user's membership of the household.
+func memberKey(householdID, userID int) auditKey {
+	return auditKey{
+		entity: models.AuditHouseholdMember,
+		id:     userID,
+		where:  "household_id = $1 and user_id = $2",
+		args:   []interface{}{householdID, userID},
+	}
+}
+
+// AllHouseholds returns the households the user is a member of, with the user's role in
+// each, in the order the user joined them.
+func (m *PostgresDBRepo) AllHouseholds(userID int) ([]*models.Household, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select h.id, h.name, h.base_currency, m.role, h.created_at, h.updated_at
+			from households h join household_members m on m.household_id = h.id
+			where m.user_id = $1 order by m.created_at, h.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	households := []*models.Household{}
+
+	for rows.Next() {
+		var household models.Household
+		err := rows.Scan(
+			&household.ID,
+			&household.Name,
+			&household.BaseCurrency,
+			&household.Role,
+			&household.CreatedAt,
+			&household.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		households = append(households, &household)
+	}
+
+	return households, rows.Err()
+}
+
+// OneHousehold returns one household. It returns sql.ErrNoRows if there is no such
+// household.
+func (m *PostgresDBRepo) OneHousehold(id int) (*models.Household, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, name, base_currency, created_at, updated_at from households where id = $1`
+
+	var household models.Household
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&household.ID,
+		&household.Name,
+		&household.BaseCurrency,
+		&household.CreatedAt,
+		&household.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &household, nil
+}
+
+// InsertHousehold inserts a household, with ownerID as its owner, and returns its id.
+func (m *PostgresDBRepo) InsertHousehold(household *models.Household, ownerID int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = m.insertHousehold(ctx, tx, household, ownerID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return household.ID, nil
+}
+
+// UpdateHousehold renames a household and sets its base currency. It returns
+// sql.ErrNoRows if there is no such household.
+func (m *PostgresDBRepo) UpdateHousehold(household *models.Household) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update households set name = $1, base_currency = $2, updated_at = $3 where id = $4`
+
+	return m.execAudited(ctx, models.AuditUpdate, recordKey(models.AuditHousehold, household.ID), stmt,
+		household.Name, household.BaseCurrency, household.UpdatedAt, household.ID)
+}
+
+// householdTimeout bounds deleting a household, which logs every record in it.
+const householdTimeout = time.Second * 30
+
+// DeleteHousehold deletes a household, along with everything recorded in it, each of
+// which is logged as deleted. It returns sql.ErrNoRows if there is no such household, and
+// repository.ErrLastHousehold if it is the only household of one of its members.
+func (m *PostgresDBRepo) DeleteHousehold(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), householdTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// lock the household before its members, as changeMembers does
+	key := recordKey(models.AuditHousehold, id)
+	before, err := snapshot(ctx, tx, key)
+	if err != nil {
+		return err
+	}
+
+	err = checkOtherHouseholds(ctx, tx, id, 0)
+	if err != nil {
+		return err
+	}
+
+	// everything below goes with the household, through foreign keys that cascade
+	var deps []dependents
+	for _, entity := range []string{models.AuditHouseholdMember, models.AuditHouseholdInvite, models.AuditIncome,
+		models.AuditExpense, models.AuditSource, models.AuditCategory, models.AuditBudget, models.AuditGoal,
+		models.AuditRecurring, models.AuditRecurringOccurrence} {
+		deps = append(deps, dependents{entity: entity, action: models.AuditDelete, where: "household_id = $1",
+			args: []interface{}{id}})
+	}
+	deps = append(deps, dependents{
+		entity:      models.AuditGoalContribution,
+		action:      models.AuditDelete,
+		where:       "goal_id in (select id from goals where household_id = $1)",
+		args:        []interface{}{id},
+		householdID: &id,
+	})
+
+	found, err := snapshotDependents(ctx, tx, deps...)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, `delete from households where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	err = expectOneRow(res)
+	if err != nil {
+		return err
+	}
+
+	err = m.logChange(ctx, tx, models.AuditDelete, key, before)
+	if err != nil {
+		return err
+	}
+
+	err = m.logDependents(ctx, tx, found)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// checkOtherHouseholds returns repository.ErrLastHousehold if a member of the household,
+// or only the user with userID if it is not 0, belongs to no other household. The members
+// are locked until tx ends, so nobody can leave two households at once.
+func checkOtherHouseholds(ctx context.Context, tx *sql.Tx, householdID, userID int) error {
+	query := `select id from users where id in
+			(select user_id from household_members where household_id = $1 and ($2 = 0 or user_id = $2))
+			order by id for update`
+
+	rows, err := tx.QueryContext(ctx, query, householdID, userID)
+	if err != nil {
+		return err
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	query = `select exists (select 1 from household_members m
+			where m.household_id = $1 and ($2 = 0 or m.user_id = $2) and not exists
+				(select 1 from household_members o where o.user_id = m.user_id and o.household_id <> $1))`
+
+	var stranded bool
+	err = tx.QueryRowContext(ctx, query, householdID, userID).Scan(&stranded)
+	if err != nil {
+		return err
+	}
+	if stranded {
+		return repository.ErrLastHousehold
+	}
+
+	return nil
+}
+
+// HouseholdMember returns the user's membership of the household. It returns
+// sql.ErrNoRows if the user is not a member.
+func (m *PostgresDBRepo) HouseholdMember(householdID, userID int) (*models.HouseholdMember, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + memberColumns + `
+			from household_members m join users u on m.user_id = u.id
+			where m.household_id = $1 and m.user_id = $2`
+
+	return scanMember(m.DB.QueryRowContext(ctx, query, householdID, userID))
+}
+
+// DefaultHouseholdMember returns the user's membership of the household they joined
+// first, which is used when a request doesn't choose one. It returns sql.ErrNoRows if
+// the user is not a member of any household.
+func (m *PostgresDBRepo) DefaultHouseholdMember(userID int) (*models.HouseholdMember, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + memberColumns + `
+			from household_members m join users u on m.user_id = u.id
+			where m.user_id = $1 order by m.created_at, m.household_id limit 1`
+
+	return scanMember(m.DB.QueryRowContext(ctx, query, userID))
+}
+
+// AllHouseholdMembers returns the members of the household, in the order they joined.
+func (m *PostgresDBRepo) AllHouseholdMembers(householdID int) ([]*models.HouseholdMember, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + memberColumns + `
+			from household_members m join users u on m.user_id = u.id
+			where m.household_id = $1 order by m.created_at, m.user_id`
+
+	rows, err := m.DB.QueryContext(ctx, query, householdID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []*models.HouseholdMember{}
+
+	for rows.Next() {
+		member, err := scanMember(rows)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+
+	return members, rows.Err()
+}
+
+// UpdateHouseholdMember changes the role of one member. It returns sql.ErrNoRows if the
+// user is not a member, and repository.ErrLastOwner if the household would be left
+// without an owner.
+func (m *PostgresDBRepo) UpdateHouseholdMember(member *models.HouseholdMember) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return m.changeMembers(ctx, models.AuditUpdate, member.HouseholdID, member.UserID, func(tx *sql.Tx) (sql.Result, error) {
+		stmt := `update household_members set role = $1, updated_at = $2 where household_id = $3 and user_id = $4`
+		return tx.ExecContext(ctx, stmt, member.Role, member.UpdatedAt, member.HouseholdID, member.UserID)
+	})
+}
+
+// DeleteHouseholdMember removes the user from the household. What they recorded there
+// stays in the household. It returns sql.ErrNoRows if the user is not a member,
+// repository.ErrLastOwner if they are its last owner, and repository.ErrLastHousehold if
+// it is their only household.
+func (m *PostgresDBRepo) DeleteHouseholdMember(householdID, userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return m.changeMembers(ctx, models.AuditDelete, householdID, userID, func(tx *sql.Tx) (sql.Result, error) {
+		err := checkOtherHouseholds(ctx, tx, householdID, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		stmt := `delete from household_members where household_id = $1 and user_id = $2`
+		return tx.ExecContext(ctx, stmt, householdID, userID)
+	})
+}
+
+// changeMembers runs change, which must make action to the user's membership of the
+// household, and commits it only if the household still has an owner afterwards. The
+// household is locked meanwhile, so two owners can't step down at once.
+func (m *PostgresDBRepo) changeMembers(ctx context.Context, action string, householdID, userID int,
+	change func(tx *sql.Tx) (sql.Result, error)) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRowContext(ctx, `select id from households where id = $1 for update`, householdID).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	key := memberKey(householdID, userID)
+	before, err := snapshot(ctx, tx, key)
+	if err != nil {
+		return err
+	}
+
+	res, err := change(tx)
+	if err != nil {
+		return err
+	}
+
+	err = expectOneRow(res)
+	if err != nil {
+		return err
+	}
+
+	err = m.logChange(ctx, tx, action, key, before)
+	if err != nil {
+		return err
+	}
+
+	var owners int
+	query := `select count(*) from household_members where household_id = $1 and role = $2`
+	err = tx.QueryRowContext(ctx, query, householdID, models.RoleOwner).Scan(&owners)
+	if err != nil {
+		return err
+	}
+	if owners == 0 {
+		return repository.ErrLastOwner
+	}
+
+	return tx.Commit()
+}
+
+// AllHouseholdInvites returns the household's invites that are still unused and unexpired
+// at now, the newest first.
+func (m *PostgresDBRepo) AllHouseholdInvites(householdID int, now time.Time) ([]*models.HouseholdInvite, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, household_id, role, created_by, expires_at, created_at
+			from household_invites where household_id = $1 and expires_at > $2
+			order by created_at desc, id desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, householdID, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []*models.HouseholdInvite{}
+
+	for rows.Next() {
+		var invite models.HouseholdInvite
+		err := rows.Scan(
+			&invite.ID,
+			&invite.HouseholdID,
+			&invite.Role,
+			&invite.CreatedBy,
+			&invite.ExpiresAt,
+			&invite.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, &invite)
+	}
+
+	return invites, rows.Err()
+}
+
+// InsertHouseholdInvite stores a new invite, and sets its id.
+func (m *PostgresDBRepo) InsertHouseholdInvite(invite *models.HouseholdInvite) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into household_invites (household_id, token_hash, role, created_by, expires_at, created_at)
+			values ($1, $2, $3, $4, $5, $6) returning id`
+
+	id, err := m.insertAudited(ctx, models.AuditHouseholdInvite, stmt, invite.HouseholdID, invite.TokenHash, invite.Role,
+		invite.CreatedBy, invite.ExpiresAt, invite.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	invite.ID = id
+
+	return nil
+}
+
+// DeleteHouseholdInvite revokes one of the household's invites. It returns sql.ErrNoRows
+// if the household has no such invite.
+func (m *PostgresDBRepo) DeleteHouseholdInvite(householdID, id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return m.execAudited(ctx, models.AuditDelete, recordKey(models.AuditHouseholdInvite, id),
+		`delete from household_invites where id = $1 and household_id = $2`, id, householdID)
+}
+
+// AcceptHouseholdInvite adds the user to the household of the unexpired invite with the
+// given hash, with the invite's role, and uses the invite up. It returns sql.ErrNoRows if
+// there is no such invite, and repository.ErrDuplicate if the user is already a member,
+// in which case the invite is kept.
+func (m *PostgresDBRepo) AcceptHouseholdInvite(tokenHash string, userID int, now time.Time) (*models.HouseholdMember, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	member := models.HouseholdMember{UserID: userID, CreatedAt: now, UpdatedAt: now}
+
+	query := `delete from household_invites i where token_hash = $1 and expires_at > $2
+			returning id, household_id, role, to_jsonb(i) - 'token_hash'`
+
+	var inviteID int
+	var invite []byte
+	err = tx.QueryRowContext(ctx, query, tokenHash, now).Scan(&inviteID, &member.HouseholdID, &member.Role, &invite)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.logChange(ctx, tx, models.AuditDelete, recordKey(models.AuditHouseholdInvite, inviteID), invite)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := `insert into household_members (household_id, user_id, role, created_at, updated_at)
+			values ($1, $2, $3, $4, $5)`
+
+	_, err = tx.ExecContext(ctx, stmt, member.HouseholdID, member.UserID, member.Role, member.CreatedAt, member.UpdatedAt)
+	if err != nil {
+		return nil, duplicate(err)
+	}
+
+	err = m.logChange(ctx, tx, models.AuditInsert, memberKey(member.HouseholdID, member.UserID), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &member, nil
+}
+
+// DeleteExpiredHouseholdInvites removes the invites that expired before now, and returns
+// how many it removed.
+func (m *PostgresDBRepo) DeleteExpiredHouseholdInvites(now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, `delete from household_invites where expires_at < $1`, now)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), nil
+}
@@ -0,0 +1,95 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"context"
+	"time"
+)
+
+// UseUserIdentity returns the id of the user linked to the subject at provider, and
+// records that they signed in with it at now. It returns sql.ErrNoRows if no user is.
+func (m *PostgresDBRepo) UseUserIdentity(provider, subject string, now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update user_identities set last_login_at = $1 where provider = $2 and subject = $3 returning user_id`
+
+	var userID int
+
+	err := m.DB.QueryRowContext(ctx, stmt, now, provider, subject).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// InsertUserIdentity links an existing user to an identity, and sets its id. It returns
+// repository.ErrDuplicate if the identity is already linked.
+func (m *PostgresDBRepo) InsertUserIdentity(identity *models.UserIdentity) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return duplicate(insertUserIdentity(ctx, m.DB, identity))
+}
+
+// InsertUserWithIdentity creates a user who signed up through a provider, linked to their
+// identity there, with a household of their own, and returns the new user's id. It
+// returns repository.ErrDuplicate if the address or the identity is taken.
+func (m *PostgresDBRepo) InsertUserWithIdentity(user models.User, identity *models.UserIdentity) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `insert into users (first_name, last_name, email, password, base_currency, email_verified_at,
+			email_verification_required, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, true, $7, $8) returning id`
+
+	var newID int
+
+	err = tx.QueryRowContext(ctx, stmt,
+		user.FirstName,
+		user.LastName,
+		user.Email,
+		user.Password,
+		user.BaseCurrency,
+		user.EmailVerifiedAt,
+		user.CreatedAt,
+		user.UpdatedAt,
+	).Scan(&newID)
+	if err != nil {
+		return 0, duplicate(err)
+	}
+
+	identity.UserID = newID
+	err = insertUserIdentity(ctx, tx, identity)
+	if err != nil {
+		return 0, duplicate(err)
+	}
+
+	err = m.insertPersonalHousehold(ctx, tx, newID, user)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// insertUserIdentity inserts identity, and sets its id.
+func insertUserIdentity(ctx context.Context, db queryRower, identity *models.UserIdentity) error {
+	stmt := `insert into user_identities (user_id, provider, subject, email, created_at, last_login_at)
+			values ($1, $2, $3, $4, $5, $6) returning id`
+
+	return db.QueryRowContext(ctx, stmt, identity.UserID, identity.Provider, identity.Subject, identity.Email,
+		identity.CreatedAt, identity.LastLoginAt).Scan(&identity.ID)
+}
@@ -0,0 +1,305 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"context"
+	"time"
+)
+
+// importTimeout bounds an import, which may insert many rows in one transaction.
+const importTimeout = time.Second * 30
+
+const importMappingColumns = `id, user_id, name, has_header, delimiter, date_column, date_format, amount_sign,
+		amount_column, debit_column, credit_column, description_column, category_column, source_column,
+		default_category, default_source, currency, account_id, created_at, updated_at`
+
+func scanImportMapping(row interface {
+	Scan(dest ...interface{}) error
+}) (*models.ImportMapping, error) {
+	var mapping models.ImportMapping
+	err := row.Scan(
+		&mapping.ID,
+		&mapping.UserID,
+		&mapping.Name,
+		&mapping.HasHeader,
+		&mapping.Delimiter,
+		&mapping.DateColumn,
+		&mapping.DateFormat,
+		&mapping.AmountSign,
+		&mapping.AmountColumn,
+		&mapping.DebitColumn,
+		&mapping.CreditColumn,
+		&mapping.DescriptionColumn,
+		&mapping.CategoryColumn,
+		&mapping.SourceColumn,
+		&mapping.DefaultCategory,
+		&mapping.DefaultSource,
+		&mapping.Currency,
+		&mapping.AccountID,
+		&mapping.CreatedAt,
+		&mapping.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mapping, nil
+}
+
+// AllImportMappings returns the user's saved CSV import mappings, sorted by name.
+func (m *PostgresDBRepo) AllImportMappings(userID int) ([]*models.ImportMapping, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + importMappingColumns + ` from import_mappings where user_id = $1 order by name`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mappings := []*models.ImportMapping{}
+
+	for rows.Next() {
+		mapping, err := scanImportMapping(rows)
+		if err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, mapping)
+	}
+
+	return mappings, rows.Err()
+}
+
+// OneImportMapping returns one CSV import mapping, if it belongs to the user.
+func (m *PostgresDBRepo) OneImportMapping(id, userID int) (*models.ImportMapping, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + importMappingColumns + ` from import_mappings where id = $1 and user_id = $2`
+
+	return scanImportMapping(m.DB.QueryRowContext(ctx, query, id, userID))
+}
+
+// InsertImportMapping inserts one CSV import mapping and returns its id.
+func (m *PostgresDBRepo) InsertImportMapping(mapping *models.ImportMapping) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into import_mappings (user_id, name, has_header, delimiter, date_column, date_format, amount_sign,
+				amount_column, debit_column, credit_column, description_column, category_column, source_column,
+				default_category, default_source, currency, account_id, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)
+			returning id`
+
+	newID, err := m.insertAudited(ctx, models.AuditImportMapping, stmt,
+		mapping.UserID,
+		mapping.Name,
+		mapping.HasHeader,
+		mapping.Delimiter,
+		mapping.DateColumn,
+		mapping.DateFormat,
+		mapping.AmountSign,
+		mapping.AmountColumn,
+		mapping.DebitColumn,
+		mapping.CreditColumn,
+		mapping.DescriptionColumn,
+		mapping.CategoryColumn,
+		mapping.SourceColumn,
+		mapping.DefaultCategory,
+		mapping.DefaultSource,
+		mapping.Currency,
+		mapping.AccountID,
+		mapping.CreatedAt,
+		mapping.UpdatedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateImportMapping updates one CSV import mapping belonging to mapping.UserID. It
+// returns sql.ErrNoRows if no matching mapping exists for the user.
+func (m *PostgresDBRepo) UpdateImportMapping(mapping *models.ImportMapping) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update import_mappings set name = $1, has_header = $2, delimiter = $3, date_column = $4,
+				date_format = $5, amount_sign = $6, amount_column = $7, debit_column = $8, credit_column = $9,
+				description_column = $10, category_column = $11, source_column = $12, default_category = $13,
+				default_source = $14, currency = $15, account_id = $16, updated_at = $17
+			where id = $18 and user_id = $19`
+
+	return m.execAudited(ctx, models.AuditUpdate, recordKey(models.AuditImportMapping, mapping.ID), stmt,
+		mapping.Name,
+		mapping.HasHeader,
+		mapping.Delimiter,
+		mapping.DateColumn,
+		mapping.DateFormat,
+		mapping.AmountSign,
+		mapping.AmountColumn,
+		mapping.DebitColumn,
+		mapping.CreditColumn,
+		mapping.DescriptionColumn,
+		mapping.CategoryColumn,
+		mapping.SourceColumn,
+		mapping.DefaultCategory,
+		mapping.DefaultSource,
+		mapping.Currency,
+		mapping.AccountID,
+		mapping.UpdatedAt,
+		mapping.ID,
+		mapping.UserID,
+	)
+}
+
+// DeleteImportMapping deletes one CSV import mapping, if it belongs to the user. It
+// returns sql.ErrNoRows if no matching mapping exists for the user.
+func (m *PostgresDBRepo) DeleteImportMapping(id, userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `delete from import_mappings where id = $1 and user_id = $2`
+
+	return m.execAudited(ctx, models.AuditDelete, recordKey(models.AuditImportMapping, id), stmt, id, userID)
+}
+
+// ImportTransactions inserts a batch of incomes and expenses, recorded by userID, into
+// the household in a single transaction, creating sources and categories as needed, and
+// returns how many rows were inserted. Rows with an external id the household already
+// has are skipped. If any row fails, nothing is inserted.
+func (m *PostgresDBRepo) ImportTransactions(householdID, userID int, incomes []*models.Income, expenses []*models.Expense) (int, error) {
+	return m.ImportJournal(householdID, userID, nil, nil, incomes, expenses)
+}
+
+// ImportJournal is ImportTransactions for a journal, which may also declare sources and
+// categories no row uses; those are created in the same transaction.
+func (m *PostgresDBRepo) ImportJournal(householdID, userID int, sources []*models.Source, categories []*models.Category,
+	incomes []*models.Income, expenses []*models.Expense) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), importTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, source := range sources {
+		_, err = m.getOrCreateSource(ctx, tx, householdID, userID, source)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	for _, category := range categories {
+		_, err = m.getOrCreateCategory(ctx, tx, householdID, userID, category)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	incomeStmt, err := tx.PrepareContext(ctx, insertIncomeQuery)
+	if err != nil {
+		return 0, err
+	}
+	defer incomeStmt.Close()
+
+	expenseStmt, err := tx.PrepareContext(ctx, insertExpenseQuery)
+	if err != nil {
+		return 0, err
+	}
+	defer expenseStmt.Close()
+
+	// look each name up once, however many rows use it
+	inserted := 0
+	sourceIDs := map[string]int{}
+	categoryIDs := map[string]int{}
+
+	for _, income := range incomes {
+		sourceID, ok := sourceIDs[income.Source.Name]
+		if !ok {
+			sourceID, err = m.getOrCreateSource(ctx, tx, householdID, userID, income.Source)
+			if err != nil {
+				return 0, err
+			}
+			sourceIDs[income.Source.Name] = sourceID
+		}
+		income.SourceID = sourceID
+
+		row := incomeStmt.QueryRowContext(ctx, userID, income.Amount, income.Currency, income.SourceID,
+			income.AccountID, income.Date, income.Description, income.ExternalID, income.CreatedAt, income.UpdatedAt, householdID)
+
+		err = m.insertIncome(ctx, tx, row, income)
+		if err != nil {
+			return 0, err
+		}
+		if income.ID != 0 {
+			inserted++
+		}
+	}
+
+	for _, expense := range expenses {
+		categoryID, ok := categoryIDs[expense.Category.Name]
+		if !ok {
+			categoryID, err = m.getOrCreateCategory(ctx, tx, householdID, userID, expense.Category)
+			if err != nil {
+				return 0, err
+			}
+			categoryIDs[expense.Category.Name] = categoryID
+		}
+		expense.CategoryID = categoryID
+
+		row := expenseStmt.QueryRowContext(ctx, userID, expense.Amount, expense.Currency, expense.CategoryID,
+			expense.AccountID, expense.Date, expense.Description, expense.PaymentMethod, expense.ExternalID,
+			expense.CreatedAt, expense.UpdatedAt, householdID)
+
+		err = m.insertExpense(ctx, tx, row, expense)
+		if err != nil {
+			return 0, err
+		}
+		if expense.ID != 0 {
+			inserted++
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return inserted, nil
+}
+
+// ImportedExternalIDs returns which of the given external ids the household already has
+// on an income or expense.
+func (m *PostgresDBRepo) ImportedExternalIDs(householdID int, ids []string) (map[string]bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select external_id from incomes where household_id = $1 and external_id = any($2)
+			union
+			select external_id from expenses where household_id = $1 and external_id = any($2)`
+
+	rows, err := m.DB.QueryContext(ctx, query, householdID, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	imported := map[string]bool{}
+
+	for rows.Next() {
+		var id string
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		imported[id] = true
+	}
+
+	return imported, rows.Err()
+}
@@ -0,0 +1,112 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"backend/internal/ratelimit"
+	"context"
+	"database/sql"
+	"time"
+)
+
+// InsertLoginAttempt records an attempt to sign in.
+func (m *PostgresDBRepo) InsertLoginAttempt(attempt *models.LoginAttempt) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into login_attempts (user_id, email, ip_address, user_agent, success, reason, created_at)
+			values ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err := m.DB.ExecContext(ctx, stmt, attempt.UserID, attempt.Email, attempt.IPAddress, attempt.UserAgent,
+		attempt.Success, attempt.Reason, attempt.CreatedAt)
+	return err
+}
+
+// PostgresLimitStore is a ratelimit.Store kept in the login_limits table, so every server
+// sharing the database sees the same failures.
+type PostgresLimitStore struct {
+	DB *sql.DB
+}
+
+var _ ratelimit.Store = (*PostgresLimitStore)(nil)
+
+// Reserve records a try of key, if wait allows it. The key's row is locked while wait
+// decides, so concurrent tries are decided one at a time.
+func (s *PostgresLimitStore) Reserve(key string, now time.Time, window time.Duration, wait func(ratelimit.Entry) time.Duration) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// make sure there is a row to lock
+	_, err = tx.ExecContext(ctx, `insert into login_limits (key, failures, last_failure) values ($1, 0, $2)
+			on conflict (key) do nothing`, key, now)
+	if err != nil {
+		return 0, err
+	}
+
+	var e ratelimit.Entry
+
+	err = tx.QueryRowContext(ctx, `select failures, last_failure from login_limits where key = $1 for update`, key).Scan(
+		&e.Failures,
+		&e.Last,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	if now.Sub(e.Last) > window {
+		e = ratelimit.Entry{}
+	}
+
+	if d := wait(e); d > 0 {
+		return d, nil
+	}
+
+	_, err = tx.ExecContext(ctx, `update login_limits set failures = $2, last_failure = $3 where key = $1`,
+		key, e.Failures+1, now)
+	if err != nil {
+		return 0, err
+	}
+
+	return 0, tx.Commit()
+}
+
+// Release takes back one failure of key.
+func (s *PostgresLimitStore) Release(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, `update login_limits set failures = failures - 1 where key = $1 and failures > 0`, key)
+	return err
+}
+
+// Reset forgets key.
+func (s *PostgresLimitStore) Reset(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, `delete from login_limits where key = $1`, key)
+	return err
+}
+
+// Prune forgets the keys that last failed before before.
+func (s *PostgresLimitStore) Prune(before time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	res, err := s.DB.ExecContext(ctx, `delete from login_limits where last_failure < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), nil
+}
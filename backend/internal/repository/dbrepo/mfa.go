@@ -0,0 +1,142 @@
+package dbrepo
+
+import (
+	"context"
+	"time"
+)
+
+// SetTOTPSecret starts enrolling the user in two-factor authentication with a new secret,
+// replacing any enrollment that was never confirmed. It returns sql.ErrNoRows if the user
+// does not exist or already has two-factor authentication on.
+func (m *PostgresDBRepo) SetTOTPSecret(userID int, secret string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update users set totp_secret = $1, totp_last_step = null
+			where id = $2 and totp_enabled_at is null`
+
+	res, err := m.DB.ExecContext(ctx, stmt, secret, userID)
+	if err != nil {
+		return err
+	}
+
+	return expectOneRow(res)
+}
+
+// EnableTOTP turns on two-factor authentication for the user, whose code for step has just
+// been checked against the secret of their enrollment, and replaces their recovery codes
+// with the given hashes. It returns sql.ErrNoRows if the user has no enrollment to confirm.
+func (m *PostgresDBRepo) EnableTOTP(userID int, step int64, codeHashes []string, now time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `update users set totp_enabled_at = $1, totp_last_step = $2, updated_at = $1
+			where id = $3 and totp_secret is not null and totp_enabled_at is null`, now, step, userID)
+	if err != nil {
+		return err
+	}
+	err = expectOneRow(res)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from recovery_codes where user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range codeHashes {
+		_, err = tx.ExecContext(ctx, `insert into recovery_codes (user_id, code_hash, created_at) values ($1, $2, $3)`,
+			userID, hash, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep records that the user signed in with their code for step. Each code works
+// once: it returns sql.ErrNoRows if a code of that step, or a later one, was already used.
+func (m *PostgresDBRepo) UseTOTPStep(userID int, step int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update users set totp_last_step = $1
+			where id = $2 and totp_enabled_at is not null and (totp_last_step is null or totp_last_step < $1)`
+
+	res, err := m.DB.ExecContext(ctx, stmt, step, userID)
+	if err != nil {
+		return err
+	}
+
+	return expectOneRow(res)
+}
+
+// UseRecoveryCode uses up the user's recovery code with the given hash. It returns
+// sql.ErrNoRows if the user has no such code, or it was already used.
+func (m *PostgresDBRepo) UseRecoveryCode(userID int, codeHash string, now time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update recovery_codes set used_at = $1 where user_id = $2 and code_hash = $3 and used_at is null`
+
+	res, err := m.DB.ExecContext(ctx, stmt, now, userID, codeHash)
+	if err != nil {
+		return err
+	}
+
+	return expectOneRow(res)
+}
+
+// CountRecoveryCodes returns how many unused recovery codes the user has left.
+func (m *PostgresDBRepo) CountRecoveryCodes(userID int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var n int
+
+	err := m.DB.QueryRowContext(ctx, `select count(*) from recovery_codes where user_id = $1 and used_at is null`,
+		userID).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// DisableTOTP turns two-factor authentication off for the user, forgetting their secret
+// and recovery codes.
+func (m *PostgresDBRepo) DisableTOTP(userID int, now time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `update users set totp_secret = null, totp_enabled_at = null, totp_last_step = null,
+			updated_at = $1 where id = $2`, now, userID)
+	if err != nil {
+		return err
+	}
+	err = expectOneRow(res)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from recovery_codes where user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
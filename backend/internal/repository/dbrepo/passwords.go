@@ -0,0 +1,116 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"context"
+	"time"
+)
+
+// InsertPasswordReset stores a password reset request.
+func (m *PostgresDBRepo) InsertPasswordReset(reset *models.PasswordReset) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into password_resets (user_id, token_hash, expires_at, created_at) values ($1, $2, $3, $4)`
+
+	_, err := m.DB.ExecContext(ctx, stmt, reset.UserID, reset.TokenHash, reset.ExpiresAt, reset.CreatedAt)
+	return err
+}
+
+// PasswordResetUser returns the id of the user of the unexpired, unused reset request
+// with the given token hash, or sql.ErrNoRows if no such request exists. It only looks;
+// ResetPassword is what uses the request up.
+func (m *PostgresDBRepo) PasswordResetUser(tokenHash string, now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var userID int
+
+	err := m.DB.QueryRowContext(ctx, `select user_id from password_resets
+			where token_hash = $1 and used_at is null and expires_at > $2`, tokenHash, now).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// ResetPassword uses the unexpired, unused reset request with the given token hash to
+// set its user's password hash, and returns the user's id. Every other outstanding
+// request of the user is used up with it, every session of the user is revoked and their
+// personal access tokens are deleted, so whoever knew the old password loses the access
+// it gave. It returns sql.ErrNoRows if no such request exists.
+func (m *PostgresDBRepo) ResetPassword(tokenHash, passwordHash string, now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+
+	// claiming the request and changing the password commit together, so a token can
+	// never be used twice
+	err = tx.QueryRowContext(ctx, `update password_resets set used_at = $1
+			where token_hash = $2 and used_at is null and expires_at > $1
+			returning user_id`, now, tokenHash).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, `update users set password = $1, updated_at = $2 where id = $3`,
+		passwordHash, now, userID)
+	if err != nil {
+		return 0, err
+	}
+	err = expectOneRow(res)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `update password_resets set used_at = $1 where user_id = $2 and used_at is null`,
+		now, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `update sessions set revoked_at = $1 where user_id = $2 and revoked_at is null`,
+		now, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from access_tokens where user_id = $1`, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// DeleteExpiredPasswordResets removes the reset requests that expired before now, and
+// returns how many it removed.
+func (m *PostgresDBRepo) DeleteExpiredPasswordResets(now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, `delete from password_resets where expires_at < $1`, now)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), nil
+}
@@ -6,6 +6,7 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"sort"
 	"time"
 )
 
@@ -14,7 +15,8 @@ import (
 // entirely, as long as the thing being swapped implements all of the functions in the type
 // repository.DatabaseRepo.
 type PostgresDBRepo struct {
-	DB *sql.DB
+	DB    *sql.DB
+	actor *models.AuditActor // Who writes are recorded as in the audit log; nil for the system
 }
 
 const dbTimeout = time.Second * 3
@@ -29,8 +31,10 @@ func (m *PostgresDBRepo) GetUserByEmail(email string) (*models.User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password,
-			created_at, updated_at from users where email = $1`
+	query := `select id, email, first_name, last_name, password, base_currency,
+			email_verified_at, email_verification_required, coalesce(totp_secret, ''), totp_enabled_at,
+			created_at, updated_at
+			from users where email = $1`
 
 	var user models.User
 	row := m.DB.QueryRowContext(ctx, query, email)
@@ -41,6 +45,11 @@ func (m *PostgresDBRepo) GetUserByEmail(email string) (*models.User, error) {
 		&user.FirstName,
 		&user.LastName,
 		&user.Password,
+		&user.BaseCurrency,
+		&user.EmailVerifiedAt,
+		&user.EmailVerificationRequired,
+		&user.TOTPSecret,
+		&user.TOTPEnabledAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -57,8 +66,10 @@ func (m *PostgresDBRepo) GetUserByID(id int) (*models.User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password,
-			created_at, updated_at from users where id = $1`
+	query := `select id, email, first_name, last_name, password, base_currency,
+			email_verified_at, email_verification_required, coalesce(totp_secret, ''), totp_enabled_at,
+			created_at, updated_at
+			from users where id = $1`
 
 	var user models.User
 	row := m.DB.QueryRowContext(ctx, query, id)
@@ -69,6 +80,11 @@ func (m *PostgresDBRepo) GetUserByID(id int) (*models.User, error) {
 		&user.FirstName,
 		&user.LastName,
 		&user.Password,
+		&user.BaseCurrency,
+		&user.EmailVerifiedAt,
+		&user.EmailVerificationRequired,
+		&user.TOTPSecret,
+		&user.TOTPEnabledAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -80,20 +96,29 @@ func (m *PostgresDBRepo) GetUserByID(id int) (*models.User, error) {
 	return &user, nil
 }
 
+// InsertUser inserts a user, with a household of their own, and returns the new user's id.
 func (m *PostgresDBRepo) InsertUser(user models.User) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	stmt := `insert into users (first_name, last_name, email, password, created_at, updated_at)
-			values ($1, $2, $3, $4, $5, $6) returning id`
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `insert into users (first_name, last_name, email, password, base_currency,
+			email_verification_required, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, true, $6, $7) returning id`
 	
 	var newID int
 
-	err := m.DB.QueryRowContext(ctx, stmt,
+	err = tx.QueryRowContext(ctx, stmt,
 		user.FirstName,
 		user.LastName,
 		user.Email,
 		user.Password,
+		user.BaseCurrency,
 		user.CreatedAt,
 		user.UpdatedAt,
 	).Scan(&newID)
@@ -103,190 +128,691 @@ func (m *PostgresDBRepo) InsertUser(user models.User) (int, error) {
 		return 0, err
 	}
 
+	err = m.insertPersonalHousehold(ctx, tx, newID, user)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
 	return newID, nil
 }
 
-func (m *PostgresDBRepo) AllIncomes(id int) ([]*models.Income, error) {
+// UpdateUserBaseCurrency sets the user's base currency, which the households they create
+// start with. Households the user is the only member of follow it, so their summaries
+// are converted into it too.
+func (m *PostgresDBRepo) UpdateUserBaseCurrency(userID int, currency string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, user_id, amount, source_id, date, description, created_at, updated_at from incomes where user_id = $1 order by date desc`
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	rows, err := m.DB.QueryContext(ctx, query, id)
+	now := time.Now()
+
+	stmt := `update users set base_currency = $1, updated_at = $2 where id = $3`
+
+	res, err := tx.ExecContext(ctx, stmt, currency, now, userID)
+	if err != nil {
+		return err
+	}
+
+	err = expectOneRow(res)
+	if err != nil {
+		return err
+	}
+
+	query := `select household_id from household_members
+			group by household_id having count(*) = 1 and bool_and(user_id = $1)`
+
+	rows, err := tx.QueryContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	var householdIDs []int
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		householdIDs = append(householdIDs, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	// update the households one at a time, so each change is logged
+	for _, id := range householdIDs {
+		key := recordKey(models.AuditHousehold, id)
+		before, err := snapshot(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+
+		stmt = `update households set base_currency = $1, updated_at = $2 where id = $3`
+
+		_, err = tx.ExecContext(ctx, stmt, currency, now, id)
+		if err != nil {
+			return err
+		}
+
+		err = m.logChange(ctx, tx, models.AuditUpdate, key, before)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// VerifyUserEmail records that the user confirmed the given address, unless they have
+// since changed it. Verifying twice keeps the first time. It returns sql.ErrNoRows if
+// the user does not exist or no longer has that address.
+func (m *PostgresDBRepo) VerifyUserEmail(id int, email string, at time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update users set email_verified_at = coalesce(email_verified_at, $1)
+			where id = $2 and email = $3`
+
+	res, err := m.DB.ExecContext(ctx, stmt, at, id, email)
+	if err != nil {
+		return err
+	}
+
+	return expectOneRow(res)
+}
+
+// AllIncomes returns one page of the household's incomes, with their sources, narrowed and
+// ordered by filter, along with the total number of matching incomes.
+func (m *PostgresDBRepo) AllIncomes(householdID int, filter models.TransactionFilter) (*models.IncomePage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	// incomes have no payment method to filter by
+	filter.PaymentMethod = ""
+
+	q, err := buildTransactionQuery("i", householdID, filter, "source_id", filter.SourceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	page := models.IncomePage{Incomes: []*models.Income{}}
+
+	countQuery := fmt.Sprintf(`select count(*) from incomes i where %s`, q.where)
+	err = m.DB.QueryRowContext(ctx, countQuery, q.args...).Scan(&page.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`select i.id, i.user_id, i.household_id, i.amount, i.currency, i.source_id, i.account_id, i.date, i.description, i.created_at, i.updated_at,
+			s.id, s.name, s.created_at, s.updated_at, (%s)::text
+			from incomes i join sources s on i.source_id = s.id
+			where %s order by %s limit $%d`, q.sortKey, q.pageWhere, q.orderBy, q.limitArg)
+
+	rows, err := m.DB.QueryContext(ctx, query, q.pageArgs...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var incomes []*models.Income
+	var lastKey string
 
 	for rows.Next() {
 		var income models.Income
+		var source models.Source
+		var sortKey string
 		err := rows.Scan(
 			&income.ID,
 			&income.UserID,
+			&income.HouseholdID,
 			&income.Amount,
+			&income.Currency,
 			&income.SourceID,
+			&income.AccountID,
 			&income.Date,
 			&income.Description,
 			&income.CreatedAt,
 			&income.UpdatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
-
-		// Fetch the corresponding source record for this income
-		sourceQuery := `select id, name, created_at, updated_at from sources where id = $1`
-		var source models.Source
-		err = m.DB.QueryRowContext(ctx, sourceQuery, income.SourceID).Scan(
 			&source.ID,
 			&source.Name,
 			&source.CreatedAt,
 			&source.UpdatedAt,
+			&sortKey,
 		)
 		if err != nil {
 			return nil, err
 		}
 
-		// Set the Source property of the income
+		// the extra row only tells us there is another page
+		if len(page.Incomes) == q.limit {
+			last := page.Incomes[len(page.Incomes)-1]
+			page.NextCursor = q.nextCursor(lastKey, last.ID)
+			break
+		}
+
 		income.Source = &source
+		page.Incomes = append(page.Incomes, &income)
+		lastKey = sortKey
+	}
 
-		incomes = append(incomes, &income)
+	if err = rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return incomes, nil
+	return &page, nil
 }
 
-func (m *PostgresDBRepo) AllExpenses(id int) ([]*models.Expense, error) {
+// AllExpenses returns one page of the household's expenses, with their categories, narrowed
+// and ordered by filter, along with the total number of matching expenses.
+func (m *PostgresDBRepo) AllExpenses(householdID int, filter models.TransactionFilter) (*models.ExpensePage, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, user_id, amount, category_id, date, description, payment_method, created_at, updated_at from expenses where user_id = $1 order by date desc`
+	q, err := buildTransactionQuery("e", householdID, filter, "category_id", filter.CategoryIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	page := models.ExpensePage{Expenses: []*models.Expense{}}
 
-	rows, err := m.DB.QueryContext(ctx, query, id)
+	countQuery := fmt.Sprintf(`select count(*) from expenses e where %s`, q.where)
+	err = m.DB.QueryRowContext(ctx, countQuery, q.args...).Scan(&page.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`select e.id, e.user_id, e.household_id, e.amount, e.currency, e.category_id, e.account_id, e.date, e.description, e.payment_method,
+			e.created_at, e.updated_at, c.id, c.name, c.created_at, c.updated_at, (%s)::text
+			from expenses e join categories c on e.category_id = c.id
+			where %s order by %s limit $%d`, q.sortKey, q.pageWhere, q.orderBy, q.limitArg)
+
+	rows, err := m.DB.QueryContext(ctx, query, q.pageArgs...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var expenses []*models.Expense
+	var lastKey string
 
 	for rows.Next() {
 		var expense models.Expense
+		var category models.Category
+		var sortKey string
 		err := rows.Scan(
 			&expense.ID,
 			&expense.UserID,
+			&expense.HouseholdID,
 			&expense.Amount,
+			&expense.Currency,
 			&expense.CategoryID,
+			&expense.AccountID,
 			&expense.Date,
 			&expense.Description,
 			&expense.PaymentMethod,
 			&expense.CreatedAt,
 			&expense.UpdatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
-
-		// Fetch the corresponding category record for this expense
-		categoryQuery := `select id, name, created_at, updated_at from categories where id = $1`
-		var category models.Category
-		err = m.DB.QueryRowContext(ctx, categoryQuery, expense.CategoryID).Scan(
 			&category.ID,
 			&category.Name,
 			&category.CreatedAt,
 			&category.UpdatedAt,
+			&sortKey,
 		)
 		if err != nil {
 			return nil, err
 		}
 
-		// Set the Category property of the expense
+		// the extra row only tells us there is another page
+		if len(page.Expenses) == q.limit {
+			last := page.Expenses[len(page.Expenses)-1]
+			page.NextCursor = q.nextCursor(lastKey, last.ID)
+			break
+		}
+
 		expense.Category = &category
+		page.Expenses = append(page.Expenses, &expense)
+		lastKey = sortKey
+	}
 
-		expenses = append(expenses, &expense)
+	if err = rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return expenses, nil
+	return &page, nil
 }
 
+// insertIncomeQuery inserts one income. An income without a currency is in its
+// account's currency, or else the household's base currency. An income with an external
+// id the household already has is not inserted again, and returns no id.
+const insertIncomeQuery = `
+		INSERT INTO incomes (user_id, amount, currency, source_id, account_id, date, description, external_id, created_at, updated_at, household_id)
+		VALUES ($1, $2, coalesce(nullif($3, ''), (select currency from accounts where id = $5),
+			(select base_currency from households where id = $11)), $4, $5, $6, $7, nullif($8, ''), $9, $10, $11)
+		ON CONFLICT (household_id, external_id) DO NOTHING RETURNING id`
+
+// insertExpenseQuery inserts one expense. An expense without a currency is in its
+// account's currency, or else the household's base currency. An expense with an
+// external id the household already has is not inserted again, and returns no id.
+const insertExpenseQuery = `
+		INSERT INTO expenses (user_id, amount, currency, category_id, account_id, date, description, payment_method, external_id, created_at, updated_at, household_id)
+		VALUES ($1, $2, coalesce(nullif($3, ''), (select currency from accounts where id = $5),
+			(select base_currency from households where id = $12)), $4, $5, $6, $7, $8, nullif($9, ''), $10, $11, $12)
+		ON CONFLICT (household_id, external_id) DO NOTHING RETURNING id`
+
 func (m *PostgresDBRepo) InsertIncome(income *models.Income) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	// Check if the source exists for the specific user, and insert if it doesn't
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = m.addIncome(ctx, tx, income)
+	if err != nil {
+		log.Printf("Error inserting income: %v\n", err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m *PostgresDBRepo) InsertExpense(expense *models.Expense) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = m.addExpense(ctx, tx, expense)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// addIncome inserts an income within tx, creating its source if the household doesn't
+// have it yet.
+func (m *PostgresDBRepo) addIncome(ctx context.Context, tx *sql.Tx, income *models.Income) error {
+	// Check if the source exists in the household, and insert if it doesn't
+	sourceID, err := m.getOrCreateSource(ctx, tx, income.HouseholdID, income.UserID, income.Source)
+	if err != nil {
+		return err
+	}
+
+	income.SourceID = sourceID
+
+	// Insert the income record
+	return m.insertIncome(ctx, tx, tx.QueryRowContext(ctx, insertIncomeQuery, income.UserID, income.Amount, income.Currency, income.SourceID, income.AccountID, income.Date, income.Description, income.ExternalID, income.CreatedAt, income.UpdatedAt, income.HouseholdID), income)
+}
+
+// addExpense inserts an expense within tx, creating its category if the household
+// doesn't have it yet.
+func (m *PostgresDBRepo) addExpense(ctx context.Context, tx *sql.Tx, expense *models.Expense) error {
+	// Check if the category exists in the household, and insert if it doesn't
+	categoryID, err := m.getOrCreateCategory(ctx, tx, expense.HouseholdID, expense.UserID, expense.Category)
+	if err != nil {
+		return err
+	}
+	expense.CategoryID = categoryID
+
+	// Insert the expense record
+	return m.insertExpense(ctx, tx, tx.QueryRowContext(ctx, insertExpenseQuery, expense.UserID, expense.Amount, expense.Currency, expense.CategoryID, expense.AccountID, expense.Date, expense.Description, expense.PaymentMethod, expense.ExternalID, expense.CreatedAt, expense.UpdatedAt, expense.HouseholdID), expense)
+}
+
+// insertIncome reads the id row returns for an insertIncomeQuery, and logs the income.
+// An income skipped as already imported is neither logged nor an error; its id stays 0.
+func (m *PostgresDBRepo) insertIncome(ctx context.Context, tx *sql.Tx, row *sql.Row, income *models.Income) error {
+	err := row.Scan(&income.ID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return m.logChange(ctx, tx, models.AuditInsert, recordKey(models.AuditIncome, income.ID), nil)
+}
+
+// insertExpense reads the id row returns for an insertExpenseQuery, and logs the expense.
+// An expense skipped as already imported is neither logged nor an error; its id stays 0.
+func (m *PostgresDBRepo) insertExpense(ctx context.Context, tx *sql.Tx, row *sql.Row, expense *models.Expense) error {
+	err := row.Scan(&expense.ID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return m.logChange(ctx, tx, models.AuditInsert, recordKey(models.AuditExpense, expense.ID), nil)
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx, so lookups can run inside or
+// outside a transaction.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// getOrCreateSource returns the id of the household's source with the given name,
+// inserting and logging the source first, as added by userID, if the household doesn't
+// have one yet.
+func (m *PostgresDBRepo) getOrCreateSource(ctx context.Context, tx *sql.Tx, householdID, userID int, source *models.Source) (int, error) {
 	var sourceID int
 
-	err := m.DB.QueryRowContext(ctx, `
-		SELECT id FROM sources WHERE name = $1 AND user_id = $2`, 
-		income.Source.Name, income.UserID).Scan(&sourceID)
+	err := tx.QueryRowContext(ctx, `
+		SELECT id FROM sources WHERE name = $1 AND household_id = $2`, 
+		source.Name, householdID).Scan(&sourceID)
 			
 	if err == sql.ErrNoRows {
-		// Source doesn't exist for this user, insert it
-		err = m.DB.QueryRowContext(ctx, `
-			INSERT INTO sources (name, user_id, created_at, updated_at) VALUES ($1, $2, $3, $4) RETURNING id`, 
-			income.Source.Name, income.UserID, income.Source.CreatedAt, income.Source.UpdatedAt).Scan(&sourceID)
+		// Source doesn't exist in this household, insert it
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO sources (name, user_id, household_id, created_at, updated_at) VALUES ($1, $2, $3, $4, $5) RETURNING id`, 
+			source.Name, userID, householdID, source.CreatedAt, source.UpdatedAt).Scan(&sourceID)
 		if err != nil {
-			log.Printf("created at: %v\n", income.Source.CreatedAt)
+			log.Printf("created at: %v\n", source.CreatedAt)
 			log.Printf("Error inserting source: %v\n", err)
-			return err
+			return 0, err
+		}
+
+		err = m.logChange(ctx, tx, models.AuditInsert, recordKey(models.AuditSource, sourceID), nil)
+		if err != nil {
+			return 0, err
 		}
 	} else if err != nil {
 		log.Printf("Error checking if source exists: %v\n", err)
+		return 0, err
+	}
+
+	return sourceID, nil
+}
+
+// getOrCreateCategory returns the id of the household's category with the given name,
+// inserting and logging the category first, as added by userID, if the household doesn't
+// have one yet.
+func (m *PostgresDBRepo) getOrCreateCategory(ctx context.Context, tx *sql.Tx, householdID, userID int, category *models.Category) (int, error) {
+	var categoryID int
+	err := tx.QueryRowContext(ctx, `
+			SELECT id FROM categories WHERE name = $1 AND household_id = $2`, 
+			category.Name, householdID).Scan(&categoryID)
+	if err == sql.ErrNoRows {
+			// Category doesn't exist in this household, insert it
+			err = tx.QueryRowContext(ctx, `
+					INSERT INTO categories (name, user_id, household_id, created_at, updated_at) VALUES ($1, $2, $3, $4, $5) RETURNING id`, 
+					category.Name, userID, householdID, category.CreatedAt, category.UpdatedAt).Scan(&categoryID)
+			if err != nil {
+					return 0, err
+			}
+
+			err = m.logChange(ctx, tx, models.AuditInsert, recordKey(models.AuditCategory, categoryID), nil)
+			if err != nil {
+					return 0, err
+			}
+	} else if err != nil {
+			return 0, err
+	}
+
+	return categoryID, nil
+}
+
+// OneIncome returns one income record, with its source, if it belongs to the household.
+func (m *PostgresDBRepo) OneIncome(id, householdID int) (*models.Income, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select i.id, i.user_id, i.household_id, i.amount, i.currency, i.source_id, i.account_id, i.date, i.description, i.created_at, i.updated_at,
+			s.id, s.user_id, s.household_id, s.name, s.created_at, s.updated_at
+			from incomes i join sources s on i.source_id = s.id
+			where i.id = $1 and i.household_id = $2`
+
+	var income models.Income
+	var source models.Source
+
+	err := m.DB.QueryRowContext(ctx, query, id, householdID).Scan(
+		&income.ID,
+		&income.UserID,
+		&income.HouseholdID,
+		&income.Amount,
+		&income.Currency,
+		&income.SourceID,
+		&income.AccountID,
+		&income.Date,
+		&income.Description,
+		&income.CreatedAt,
+		&income.UpdatedAt,
+		&source.ID,
+		&source.UserID,
+		&source.HouseholdID,
+		&source.Name,
+		&source.CreatedAt,
+		&source.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	income.Source = &source
+
+	return &income, nil
+}
+
+// UpdateIncome updates one income record belonging to income.HouseholdID. The source is
+// looked up by name, and created if the household doesn't have it yet. It returns
+// sql.ErrNoRows if no matching income exists in the household.
+func (m *PostgresDBRepo) UpdateIncome(income *models.Income) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	key := recordKey(models.AuditIncome, income.ID)
+	before, err := snapshot(ctx, tx, key)
+	if err != nil {
+		return err
+	}
+
+	sourceID, err := m.getOrCreateSource(ctx, tx, income.HouseholdID, income.Source.UserID, income.Source)
+	if err != nil {
 		return err
 	}
 
 	income.SourceID = sourceID
 
-	// Insert the income record
-	query := `
-			INSERT INTO incomes (user_id, amount, source_id, date, description, created_at, updated_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7)`
-	_, err = m.DB.ExecContext(ctx, query, income.UserID, income.Amount, income.SourceID, income.Date, income.Description, income.CreatedAt, income.UpdatedAt)
+	stmt := `update incomes set amount = $1, currency = $2, source_id = $3, account_id = $4, date = $5, description = $6,
+			updated_at = $7 where id = $8 and household_id = $9`
+
+	res, err := tx.ExecContext(ctx, stmt,
+		income.Amount,
+		income.Currency,
+		income.SourceID,
+		income.AccountID,
+		income.Date,
+		income.Description,
+		income.UpdatedAt,
+		income.ID,
+		income.HouseholdID,
+	)
 	if err != nil {
-		log.Printf("Error inserting income: %v\n", err)
+		log.Printf("Error updating income: %v\n", err)
+		return err
 	}
-	return err
+
+	err = expectOneRow(res)
+	if err != nil {
+		return err
+	}
+
+	err = m.logChange(ctx, tx, models.AuditUpdate, key, before)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (m *PostgresDBRepo) InsertExpense(expense *models.Expense) error {
+// DeleteIncome deletes one income record, by id, if it belongs to the household. It
+// returns sql.ErrNoRows if no matching income exists in the household.
+func (m *PostgresDBRepo) DeleteIncome(id, householdID int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	// Check if the category exists for the specific user, and insert if it doesn't
-	var categoryID int
-	err := m.DB.QueryRowContext(ctx, `
-			SELECT id FROM categories WHERE name = $1 AND user_id = $2`, 
-			expense.Category.Name, expense.UserID).Scan(&categoryID)
-	if err == sql.ErrNoRows {
-			// Category doesn't exist for this user, insert it
-			err = m.DB.QueryRowContext(ctx, `
-					INSERT INTO categories (name, user_id, created_at, updated_at) VALUES ($1, $2, $3, $4) RETURNING id`, 
-					expense.Category.Name, expense.UserID, expense.Category.CreatedAt, expense.Category.UpdatedAt).Scan(&categoryID)
-			if err != nil {
-					return err
-			}
-	} else if err != nil {
-			return err
+	stmt := `delete from incomes where id = $1 and household_id = $2`
+
+	return m.execAudited(ctx, models.AuditDelete, recordKey(models.AuditIncome, id), stmt, id, householdID)
+}
+
+// OneExpense returns one expense record, with its category, if it belongs to the household.
+func (m *PostgresDBRepo) OneExpense(id, householdID int) (*models.Expense, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select e.id, e.user_id, e.household_id, e.amount, e.currency, e.category_id, e.account_id, e.date, e.description, e.payment_method,
+			e.created_at, e.updated_at, c.id, c.user_id, c.household_id, c.name, c.created_at, c.updated_at
+			from expenses e join categories c on e.category_id = c.id
+			where e.id = $1 and e.household_id = $2`
+
+	var expense models.Expense
+	var category models.Category
+
+	err := m.DB.QueryRowContext(ctx, query, id, householdID).Scan(
+		&expense.ID,
+		&expense.UserID,
+		&expense.HouseholdID,
+		&expense.Amount,
+		&expense.Currency,
+		&expense.CategoryID,
+		&expense.AccountID,
+		&expense.Date,
+		&expense.Description,
+		&expense.PaymentMethod,
+		&expense.CreatedAt,
+		&expense.UpdatedAt,
+		&category.ID,
+		&category.UserID,
+		&category.HouseholdID,
+		&category.Name,
+		&category.CreatedAt,
+		&category.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
 	}
+
+	expense.Category = &category
+
+	return &expense, nil
+}
+
+// UpdateExpense updates one expense record belonging to expense.HouseholdID. The
+// category is looked up by name, and created if the household doesn't have it yet. It
+// returns sql.ErrNoRows if no matching expense exists in the household.
+func (m *PostgresDBRepo) UpdateExpense(expense *models.Expense) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	key := recordKey(models.AuditExpense, expense.ID)
+	before, err := snapshot(ctx, tx, key)
+	if err != nil {
+		return err
+	}
+
+	categoryID, err := m.getOrCreateCategory(ctx, tx, expense.HouseholdID, expense.Category.UserID, expense.Category)
+	if err != nil {
+		return err
+	}
+
 	expense.CategoryID = categoryID
 
-	// Insert the expense record
-	query := `
-			INSERT INTO expenses (user_id, amount, category_id, date, description, payment_method, created_at, updated_at) 
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
-	_, err = m.DB.ExecContext(ctx, query, expense.UserID, expense.Amount, expense.CategoryID, expense.Date, expense.Description, expense.PaymentMethod, expense.CreatedAt, expense.UpdatedAt)
-	return err
+	stmt := `update expenses set amount = $1, currency = $2, category_id = $3, account_id = $4, date = $5, description = $6,
+			payment_method = $7, updated_at = $8 where id = $9 and household_id = $10`
+
+	res, err := tx.ExecContext(ctx, stmt,
+		expense.Amount,
+		expense.Currency,
+		expense.CategoryID,
+		expense.AccountID,
+		expense.Date,
+		expense.Description,
+		expense.PaymentMethod,
+		expense.UpdatedAt,
+		expense.ID,
+		expense.HouseholdID,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = expectOneRow(res)
+	if err != nil {
+		return err
+	}
+
+	err = m.logChange(ctx, tx, models.AuditUpdate, key, before)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (m *PostgresDBRepo) AllSources(id int) ([]*models.Source, error) {
+// DeleteExpense deletes one expense record, by id, if it belongs to the household. It
+// returns sql.ErrNoRows if no matching expense exists in the household.
+func (m *PostgresDBRepo) DeleteExpense(id, householdID int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, name, created_at, updated_at from sources where user_id = $1`
+	stmt := `delete from expenses where id = $1 and household_id = $2`
 
-	rows, err := m.DB.QueryContext(ctx, query, id)
+	return m.execAudited(ctx, models.AuditDelete, recordKey(models.AuditExpense, id), stmt, id, householdID)
+}
+
+// expectOneRow turns an update or delete that touched no rows into sql.ErrNoRows,
+// so callers can tell a missing (or someone else's) record apart from a success.
+func expectOneRow(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (m *PostgresDBRepo) AllSources(householdID int) ([]*models.Source, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, name, created_at, updated_at from sources where household_id = $1`
+
+	rows, err := m.DB.QueryContext(ctx, query, householdID)
 	if err != nil {
 		return nil, err
 	}
@@ -311,13 +837,13 @@ func (m *PostgresDBRepo) AllSources(id int) ([]*models.Source, error) {
 	return sources, nil
 }
 
-func (m *PostgresDBRepo) AllCategories(id int) ([]*models.Category, error) {
+func (m *PostgresDBRepo) AllCategories(householdID int) ([]*models.Category, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, name, created_at, updated_at from categories where user_id = $1`
+	query := `select id, name, created_at, updated_at from categories where household_id = $1`
 
-	rows, err := m.DB.QueryContext(ctx, query, id)
+	rows, err := m.DB.QueryContext(ctx, query, householdID)
 	if err != nil {
 		return nil, err
 	}
@@ -342,236 +868,205 @@ func (m *PostgresDBRepo) AllCategories(id int) ([]*models.Category, error) {
 	return categories, nil
 }
 
-func (m *PostgresDBRepo) GetTotalIncome(userID int) (float64, error) {
-	var totalIncome float64
-	query := `SELECT COALESCE(SUM(amount), 0) FROM incomes WHERE user_id = $1`
-	
-	err := m.DB.QueryRow(query, userID).Scan(&totalIncome)
-	if err != nil {
-			return 0, err
-	}
-	
-	return totalIncome, nil
-}
-
-func (m *PostgresDBRepo) GetTotalExpenses(userID int) (float64, error) {
-	var totalExpenses float64
-	query := `SELECT COALESCE(SUM(amount), 0) FROM expenses WHERE user_id = $1`
-	
-	err := m.DB.QueryRow(query, userID).Scan(&totalExpenses)
-	if err != nil {
-			return 0, err
-	}
-	
-	return totalExpenses, nil
-}
+// GetMonthlyExpensesByCategory returns the household's expenses within the period in one
+// statement, keyed by category name, for each month the period touches, oldest first. A
+// month with no expenses gets an empty map.
+func (m *PostgresDBRepo) GetMonthlyExpensesByCategory(householdID int, period models.Period) ([]map[string]models.Money, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
 
-func (m *PostgresDBRepo) GetIncomeBySource(userID int) (map[string]float64, error) {
-	query := `SELECT s.name, COALESCE(SUM(i.amount), 0) FROM incomes i
-						JOIN sources s ON i.source_id = s.id
-						WHERE i.user_id = $1 GROUP BY s.name`
-	
-	rows, err := m.DB.Query(query, userID)
+	// a month with no expenses comes back once with a null name
+	query := fmt.Sprintf(`
+		with months as (
+			select generate_series(date_trunc('month', $2::date), date_trunc('month', $3::date),
+				'1 month'::interval)::date as month
+		)
+		select m.month, t.name, coalesce(t.amount, 0)
+		from months m
+		left join (
+			select date_trunc('month', e.date)::date as month, c.name, sum(%s) as amount
+			from expenses e join categories c on e.category_id = c.id
+			where e.household_id = $1 and e.date between $2 and $3
+			group by 1, 2
+		) t on t.month = m.month
+		order by 1`, inBaseCurrency("e"))
+
+	rows, err := m.DB.QueryContext(ctx, query, householdID, period.From, period.To)
 	if err != nil {
-			return nil, err
+		return nil, err
 	}
 	defer rows.Close()
 
-	incomeBySource := make(map[string]float64)
-	
+	var months []map[string]models.Money
+	var current time.Time
+
 	for rows.Next() {
-			var sourceName string
-			var amount float64
-			
-			err := rows.Scan(&sourceName, &amount)
-			if err != nil {
-					return nil, err
-			}
-			
-			incomeBySource[sourceName] = amount
-	}
-	
-	return incomeBySource, nil
-}
+		var month time.Time
+		var name sql.NullString
+		var amount models.Money
 
-func (m *PostgresDBRepo) GetExpensesByCategory(userID int) (map[string]float64, error) {
-	query := `SELECT c.name, COALESCE(SUM(e.amount), 0) FROM expenses e
-						JOIN categories c ON e.category_id = c.id
-						WHERE e.user_id = $1 GROUP BY c.name`
-	
-	rows, err := m.DB.Query(query, userID)
-	if err != nil {
+		err := rows.Scan(&month, &name, &amount)
+		if err != nil {
 			return nil, err
+		}
+
+		if months == nil || !month.Equal(current) {
+			months = append(months, make(map[string]models.Money))
+			current = month
+		}
+
+		if name.Valid {
+			months[len(months)-1][name.String] = amount
+		}
 	}
-	defer rows.Close()
 
-	expensesByCategory := make(map[string]float64)
-	
-	for rows.Next() {
-			var categoryName string
-			var amount float64
-			
-			err := rows.Scan(&categoryName, &amount)
-			if err != nil {
-					return nil, err
-			}
-			
-			expensesByCategory[categoryName] = amount
+	if err = rows.Err(); err != nil {
+		return nil, err
 	}
-	
-	return expensesByCategory, nil
+
+	return months, nil
 }
 
-func (m *PostgresDBRepo) GetIncomeForMonth(userID, monthsAgo int) (float64, error) {
-	var income float64
-	query := `SELECT COALESCE(SUM(amount), 0) FROM incomes
-              WHERE user_id = $1 AND date_trunc('month', date) = date_trunc('month', (CURRENT_DATE - INTERVAL '1 month' * $2))`
-	
-	err := m.DB.QueryRow(query, userID, monthsAgo).Scan(&income)
-	if err != nil {
-			log.Println(err)
-			return 0, err
+// GetFinancialSummary builds the dashboard summary for the household in two statements:
+// all-time totals by source and category, and a breakdown of the period into buckets of
+// the given granularity, including buckets with no activity. Amounts are converted into
+// the household's base currency; transactions with no known rate are left out and counted.
+func (m *PostgresDBRepo) GetFinancialSummary(householdID int, period models.Period, granularity models.Granularity) (*models.FinancialSummary, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	summary := models.FinancialSummary{
+		OverallIncomeBySource:    make(map[string]models.Money),
+		OverallExpenseByCategory: make(map[string]models.Money),
+		Period:                   period,
+		Granularity:              granularity,
+		Months:                   []*models.BucketSummary{},
 	}
-	
-	return income, nil
-}
 
-func (m *PostgresDBRepo) GetExpensesForMonth(userID, monthsAgo int) (float64, error) {
-	var expenses float64
-	query := `SELECT COALESCE(SUM(amount), 0) FROM expenses
-						WHERE user_id = $1 AND date_trunc('month', date) = date_trunc('month', (CURRENT_DATE - INTERVAL '1 month' * $2))`
-	
-	
-	err := m.DB.QueryRow(query, userID, monthsAgo).Scan(&expenses)
+	currencyQuery := fmt.Sprintf(`select h.base_currency,
+			(select count(*) from incomes i where i.household_id = $1 and %s is null)
+			+ (select count(*) from expenses e where e.household_id = $1 and %s is null)
+			from households h where h.id = $1`, inBaseCurrency("i"), inBaseCurrency("e"))
+
+	err := m.DB.QueryRowContext(ctx, currencyQuery, householdID).Scan(&summary.BaseCurrency, &summary.MissingRates)
 	if err != nil {
-			return 0, err
+		return nil, err
 	}
-	
-	return expenses, nil
-}
 
-func (m *PostgresDBRepo) GetIncomeBySourceForMonth(userID, monthsAgo int) (map[string]float64, error) {
-	query := `SELECT s.name, COALESCE(SUM(i.amount), 0) FROM incomes i
-						JOIN sources s ON i.source_id = s.id
-						WHERE i.user_id = $1 AND date_trunc('month', i.date) = date_trunc('month', (CURRENT_DATE - INTERVAL '1 month' * $2))
-						GROUP BY s.name`
-	
-	rows, err := m.DB.Query(query, userID, monthsAgo)
+	// Rows with a bucket are the breakdown of the period; a bucket with no activity
+	// comes back once with a null kind. Rows without a bucket are the all-time totals.
+	query := fmt.Sprintf(`
+		with buckets as (
+			select generate_series(date_trunc($4, $2::date), date_trunc($4, $3::date),
+				$5::interval)::date as bucket
+		), entries as (
+			select 'income' as kind, i.date, s.name, %s as amount
+			from incomes i left join sources s on i.source_id = s.id
+			where i.household_id = $1
+			union all
+			select 'expense', e.date, c.name, %s
+			from expenses e left join categories c on e.category_id = c.id
+			where e.household_id = $1
+		)
+		select b.bucket, t.kind, t.name, coalesce(t.amount, 0)
+		from buckets b
+		left join (
+			select kind, date_trunc($4, date)::date as bucket, name, sum(amount) as amount
+			from entries where date between $2 and $3 group by 1, 2, 3
+		) t on t.bucket = b.bucket
+		union all
+		select null, kind, name, coalesce(sum(amount), 0) from entries group by kind, name
+		order by 1 nulls first`, inBaseCurrency("i"), inBaseCurrency("e"))
+
+	rows, err := m.DB.QueryContext(ctx, query, householdID, period.From, period.To, string(granularity), granularity.Interval())
 	if err != nil {
-			return nil, err
+		return nil, err
 	}
 	defer rows.Close()
 
-	incomeBySource := make(map[string]float64)
-	
+	var current *models.BucketSummary
+
 	for rows.Next() {
-			var sourceName string
-			var amount float64
-			
-			err := rows.Scan(&sourceName, &amount)
-			if err != nil {
-					return nil, err
-			}
-			
-			incomeBySource[sourceName] = amount
-	}
-	
-	return incomeBySource, nil
-}
+		var bucket sql.NullTime
+		var kind, name sql.NullString
+		var amount models.Money
 
-func (m *PostgresDBRepo) GetExpensesByCategoryForMonth(userID, monthsAgo int) (map[string]float64, error) {
-	query := `SELECT c.name, COALESCE(SUM(e.amount), 0) FROM expenses e
-						JOIN categories c ON e.category_id = c.id
-						WHERE e.user_id = $1 AND date_trunc('month', e.date) = date_trunc('month', (CURRENT_DATE - INTERVAL '1 month' * $2))
-						GROUP BY c.name`
-	
-	rows, err := m.DB.Query(query, userID, monthsAgo)
-	if err != nil {
+		err := rows.Scan(&bucket, &kind, &name, &amount)
+		if err != nil {
 			return nil, err
-	}
-	defer rows.Close()
+		}
 
-	expensesByCategory := make(map[string]float64)
-	
-	for rows.Next() {
-			var categoryName string
-			var amount float64
-			
-			err := rows.Scan(&categoryName, &amount)
-			if err != nil {
-					return nil, err
+		if !bucket.Valid {
+			// all-time totals
+			switch kind.String {
+			case "income":
+				summary.IncomeSumTotal += amount
+				if name.Valid {
+					summary.OverallIncomeBySource[name.String] = amount
+				}
+			case "expense":
+				summary.ExpenseSumTotal += amount
+				if name.Valid {
+					summary.OverallExpenseByCategory[name.String] = amount
+				}
 			}
-			
-			expensesByCategory[categoryName] = amount
-	}
-	
-	return expensesByCategory, nil
-}
+			continue
+		}
 
-func (m *PostgresDBRepo) GetTop3IncomeSourcesForMonth(userID, monthsAgo int) ([]map[string]interface{}, error) {
-	query := `SELECT s.name, COALESCE(SUM(i.amount), 0) FROM incomes i
-						JOIN sources s ON i.source_id = s.id
-						WHERE i.user_id = $1 AND date_trunc('month', i.date) = date_trunc('month', (CURRENT_DATE - INTERVAL '1 month' * $2))
-						GROUP BY s.name ORDER BY SUM(i.amount) DESC LIMIT 3`
-	
-	rows, err := m.DB.Query(query, userID, monthsAgo)
-	if err != nil {
-			return nil, err
-	}
-	defer rows.Close()
+		if current == nil || !bucket.Time.Equal(current.Start) {
+			current = &models.BucketSummary{
+				Label:             granularity.Label(bucket.Time),
+				Start:             bucket.Time,
+				IncomeBySource:    make(map[string]models.Money),
+				ExpenseByCategory: make(map[string]models.Money),
+				Top3Income:        []*models.SourceAmount{},
+				Top3Expense:       []*models.CategoryAmount{},
+			}
+			summary.Months = append(summary.Months, current)
+		}
 
-	var top3IncomeSources []map[string]interface{}
-	
-	for rows.Next() {
-			var sourceName string
-			var amount float64
-			
-			err := rows.Scan(&sourceName, &amount)
-			if err != nil {
-					return nil, err
+		switch kind.String {
+		case "income":
+			current.IncomeSum += amount
+			if name.Valid {
+				current.IncomeBySource[name.String] = amount
+				current.Top3Income = append(current.Top3Income, &models.SourceAmount{Source: name.String, Amount: amount})
 			}
-			
-			top3IncomeSources = append(top3IncomeSources, map[string]interface{}{
-					"source": sourceName,
-					"amount": amount,
-			})
+		case "expense":
+			current.ExpenseSum += amount
+			if name.Valid {
+				current.ExpenseByCategory[name.String] = amount
+				current.Top3Expense = append(current.Top3Expense, &models.CategoryAmount{Category: name.String, Amount: amount})
+			}
+		}
 	}
-	
-	return top3IncomeSources, nil
-}
 
-func (m *PostgresDBRepo) GetTop3ExpenseCategoriesForMonth(userID, monthsAgo int) ([]map[string]interface{}, error) {
-	query := `SELECT c.name, COALESCE(SUM(e.amount), 0) FROM expenses e
-						JOIN categories c ON e.category_id = c.id
-						WHERE e.user_id = $1 AND date_trunc('month', e.date) = date_trunc('month', (CURRENT_DATE - INTERVAL '1 month' * $2))
-						GROUP BY c.name ORDER BY SUM(e.amount) DESC LIMIT 3`
-	
-	rows, err := m.DB.Query(query, userID, monthsAgo)
-	if err != nil {
-			return nil, err
+	if err = rows.Err(); err != nil {
+		return nil, err
 	}
-	defer rows.Close()
 
-	var top3ExpenseCategories []map[string]interface{}
-	
-	for rows.Next() {
-			var categoryName string
-			var amount float64
-			
-			err := rows.Scan(&categoryName, &amount)
-			if err != nil {
-					return nil, err
-			}
-			
-			top3ExpenseCategories = append(top3ExpenseCategories, map[string]interface{}{
-					"category": categoryName,
-					"amount": amount,
-			})
+	summary.AccountBalance = summary.IncomeSumTotal - summary.ExpenseSumTotal
+
+	for _, b := range summary.Months {
+		b.NetIncome = b.IncomeSum - b.ExpenseSum
+
+		sort.SliceStable(b.Top3Income, func(i, j int) bool {
+			return b.Top3Income[i].Amount > b.Top3Income[j].Amount
+		})
+		if len(b.Top3Income) > 3 {
+			b.Top3Income = b.Top3Income[:3]
+		}
+
+		sort.SliceStable(b.Top3Expense, func(i, j int) bool {
+			return b.Top3Expense[i].Amount > b.Top3Expense[j].Amount
+		})
+		if len(b.Top3Expense) > 3 {
+			b.Top3Expense = b.Top3Expense[:3]
+		}
 	}
-	
-	return top3ExpenseCategories, nil
-}
 
+	return &summary, nil
+}
 
 // -------------------------------------- OLD CODE FOR REFERENCE --------------------------------------
 
@@ -0,0 +1,504 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"database/sql"
+	"time"
+)
+
+// recurringColumns selects a recurring template aliased as t, with the names of its
+// source and category, joined as s and c.
+const recurringColumns = `t.id, t.user_id, t.household_id, t.kind, t.amount, coalesce(t.currency, ''), t.source_id, coalesce(s.name, ''),
+		t.category_id, coalesce(c.name, ''), t.account_id, coalesce(t.description, ''), coalesce(t.payment_method, ''),
+		t.frequency, t.rule, t.start_date, t.end_date, t.posted_through, t.created_at, t.updated_at`
+
+const recurringJoins = `recurring_templates t
+		left join sources s on t.source_id = s.id
+		left join categories c on t.category_id = c.id`
+
+func scanRecurring(row interface {
+	Scan(dest ...interface{}) error
+}) (*models.RecurringTemplate, error) {
+	var t models.RecurringTemplate
+	var sourceName, categoryName string
+	err := row.Scan(
+		&t.ID,
+		&t.UserID,
+		&t.HouseholdID,
+		&t.Kind,
+		&t.Amount,
+		&t.Currency,
+		&t.SourceID,
+		&sourceName,
+		&t.CategoryID,
+		&categoryName,
+		&t.AccountID,
+		&t.Description,
+		&t.PaymentMethod,
+		&t.Frequency,
+		&t.Rule,
+		&t.StartDate,
+		&t.EndDate,
+		&t.PostedThrough,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.SourceID != nil {
+		t.Source = &models.Source{ID: *t.SourceID, HouseholdID: t.HouseholdID, Name: sourceName}
+	}
+
+	if t.CategoryID != nil {
+		t.Category = &models.Category{ID: *t.CategoryID, HouseholdID: t.HouseholdID, Name: categoryName}
+	}
+
+	return &t, nil
+}
+
+func (m *PostgresDBRepo) queryRecurring(ctx context.Context, query string, args ...interface{}) ([]*models.RecurringTemplate, error) {
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []*models.RecurringTemplate{}
+
+	for rows.Next() {
+		t, err := scanRecurring(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+
+	return templates, rows.Err()
+}
+
+// AllRecurring returns the household's recurring templates, oldest first.
+func (m *PostgresDBRepo) AllRecurring(householdID int) ([]*models.RecurringTemplate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + recurringColumns + ` from ` + recurringJoins + `
+			where t.household_id = $1 order by t.start_date, t.id`
+
+	return m.queryRecurring(ctx, query, householdID)
+}
+
+// OneRecurring returns one recurring template, if it belongs to the household.
+func (m *PostgresDBRepo) OneRecurring(id, householdID int) (*models.RecurringTemplate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + recurringColumns + ` from ` + recurringJoins + `
+			where t.id = $1 and t.household_id = $2`
+
+	return scanRecurring(m.DB.QueryRowContext(ctx, query, id, householdID))
+}
+
+// DueRecurring returns the recurring templates, of every household, that may have occurrences
+// on or before today still to post.
+func (m *PostgresDBRepo) DueRecurring(today time.Time) ([]*models.RecurringTemplate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + recurringColumns + ` from ` + recurringJoins + `
+			where t.start_date <= $1
+			and (t.posted_through is null
+				or (t.posted_through < $1 and (t.end_date is null or t.posted_through < t.end_date)))
+			order by t.id`
+
+	return m.queryRecurring(ctx, query, today)
+}
+
+// setRecurringParty looks up the template's source or category by name, creating it if
+// the household doesn't have it yet, and clears the one its kind doesn't use.
+func (m *PostgresDBRepo) setRecurringParty(ctx context.Context, tx *sql.Tx, t *models.RecurringTemplate) error {
+	t.SourceID = nil
+	t.CategoryID = nil
+
+	if t.Kind == models.RecurringIncome {
+		t.Category = nil
+		sourceID, err := m.getOrCreateSource(ctx, tx, t.HouseholdID, t.Source.UserID, t.Source)
+		if err != nil {
+			return err
+		}
+		t.SourceID = &sourceID
+		return nil
+	}
+
+	t.Source = nil
+	categoryID, err := m.getOrCreateCategory(ctx, tx, t.HouseholdID, t.Category.UserID, t.Category)
+	if err != nil {
+		return err
+	}
+	t.CategoryID = &categoryID
+
+	return nil
+}
+
+// InsertRecurring inserts one recurring template and returns its id.
+func (m *PostgresDBRepo) InsertRecurring(t *models.RecurringTemplate) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = m.setRecurringParty(ctx, tx, t)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `insert into recurring_templates (user_id, kind, amount, currency, source_id, category_id, account_id,
+				description, payment_method, frequency, rule, start_date, end_date, created_at, updated_at, household_id)
+			values ($1, $2, $3, nullif($4, ''), $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16) returning id`
+
+	var newID int
+
+	err = tx.QueryRowContext(ctx, stmt,
+		t.UserID,
+		t.Kind,
+		t.Amount,
+		t.Currency,
+		t.SourceID,
+		t.CategoryID,
+		t.AccountID,
+		t.Description,
+		t.PaymentMethod,
+		t.Frequency,
+		t.Rule,
+		t.StartDate,
+		t.EndDate,
+		t.CreatedAt,
+		t.UpdatedAt,
+		t.HouseholdID,
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = m.logChange(ctx, tx, models.AuditInsert, recordKey(models.AuditRecurring, newID), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateRecurring updates one recurring template belonging to t.HouseholdID. Occurrences
+// already posted are left as they are. It returns sql.ErrNoRows if no matching template
+// exists in the household.
+func (m *PostgresDBRepo) UpdateRecurring(t *models.RecurringTemplate) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	key := recordKey(models.AuditRecurring, t.ID)
+	before, err := snapshot(ctx, tx, key)
+	if err != nil {
+		return err
+	}
+
+	err = m.setRecurringParty(ctx, tx, t)
+	if err != nil {
+		return err
+	}
+
+	stmt := `update recurring_templates set kind = $1, amount = $2, currency = nullif($3, ''), source_id = $4,
+				category_id = $5, account_id = $6, description = $7, payment_method = $8, frequency = $9, rule = $10,
+				start_date = $11, end_date = $12, updated_at = $13
+			where id = $14 and household_id = $15`
+
+	res, err := tx.ExecContext(ctx, stmt,
+		t.Kind,
+		t.Amount,
+		t.Currency,
+		t.SourceID,
+		t.CategoryID,
+		t.AccountID,
+		t.Description,
+		t.PaymentMethod,
+		t.Frequency,
+		t.Rule,
+		t.StartDate,
+		t.EndDate,
+		t.UpdatedAt,
+		t.ID,
+		t.HouseholdID,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = expectOneRow(res)
+	if err != nil {
+		return err
+	}
+
+	err = m.logChange(ctx, tx, models.AuditUpdate, key, before)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteRecurring deletes one recurring template, if it belongs to the household, with its
+// skipped, overridden and posted occurrences. Incomes and expenses it already posted are
+// kept. It returns sql.ErrNoRows if no matching
+// template exists in the household.
+func (m *PostgresDBRepo) DeleteRecurring(id, householdID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	deps := []dependents{{
+		entity: models.AuditRecurringOccurrence,
+		action: models.AuditDelete,
+		where:  "template_id = $1",
+		args:   []interface{}{id},
+	}}
+
+	stmt := `delete from recurring_templates where id = $1 and household_id = $2`
+
+	return m.deleteAudited(ctx, recordKey(models.AuditRecurring, id), deps, stmt, id, householdID)
+}
+
+// RecurringOccurrences returns the skipped, overridden and posted occurrences of the
+// household's templates from from to to, both inclusive.
+func (m *PostgresDBRepo) RecurringOccurrences(householdID int, from, to time.Time) ([]*models.RecurringOccurrence, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select template_id, household_id, date, status, amount, description, created_at, updated_at
+			from recurring_occurrences where household_id = $1 and date between $2 and $3
+			order by date, template_id`
+
+	rows, err := m.DB.QueryContext(ctx, query, householdID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	occurrences := []*models.RecurringOccurrence{}
+
+	for rows.Next() {
+		var o models.RecurringOccurrence
+		err := rows.Scan(
+			&o.TemplateID,
+			&o.HouseholdID,
+			&o.Date,
+			&o.Status,
+			&o.Amount,
+			&o.Description,
+			&o.CreatedAt,
+			&o.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		occurrences = append(occurrences, &o)
+	}
+
+	return occurrences, rows.Err()
+}
+
+// occurrenceKey names the occurrence of a template on date.
+func occurrenceKey(templateID int, date time.Time) auditKey {
+	return auditKey{
+		entity: models.AuditRecurringOccurrence,
+		id:     templateID,
+		where:  "template_id = $1 and date = $2",
+		args:   []interface{}{templateID, date},
+	}
+}
+
+// SetRecurringOccurrence skips or overrides one occurrence of one of o.HouseholdID's
+// templates, replacing any earlier skip or override. It returns repository.ErrPosted if
+// the occurrence has already been posted, or the template does not belong to the
+// household.
+func (m *PostgresDBRepo) SetRecurringOccurrence(o *models.RecurringOccurrence) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into recurring_occurrences (template_id, household_id, date, status, amount, description, created_at, updated_at)
+			select $1, $2, $3, $4, $5, $6, $7, $8
+			where exists (select 1 from recurring_templates where id = $1 and household_id = $2)
+			on conflict (template_id, date) do update
+			set status = excluded.status, amount = excluded.amount, description = excluded.description,
+				updated_at = excluded.updated_at
+			where recurring_occurrences.status <> 'posted'`
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	key := occurrenceKey(o.TemplateID, o.Date)
+	before, err := snapshot(ctx, tx, key)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, stmt,
+		o.TemplateID,
+		o.HouseholdID,
+		o.Date,
+		o.Status,
+		o.Amount,
+		o.Description,
+		o.CreatedAt,
+		o.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return repository.ErrPosted
+	}
+
+	action := models.AuditUpdate
+	if before == nil {
+		action = models.AuditInsert
+	}
+
+	err = m.logChange(ctx, tx, action, key, before)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteRecurringOccurrence removes the skip or override of one occurrence, if its
+// template belongs to the household, so it is posted as the template says. It returns
+// sql.ErrNoRows if the occurrence is neither skipped nor overridden.
+func (m *PostgresDBRepo) DeleteRecurringOccurrence(templateID, householdID int, date time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `delete from recurring_occurrences
+			where template_id = $1 and household_id = $2 and date = $3 and status <> 'posted'`
+
+	return m.execAudited(ctx, models.AuditDelete, occurrenceKey(templateID, date), stmt, templateID, householdID, date)
+}
+
+// PostRecurringOccurrence posts one occurrence of a template, with any override, as an
+// income or expense, and marks it posted, all in one transaction. Only one caller can
+// post an occurrence; it returns sql.ErrNoRows if the occurrence was already posted or
+// is skipped.
+func (m *PostgresDBRepo) PostRecurringOccurrence(t *models.RecurringTemplate, date time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	key := occurrenceKey(t.ID, date)
+	before, err := snapshot(ctx, tx, key)
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into recurring_occurrences (template_id, household_id, date, status, created_at, updated_at)
+			values ($1, $2, $3, 'posted', $4, $4)
+			on conflict (template_id, date) do update
+			set status = 'posted', updated_at = excluded.updated_at
+			where recurring_occurrences.status = 'override'
+			returning template_id, household_id, date, status, amount, description, created_at, updated_at`
+
+	var o models.RecurringOccurrence
+
+	err = tx.QueryRowContext(ctx, stmt, t.ID, t.HouseholdID, date, time.Now()).Scan(
+		&o.TemplateID,
+		&o.HouseholdID,
+		&o.Date,
+		&o.Status,
+		&o.Amount,
+		&o.Description,
+		&o.CreatedAt,
+		&o.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	action := models.AuditUpdate
+	if before == nil {
+		action = models.AuditInsert
+	}
+
+	err = m.logChange(ctx, tx, action, key, before)
+	if err != nil {
+		return err
+	}
+
+	occurrence := t.Upcoming(date, &o)
+
+	if t.Kind == models.RecurringIncome {
+		income, err := t.Income(occurrence)
+		if err != nil {
+			return err
+		}
+		err = m.addIncome(ctx, tx, income)
+		if err != nil {
+			return err
+		}
+	} else {
+		expense, err := t.Expense(occurrence)
+		if err != nil {
+			return err
+		}
+		err = m.addExpense(ctx, tx, expense)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SetRecurringPostedThrough records that every occurrence of a template up to date has
+// been handled. It never moves the date backwards. This is the scheduler's bookkeeping,
+// moved every day for every template, so it is left out of the audit log; the occurrences
+// it posts or skips are logged where that happens.
+func (m *PostgresDBRepo) SetRecurringPostedThrough(templateID int, date time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update recurring_templates set posted_through = $2
+			where id = $1 and (posted_through is null or posted_through < $2)`
+
+	_, err := m.DB.ExecContext(ctx, stmt, templateID, date)
+
+	return err
+}
@@ -0,0 +1,218 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"database/sql"
+	"time"
+)
+
+// InsertSession stores the first session of a new login, and sets its id.
+func (m *PostgresDBRepo) InsertSession(session *models.Session) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return insertSession(ctx, m.DB, session)
+}
+
+// insertSession inserts session, and sets its id.
+func insertSession(ctx context.Context, db queryRower, session *models.Session) error {
+	stmt := `insert into sessions (user_id, family_id, token_hash, user_agent, ip_address, expires_at,
+			created_at, last_used_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	return db.QueryRowContext(ctx, stmt, session.UserID, session.FamilyID, session.TokenHash, session.UserAgent,
+		session.IPAddress, session.ExpiresAt, session.CreatedAt, session.LastUsedAt).Scan(&session.ID)
+}
+
+// RotateSession exchanges the refresh token with the given hash for next, which joins
+// its family; next's id, user, family and login time are set from it. It returns
+// sql.ErrNoRows if the token is unknown, expired or revoked. If the token was already
+// rotated, it has been used twice, so the whole family is revoked and ErrTokenReused is
+// returned, with next's user and family set to say whose.
+func (m *PostgresDBRepo) RotateSession(tokenHash string, next *models.Session, now time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current models.Session
+
+	// the row stays locked until commit, so two refreshes with the same token cannot
+	// both rotate it
+	err = tx.QueryRowContext(ctx, `select id, user_id, family_id, expires_at, created_at, rotated_at, revoked_at
+			from sessions where token_hash = $1 for update`, tokenHash).Scan(
+		&current.ID,
+		&current.UserID,
+		&current.FamilyID,
+		&current.ExpiresAt,
+		&current.CreatedAt,
+		&current.RotatedAt,
+		&current.RevokedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	if current.RevokedAt != nil || !current.ExpiresAt.After(now) {
+		return sql.ErrNoRows
+	}
+
+	next.UserID = current.UserID
+	next.FamilyID = current.FamilyID
+
+	if current.RotatedAt != nil {
+		_, err = tx.ExecContext(ctx, `update sessions set revoked_at = $1 where family_id = $2 and revoked_at is null`,
+			now, current.FamilyID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+		return repository.ErrTokenReused
+	}
+
+	_, err = tx.ExecContext(ctx, `update sessions set rotated_at = $1 where id = $2`, now, current.ID)
+	if err != nil {
+		return err
+	}
+
+	next.CreatedAt = current.CreatedAt
+
+	err = insertSession(ctx, tx, next)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RevokeSession revokes the family of the refresh token with the given hash, so neither
+// it nor any token rotated from the same login works again. Unknown tokens are ignored.
+func (m *PostgresDBRepo) RevokeSession(tokenHash string, now time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update sessions set revoked_at = $1
+			where family_id = (select family_id from sessions where token_hash = $2) and revoked_at is null`
+
+	_, err := m.DB.ExecContext(ctx, stmt, now, tokenHash)
+	return err
+}
+
+// AllSessions returns the sessions of the user that can still be refreshed, the most
+// recently used first: the live token of each family.
+func (m *PostgresDBRepo) AllSessions(userID int, now time.Time) ([]*models.Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, family_id, token_hash, user_agent, ip_address, expires_at, created_at, last_used_at
+			from sessions
+			where user_id = $1 and rotated_at is null and revoked_at is null and expires_at > $2
+			order by last_used_at desc, id desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*models.Session{}
+
+	for rows.Next() {
+		var session models.Session
+		err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.FamilyID,
+			&session.TokenHash,
+			&session.UserAgent,
+			&session.IPAddress,
+			&session.ExpiresAt,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// RevokeUserSession revokes the session of the user with the given family id. It returns
+// sql.ErrNoRows if the user has no such session, or it was already revoked.
+func (m *PostgresDBRepo) RevokeUserSession(userID int, familyID string, now time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update sessions set revoked_at = $1 where user_id = $2 and family_id = $3 and revoked_at is null`
+
+	res, err := m.DB.ExecContext(ctx, stmt, now, userID, familyID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// RevokeAllSessions revokes every session of the user, logging them out everywhere, and
+// returns how many tokens it revoked.
+func (m *PostgresDBRepo) RevokeAllSessions(userID int, now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, `update sessions set revoked_at = $1 where user_id = $2 and revoked_at is null`,
+		now, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), nil
+}
+
+// DeleteExpiredSessions removes the families whose every token expired before now, and
+// returns how many sessions it removed. Rotated tokens of live families are kept, so their
+// reuse is still caught.
+func (m *PostgresDBRepo) DeleteExpiredSessions(now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `delete from sessions where family_id in
+			(select family_id from sessions group by family_id having max(expires_at) < $1)`
+
+	res, err := m.DB.ExecContext(ctx, stmt, now)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), nil
+}
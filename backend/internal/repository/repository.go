@@ -3,29 +3,138 @@ package repository
 import (
 	"backend/internal/models"
 	"database/sql"
+	"errors"
+	"time"
 )
 
+// ErrInUse is returned when a record cannot be deleted, or changed, because other
+// records still refer to it.
+var ErrInUse = errors.New("record is still in use")
+
+// ErrDuplicate is returned when a record would clash with an existing one.
+var ErrDuplicate = errors.New("record already exists")
+
+// ErrPosted is returned when a recurring occurrence has already been posted, and can no
+// longer be skipped or changed.
+var ErrPosted = errors.New("occurrence already posted")
+
+// ErrTokenReused is returned when a refresh token that was already rotated is presented
+// again, which means it was copied; its whole session has been revoked.
+var ErrTokenReused = errors.New("refresh token reused")
+
+// ErrLastOwner is returned when a change would leave a household without an owner.
+var ErrLastOwner = errors.New("a household needs at least one owner")
+
+// ErrLastHousehold is returned when a change would leave a user without a household.
+var ErrLastHousehold = errors.New("a user needs at least one household")
+
 
 type DatabaseRepo interface {
 	Connection() *sql.DB
 	GetUserByEmail(email string) (*models.User, error)
 	GetUserByID(id int) (*models.User, error)
-	AllIncomes(id int) ([]*models.Income, error)
-	AllExpenses(id int) ([]*models.Expense, error)
+	UpdateUserBaseCurrency(userID int, currency string) error
+	VerifyUserEmail(id int, email string, at time.Time) error
+	AllIncomes(householdID int, filter models.TransactionFilter) (*models.IncomePage, error)
+	AllExpenses(householdID int, filter models.TransactionFilter) (*models.ExpensePage, error)
 	InsertIncome(income *models.Income) error
 	InsertExpense(expense *models.Expense) error
-	AllSources(id int) ([]*models.Source, error)
-	AllCategories(id int) ([]*models.Category, error)
-	GetTotalIncome(userID int) (float64, error)
-	GetTotalExpenses(userID int) (float64, error)
-	GetIncomeBySource(userID int) (map[string]float64, error)
-	GetExpensesByCategory(userID int) (map[string]float64, error)
-	GetIncomeForMonth(userID, monthsAgo int) (float64, error)
-	GetExpensesForMonth(userID, monthsAgo int) (float64, error)
-	GetIncomeBySourceForMonth(userID, monthsAgo int) (map[string]float64, error)
-	GetExpensesByCategoryForMonth(userID, monthsAgo int) (map[string]float64, error)
-	GetTop3IncomeSourcesForMonth(userID, monthsAgo int) ([]map[string]interface{}, error)
-	GetTop3ExpenseCategoriesForMonth(userID, monthsAgo int) ([]map[string]interface{}, error)
+	OneIncome(id, householdID int) (*models.Income, error)
+	UpdateIncome(income *models.Income) error
+	DeleteIncome(id, householdID int) error
+	OneExpense(id, householdID int) (*models.Expense, error)
+	UpdateExpense(expense *models.Expense) error
+	DeleteExpense(id, householdID int) error
+	AllSources(householdID int) ([]*models.Source, error)
+	AllCategories(householdID int) ([]*models.Category, error)
+	GetMonthlyExpensesByCategory(householdID int, period models.Period) ([]map[string]models.Money, error)
+	GetFinancialSummary(householdID int, period models.Period, granularity models.Granularity) (*models.FinancialSummary, error)
+	UpsertExchangeRates(rates []*models.ExchangeRate) (int, error)
+	AllAccounts(userID int) ([]*models.Account, error)
+	OneAccount(id, userID int) (*models.Account, error)
+	InsertAccount(account *models.Account) (int, error)
+	UpdateAccount(account *models.Account) error
+	DeleteAccount(id, userID int) error
+	AccountLedger(id, userID int) ([]*models.LedgerEntry, error)
+	AllTransfers(userID int) ([]*models.Transfer, error)
+	InsertTransfer(transfer *models.Transfer) (int, error)
+	DeleteTransfer(id, userID int) error
+	AllBudgets(householdID int) ([]*models.Budget, error)
+	OneBudget(id, householdID int) (*models.Budget, error)
+	InsertBudget(budget *models.Budget) (int, error)
+	UpdateBudget(budget *models.Budget) error
+	DeleteBudget(id, householdID int) error
+	AllGoals(householdID int) ([]*models.Goal, error)
+	OneGoal(id, householdID int) (*models.Goal, error)
+	InsertGoal(goal *models.Goal) (int, error)
+	UpdateGoal(goal *models.Goal) error
+	DeleteGoal(id, householdID int) error
+	AllGoalContributions(goalID, householdID int) ([]*models.GoalContribution, error)
+	InsertGoalContribution(householdID int, contribution *models.GoalContribution) (int, error)
+	DeleteGoalContribution(id, goalID, householdID int) error
+	AllRecurring(householdID int) ([]*models.RecurringTemplate, error)
+	OneRecurring(id, householdID int) (*models.RecurringTemplate, error)
+	DueRecurring(today time.Time) ([]*models.RecurringTemplate, error)
+	InsertRecurring(t *models.RecurringTemplate) (int, error)
+	UpdateRecurring(t *models.RecurringTemplate) error
+	DeleteRecurring(id, householdID int) error
+	RecurringOccurrences(householdID int, from, to time.Time) ([]*models.RecurringOccurrence, error)
+	SetRecurringOccurrence(o *models.RecurringOccurrence) error
+	DeleteRecurringOccurrence(templateID, householdID int, date time.Time) error
+	PostRecurringOccurrence(t *models.RecurringTemplate, date time.Time) error
+	SetRecurringPostedThrough(templateID int, date time.Time) error
+	AllImportMappings(userID int) ([]*models.ImportMapping, error)
+	OneImportMapping(id, userID int) (*models.ImportMapping, error)
+	InsertImportMapping(mapping *models.ImportMapping) (int, error)
+	UpdateImportMapping(mapping *models.ImportMapping) error
+	DeleteImportMapping(id, userID int) error
+	ImportTransactions(householdID, userID int, incomes []*models.Income, expenses []*models.Expense) (int, error)
+	ImportedExternalIDs(householdID int, ids []string) (map[string]bool, error)
+	ImportJournal(householdID, userID int, sources []*models.Source, categories []*models.Category, incomes []*models.Income, expenses []*models.Expense) (int, error)
+	EachIncome(householdID int, fn func(*models.Income) error) error
+	EachExpense(householdID int, fn func(*models.Expense) error) error
+	InsertPasswordReset(reset *models.PasswordReset) error
+	PasswordResetUser(tokenHash string, now time.Time) (int, error)
+	ResetPassword(tokenHash, passwordHash string, now time.Time) (int, error)
+	DeleteExpiredPasswordResets(now time.Time) (int, error)
+	InsertSession(session *models.Session) error
+	RotateSession(tokenHash string, next *models.Session, now time.Time) error
+	RevokeSession(tokenHash string, now time.Time) error
+	AllSessions(userID int, now time.Time) ([]*models.Session, error)
+	RevokeUserSession(userID int, familyID string, now time.Time) error
+	RevokeAllSessions(userID int, now time.Time) (int, error)
+	DeleteExpiredSessions(now time.Time) (int, error)
+	SetTOTPSecret(userID int, secret string) error
+	EnableTOTP(userID int, step int64, codeHashes []string, now time.Time) error
+	UseTOTPStep(userID int, step int64) error
+	UseRecoveryCode(userID int, codeHash string, now time.Time) error
+	CountRecoveryCodes(userID int) (int, error)
+	DisableTOTP(userID int, now time.Time) error
+	InsertLoginAttempt(attempt *models.LoginAttempt) error
+	AllAccessTokens(userID int) ([]*models.AccessToken, error)
+	InsertAccessToken(token *models.AccessToken) error
+	DeleteAccessToken(userID, id int) error
+	UseAccessToken(tokenHash string, now time.Time) (*models.AccessToken, error)
+	UseUserIdentity(provider, subject string, now time.Time) (int, error)
+	InsertUserIdentity(identity *models.UserIdentity) error
+	InsertUserWithIdentity(user models.User, identity *models.UserIdentity) (int, error)
+	AllHouseholds(userID int) ([]*models.Household, error)
+	OneHousehold(id int) (*models.Household, error)
+	InsertHousehold(household *models.Household, ownerID int) (int, error)
+	UpdateHousehold(household *models.Household) error
+	DeleteHousehold(id int) error
+	HouseholdMember(householdID, userID int) (*models.HouseholdMember, error)
+	DefaultHouseholdMember(userID int) (*models.HouseholdMember, error)
+	AllHouseholdMembers(householdID int) ([]*models.HouseholdMember, error)
+	UpdateHouseholdMember(member *models.HouseholdMember) error
+	DeleteHouseholdMember(householdID, userID int) error
+	AllHouseholdInvites(householdID int, now time.Time) ([]*models.HouseholdInvite, error)
+	InsertHouseholdInvite(invite *models.HouseholdInvite) error
+	DeleteHouseholdInvite(householdID, id int) error
+	AcceptHouseholdInvite(tokenHash string, userID int, now time.Time) (*models.HouseholdMember, error)
+	DeleteExpiredHouseholdInvites(now time.Time) (int, error)
+	WithActor(actor models.AuditActor) DatabaseRepo
+	AuditHistory(entity string, id, householdID, userID int) ([]*models.AuditEntry, error)
 
 	// ----------------- NEPRECATED OLD CODE -----------------
 
@@ -28,53 +28,359 @@ CREATE TABLE public.users (
     last_name VARCHAR(255) NOT NULL,
     email VARCHAR(255) UNIQUE NOT NULL,
     password VARCHAR(255) NOT NULL,
-    created_at TIMESTAMP,
-    updated_at TIMESTAMP
+    base_currency CHAR(3) NOT NULL DEFAULT 'CAD',
+    email_verified_at TIMESTAMPTZ,
+    -- false for accounts created before addresses were verified, which are never locked out
+    email_verification_required BOOLEAN NOT NULL DEFAULT false,
+    totp_secret VARCHAR(64),
+    totp_enabled_at TIMESTAMPTZ,
+    totp_last_step BIGINT,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ
+);
+
+-- Create the recovery_codes table; one-time codes for signing in without the
+-- authenticator app, stored as hashes
+CREATE TABLE public.recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    code_hash CHAR(64) NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ,
+    UNIQUE (user_id, code_hash)
+);
+
+-- Create the user_identities table; accounts at external OpenID Connect providers that
+-- users sign in with
+CREATE TABLE public.user_identities (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    provider VARCHAR(64) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ,
+    last_login_at TIMESTAMPTZ,
+    UNIQUE (provider, subject)
+);
+
+-- Create the password_resets table; only a hash of each emailed token is stored
+CREATE TABLE public.password_resets (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ
+);
+
+-- Create the sessions table; each refresh token is stored as a hash, and every token
+-- rotated from the same login shares a family_id, so a reused token can revoke them all
+CREATE TABLE public.sessions (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    family_id VARCHAR(64) NOT NULL,
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    expires_at TIMESTAMPTZ NOT NULL,
+    rotated_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ
+);
+
+CREATE INDEX sessions_family_id_idx ON public.sessions (family_id);
+
+-- Create the access_tokens table; personal access tokens for scripts, stored as hashes,
+-- with their scopes separated by spaces
+CREATE TABLE public.access_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    scopes TEXT NOT NULL DEFAULT '',
+    expires_at TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ
+);
+
+-- Create the login_attempts table; an audit trail of every try at signing in
+CREATE TABLE public.login_attempts (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES public.users(id) ON DELETE SET NULL,
+    email VARCHAR(255) NOT NULL,
+    ip_address VARCHAR(45) NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    success BOOLEAN NOT NULL,
+    reason VARCHAR(32) NOT NULL,
+    created_at TIMESTAMPTZ
+);
+
+-- Create the login_limits table; recent failed sign ins and emailed links by address and
+-- by account, when LOGIN_LIMIT_STORE is postgres
+CREATE TABLE public.login_limits (
+    key VARCHAR(320) PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure TIMESTAMPTZ NOT NULL
+);
+
+-- Create the households table; a group of users who share their records, with the
+-- currency their summaries are converted into
+CREATE TABLE public.households (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    base_currency CHAR(3) NOT NULL DEFAULT 'CAD',
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ
+);
+
+-- Create the household_members table; each user's role in the households they belong to
+CREATE TABLE public.household_members (
+    household_id INTEGER NOT NULL REFERENCES public.households(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    role VARCHAR(10) NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    PRIMARY KEY (household_id, user_id)
+);
+
+CREATE INDEX household_members_user_id_idx ON public.household_members (user_id);
+
+-- Create the household_invites table; links to join a household, stored as hashes
+CREATE TABLE public.household_invites (
+    id SERIAL PRIMARY KEY,
+    household_id INTEGER NOT NULL REFERENCES public.households(id) ON DELETE CASCADE,
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    role VARCHAR(10) NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+    created_by INTEGER NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ
 );
 
 -- Create the sources table
 CREATE TABLE public.sources (
     id SERIAL PRIMARY KEY,
     user_id INTEGER NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    household_id INTEGER NOT NULL REFERENCES public.households(id) ON DELETE CASCADE,
     name VARCHAR(255) NOT NULL,
-    created_at TIMESTAMP,
-    updated_at TIMESTAMP
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ
+);
+
+-- Create the accounts table
+CREATE TABLE public.accounts (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    type VARCHAR(32) NOT NULL,
+    currency CHAR(3) NOT NULL,
+    opening_balance NUMERIC(12, 2) NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    UNIQUE (user_id, name)
 );
 
 -- Create the incomes table
 CREATE TABLE public.incomes (
     id SERIAL PRIMARY KEY,
     user_id INTEGER NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    household_id INTEGER NOT NULL REFERENCES public.households(id) ON DELETE CASCADE,
     amount NUMERIC(10, 2) NOT NULL CHECK (amount > 0),
+    currency CHAR(3) NOT NULL DEFAULT 'CAD',
     source_id INTEGER REFERENCES public.sources(id),
+    account_id INTEGER REFERENCES public.accounts(id) ON DELETE SET NULL,
     date DATE NOT NULL,
     description TEXT,
-    created_at TIMESTAMP,
-    updated_at TIMESTAMP
+    external_id VARCHAR(255),
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    UNIQUE (household_id, external_id)
 );
 
 -- Create the categories table
 CREATE TABLE public.categories (
     id SERIAL PRIMARY KEY,
     user_id INTEGER NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    household_id INTEGER NOT NULL REFERENCES public.households(id) ON DELETE CASCADE,
     name VARCHAR(255) NOT NULL,
-    created_at TIMESTAMP,
-    updated_at TIMESTAMP
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ
 );
 
 -- Create the expenses table
 CREATE TABLE public.expenses (
     id SERIAL PRIMARY KEY,
     user_id INTEGER NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    household_id INTEGER NOT NULL REFERENCES public.households(id) ON DELETE CASCADE,
     amount NUMERIC(10, 2) NOT NULL CHECK (amount > 0),
+    currency CHAR(3) NOT NULL DEFAULT 'CAD',
     category_id INTEGER REFERENCES public.categories(id),
+    account_id INTEGER REFERENCES public.accounts(id) ON DELETE SET NULL,
     date DATE NOT NULL,
     description TEXT,
     payment_method VARCHAR(255),
-    created_at TIMESTAMP,
-    updated_at TIMESTAMP
+    external_id VARCHAR(255),
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    UNIQUE (household_id, external_id)
 );
 
+-- Create the budgets table; one monthly limit per expense category
+CREATE TABLE public.budgets (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    household_id INTEGER NOT NULL REFERENCES public.households(id) ON DELETE CASCADE,
+    category_id INTEGER NOT NULL REFERENCES public.categories(id) ON DELETE CASCADE,
+    amount NUMERIC(10, 2) NOT NULL CHECK (amount > 0),
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    UNIQUE (household_id, category_id)
+);
+
+-- Create the transfers table; a transfer moves money between two accounts and is
+-- neither income nor expense
+CREATE TABLE public.transfers (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    from_account_id INTEGER NOT NULL REFERENCES public.accounts(id),
+    to_account_id INTEGER NOT NULL REFERENCES public.accounts(id),
+    amount NUMERIC(10, 2) NOT NULL CHECK (amount > 0),
+    date DATE NOT NULL,
+    description TEXT,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    CHECK (from_account_id <> to_account_id)
+);
+
+-- Create the goals table; a savings target, optionally linked to an account or an
+-- expense category
+CREATE TABLE public.goals (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    household_id INTEGER NOT NULL REFERENCES public.households(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    target_amount NUMERIC(10, 2) NOT NULL CHECK (target_amount > 0),
+    target_date DATE NOT NULL,
+    account_id INTEGER REFERENCES public.accounts(id) ON DELETE SET NULL,
+    category_id INTEGER REFERENCES public.categories(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ
+);
+
+-- Create the goal_contributions table; money set aside towards a goal
+CREATE TABLE public.goal_contributions (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    goal_id INTEGER NOT NULL REFERENCES public.goals(id) ON DELETE CASCADE,
+    amount NUMERIC(10, 2) NOT NULL CHECK (amount > 0),
+    date DATE NOT NULL,
+    description TEXT,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ
+);
+
+-- Create the recurring_templates table; an income or expense posted on a schedule
+CREATE TABLE public.recurring_templates (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    household_id INTEGER NOT NULL REFERENCES public.households(id) ON DELETE CASCADE,
+    kind VARCHAR(10) NOT NULL CHECK (kind IN ('income', 'expense')),
+    amount NUMERIC(10, 2) NOT NULL CHECK (amount > 0),
+    currency CHAR(3),
+    source_id INTEGER REFERENCES public.sources(id),
+    category_id INTEGER REFERENCES public.categories(id),
+    account_id INTEGER REFERENCES public.accounts(id) ON DELETE SET NULL,
+    description TEXT,
+    payment_method VARCHAR(255),
+    frequency VARCHAR(20) NOT NULL,
+    rule TEXT NOT NULL,
+    start_date DATE NOT NULL,
+    end_date DATE,
+    posted_through DATE,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ
+);
+
+-- Create the recurring_occurrences table; one row per skipped, overridden or posted
+-- occurrence of a template, so each occurrence is posted at most once
+CREATE TABLE public.recurring_occurrences (
+    template_id INTEGER NOT NULL REFERENCES public.recurring_templates(id) ON DELETE CASCADE,
+    household_id INTEGER NOT NULL REFERENCES public.households(id) ON DELETE CASCADE,
+    date DATE NOT NULL,
+    status VARCHAR(10) NOT NULL CHECK (status IN ('override', 'skipped', 'posted')),
+    amount NUMERIC(10, 2) CHECK (amount > 0),
+    description TEXT,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    PRIMARY KEY (template_id, date)
+);
+
+-- Create the import_mappings table; how to read one bank's CSV statements
+CREATE TABLE public.import_mappings (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    has_header BOOLEAN NOT NULL DEFAULT TRUE,
+    delimiter VARCHAR(1) NOT NULL DEFAULT '',
+    date_column VARCHAR(255) NOT NULL,
+    date_format VARCHAR(64) NOT NULL,
+    amount_sign VARCHAR(20) NOT NULL,
+    amount_column VARCHAR(255) NOT NULL DEFAULT '',
+    debit_column VARCHAR(255) NOT NULL DEFAULT '',
+    credit_column VARCHAR(255) NOT NULL DEFAULT '',
+    description_column VARCHAR(255) NOT NULL DEFAULT '',
+    category_column VARCHAR(255) NOT NULL DEFAULT '',
+    source_column VARCHAR(255) NOT NULL DEFAULT '',
+    default_category VARCHAR(255) NOT NULL DEFAULT '',
+    default_source VARCHAR(255) NOT NULL DEFAULT '',
+    currency VARCHAR(3) NOT NULL DEFAULT '',
+    account_id INTEGER REFERENCES public.accounts(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ
+);
+
+-- Create the exchange_rates table; one unit of base is worth rate units of quote on date
+CREATE TABLE public.exchange_rates (
+    date DATE NOT NULL,
+    base CHAR(3) NOT NULL,
+    quote CHAR(3) NOT NULL,
+    rate NUMERIC(18, 8) NOT NULL CHECK (rate > 0),
+    PRIMARY KEY (base, quote, date)
+);
+
+-- Create the audit_log table; every change to a financial record, with the record before
+-- and after it. It has no foreign keys, so the history outlives what it describes, and
+-- a trigger keeps it append-only
+CREATE TABLE public.audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    actor_id INTEGER,
+    access_token_id INTEGER,
+    household_id INTEGER,
+    owner_id INTEGER,
+    entity VARCHAR(32) NOT NULL,
+    entity_id INTEGER NOT NULL,
+    action VARCHAR(8) NOT NULL CHECK (action IN ('insert', 'update', 'delete')),
+    before JSONB,
+    after JSONB,
+    ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    request_id VARCHAR(64) NOT NULL DEFAULT '',
+    method VARCHAR(8) NOT NULL DEFAULT '',
+    path TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX audit_log_entity_idx ON public.audit_log (entity, entity_id, id);
+
+CREATE FUNCTION public.audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON public.audit_log
+    FOR EACH ROW EXECUTE FUNCTION public.audit_log_append_only();
+
 
 --
 -- PostgreSQL database dump complete
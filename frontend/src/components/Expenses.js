@@ -28,7 +28,7 @@ const Expenses = () => {
       .then((response) => response.json())
       .then((data) => {
         console.log(data);
-        setExpenses(data);
+        setExpenses(data.expenses);
       })
       .catch(err => {
         console.log(err);
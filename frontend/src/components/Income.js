@@ -28,7 +28,7 @@ const Income = () => {
       .then((response) => response.json())
       .then((data) => {
         console.log(data);
-        setPaycheques(data);
+        setPaycheques(data.incomes);
       })
       .catch(err => {
         console.log(err);